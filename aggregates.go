@@ -0,0 +1,167 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// GroupBy groups the rows of the query by columns. The selected columns are usually
+// the grouped columns and aggregates, scanned into a struct with Into or As, or into
+// the fields of the table with matching column names:
+//
+//	M.Query(Post{}, "author_id, count(*) AS score").GroupBy("author_id").Having("count(*) >", 10).Do()
+func (q *Query) GroupBy(columns ...string) *Query {
+	q.groupBy = strings.Join(columns, ", ")
+	return q
+}
+
+// Having adds a condition on the groups of a query with GroupBy, like Where does for
+// rows.
+func (q *Query) Having(condition string, binding interface{}) *Query {
+	q.having = append(q.having, comparison(condition)+" ?")
+	q.havingArgs = append(q.havingArgs, binding)
+	return q
+}
+
+// Into runs the query and appends the returned rows to the slice pointed to by dest,
+// which can be a slice of structs or of struct pointers of any type, such as the rows
+// of a report. The selected columns are matched to the fields of the struct by their db
+// tags, and columns without a field are ignored:
+//
+//	var scores []struct {
+//		AuthorID int64 `db:"author_id"`
+//		Posts    int64 `db:"posts"`
+//		Words    int64 `db:"words"`
+//	}
+//	err := M.Query(Post{}, "author_id, count(*) AS posts, sum(words) AS words").GroupBy("author_id").Into(&scores)
+func (q *Query) Into(dest interface{}) error {
+	return q.IntoContext(context.Background(), dest)
+}
+
+// IntoContext is like Into but runs the query with ctx.
+func (q *Query) IntoContext(ctx context.Context, dest interface{}) (err error) {
+	defer q.m.recover(&err)
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("m: Into expects a pointer to a slice, got %T", dest)
+	}
+	slice := v.Elem()
+	typ := slice.Type().Elem()
+	pointers := typ.Kind() == reflect.Ptr
+	if pointers {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("m: Into expects a slice of structs or struct pointers, got %T", dest)
+	}
+
+	r := *q
+	r.as = typ
+	rows, err := r.DoContext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		if !pointers {
+			v = v.Elem()
+		}
+		slice.Set(reflect.Append(slice, v))
+	}
+	return nil
+}
+
+// args returns the bindings of q in the order of their placeholders.
+func (q *Query) args() []interface{} {
+	if len(q.havingArgs) == 0 {
+		return q.bindings
+	}
+	return append(q.bindings[:len(q.bindings):len(q.bindings)], q.havingArgs...)
+}
+
+// Count returns the number of rows matching the query, or the number of groups if
+// GroupBy was called. The order, limit and offset of the query are ignored.
+func (q *Query) Count() (int64, error) {
+	return q.CountContext(context.Background())
+}
+
+// CountContext is like Count but runs the query with ctx.
+func (q *Query) CountContext(ctx context.Context) (int64, error) {
+	var n int64
+	err := q.aggregate(ctx, "count(*)", &n)
+	return n, err
+}
+
+// Sum returns the sum of column over the rows matching the query, or zero if no rows
+// match. The order, limit and offset of the query are ignored.
+func (q *Query) Sum(column string) (float64, error) {
+	return q.SumContext(context.Background(), column)
+}
+
+// SumContext is like Sum but runs the query with ctx.
+func (q *Query) SumContext(ctx context.Context, column string) (float64, error) {
+	var sum sql.NullFloat64
+	err := q.aggregate(ctx, "sum("+column+")", &sum)
+	return sum.Float64, err
+}
+
+// Avg returns the average of column over the rows matching the query, or zero if no
+// rows match. The order, limit and offset of the query are ignored.
+func (q *Query) Avg(column string) (float64, error) {
+	return q.AvgContext(context.Background(), column)
+}
+
+// AvgContext is like Avg but runs the query with ctx.
+func (q *Query) AvgContext(ctx context.Context, column string) (float64, error) {
+	var avg sql.NullFloat64
+	err := q.aggregate(ctx, "avg("+column+")", &avg)
+	return avg.Float64, err
+}
+
+// aggregate scans the value of the aggregate expr over the rows of q into dest.
+func (q *Query) aggregate(ctx context.Context, expr string, dest interface{}) (err error) {
+	defer q.m.recover(&err)
+	if q.err != nil {
+		return q.err
+	}
+	a := *q
+	a.t = q.route()
+	a.columns = expr
+	a.order, a.limit, a.offset, a.sample, a.preload, a.as = "", 0, 0, 0, nil, nil
+	query := a.String()
+	if q.groupBy != "" {
+		if expr != "count(*)" {
+			return fmt.Errorf("m: aggregates of grouped queries are selected as columns of Do")
+		}
+		if q.m.Type == Cassandra {
+			return fmt.Errorf("m: counting the groups of a query is not supported on Cassandra")
+		}
+		// count the groups rather than the rows of each group, GROUPS is reserved on MySQL
+		a.columns = "1"
+		query = "SELECT count(*) FROM (" + a.String() + ") AS m_groups"
+	}
+
+	s := &Statement{Op: OpSelect, Table: a.t.Name, SQL: query, Args: a.args(), Idempotent: true}
+	return q.m.run(ctx, s, a.t.ReadTimeout, func(ctx context.Context, qr querier) error {
+		rows, done, err := q.m.queryRows(ctx, s, qr)
+		if err != nil {
+			done()
+			return err
+		}
+		defer done()
+		defer rows.Close()
+		if !rows.Next() {
+			if err = rows.Err(); err != nil {
+				return err
+			}
+			return sql.ErrNoRows
+		}
+		if err = rows.Scan(dest); err != nil {
+			return err
+		}
+		return rows.Close()
+	})
+}
@@ -0,0 +1,202 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backfill updates every row of a table in batches of primary key ranges, for example
+// to populate a new column. Batches can be throttled so that the backfill doesn't
+// overload the database or its replicas, and a backfill that is interrupted can be
+// resumed from its last checkpoint.
+//
+//	b := M.NewBackfill("slug = lower(name)")
+//	b.Rate = 10
+//	err := b.Run(User{})
+type Backfill struct {
+	BatchSize int
+
+	// Set is the SET clause of an UPDATE run for each batch, with placeholders for Args
+	// numbered from the first. Fn is called instead if Set is empty.
+	Set  string
+	Args []interface{}
+
+	// Fn is called with the rows of each batch and a Mapping for the transaction the
+	// batch runs in.
+	Fn func(m *Mapping, rows []interface{}) error
+
+	// Rate limits the number of batches per second if it is positive.
+	Rate float64
+
+	// Lag, if not nil, returns the replication lag of the database. While it is more
+	// than MaxLag the backfill waits before the next batch.
+	Lag    func(context.Context) (time.Duration, error)
+	MaxLag time.Duration
+
+	// Progress, if not nil, is called after each batch with a checkpoint the backfill
+	// can be resumed from. Returning an error stops the backfill and is returned.
+	Progress func(Checkpoint) error
+
+	// Resume, if not nil, resumes the backfill after the given checkpoint.
+	Resume *Checkpoint
+
+	m    *Mapping
+	step func(ctx context.Context, t *tableMap, keys []string, from, to []interface{}) error
+}
+
+// DefaultBackfillBatch is the number of rows updated at a time by a Backfill when
+// BatchSize is not positive.
+const DefaultBackfillBatch = 1000
+
+// lagPoll is how long a Backfill waits before checking the replication lag again.
+const lagPoll = time.Second
+
+// NewBackfill returns a Backfill of the table for thing that sets set, see Backfill.Set.
+func (m *Mapping) NewBackfill(set string, args ...interface{}) *Backfill {
+	return &Backfill{Set: set, Args: args, m: m}
+}
+
+// NewBackfillFunc returns a Backfill that calls fn with each batch, see Backfill.Fn.
+func (m *Mapping) NewBackfillFunc(fn func(m *Mapping, rows []interface{}) error) *Backfill {
+	return &Backfill{Fn: fn, m: m}
+}
+
+// Run runs the backfill on the table for thing.
+func (b *Backfill) Run(thing interface{}) error {
+	return b.RunContext(context.Background(), thing)
+}
+
+// RunContext is like Run but runs the statements with ctx.
+func (b *Backfill) RunContext(ctx context.Context, thing interface{}) error {
+	// a replica could miss rows that were just inserted
+	ctx = WithPrimary(ctx)
+	m := b.m
+	t, err := m.table(thing)
+	if err != nil {
+		return err
+	}
+	if err := t.modifiable(); err != nil && b.step == nil {
+		return err
+	}
+	if m.Type == Cassandra {
+		return fmt.Errorf("m: backfills are not supported on Cassandra")
+	}
+	step := b.step
+	if step == nil && b.Set != "" {
+		step = b.update
+	}
+	if step == nil && b.Fn == nil {
+		return fmt.Errorf("m: backfill of %s has neither Set nor Fn", t.Name)
+	}
+	keys := t.primaryKey()
+	if len(keys) == 0 {
+		return ErrNoPrimaryKey
+	}
+	batch := b.BatchSize
+	if batch <= 0 {
+		batch = DefaultBackfillBatch
+	}
+	checkpoint := Checkpoint{Table: t.Name}
+	if b.Resume != nil {
+		if b.Resume.Table != t.Name {
+			return fmt.Errorf("m: checkpoint of table %s can't resume a backfill of %s", b.Resume.Table, t.Name)
+		}
+		checkpoint = *b.Resume
+	}
+	var bucket *TokenBucket
+	if b.Rate > 0 {
+		bucket = NewTokenBucket(b.Rate, 1)
+	}
+	if b.Set != "" {
+		defer m.cached(t, func(c *TableCache) { c.Purge() })
+	}
+
+	for {
+		if bucket != nil {
+			if err := bucket.Take(ctx); err != nil {
+				return err
+			}
+		}
+		if err := b.waitForLag(ctx); err != nil {
+			return err
+		}
+
+		columns := "*"
+		if step != nil {
+			columns = quoteIdents(keys, m.Type)
+		}
+		q := getBuffer()
+		fmt.Fprintf(q, "SELECT %s FROM %s", columns, quoteIdent(t.Name, m.Type))
+		if checkpoint.Key != nil {
+			fmt.Fprintf(q, " WHERE (%s) > (", quoteIdents(keys, m.Type))
+			writePlaceholders(q, 0, len(keys), m.Type)
+			q.WriteByte(')')
+		}
+		fmt.Fprintf(q, " ORDER BY %s LIMIT %d", quoteIdents(keys, m.Type), batch)
+		rows, err := m.doSelect(ctx, t, putBuffer(q), checkpoint.Key...)
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+
+		_, last := keysForUpdate(rows[len(rows)-1], t)
+		if step != nil {
+			err = step(ctx, t, keys, checkpoint.Key, last)
+		} else {
+			err = m.inTx(ctx, func(m *Mapping) error { return b.Fn(m, rows) })
+		}
+		if err != nil {
+			return err
+		}
+
+		checkpoint.Rows += int64(len(rows))
+		checkpoint.Key = last
+		if b.Progress != nil {
+			if err = b.Progress(checkpoint); err != nil {
+				return err
+			}
+		}
+		if len(rows) < batch {
+			return nil
+		}
+	}
+}
+
+// update runs the UPDATE of the rows of t with keys after from, if it isn't nil, up to
+// and including to.
+func (b *Backfill) update(ctx context.Context, t *tableMap, keys []string, from, to []interface{}) error {
+	dbt := b.m.Type
+	args := append(append([]interface{}{}, b.Args...), to...)
+	q := getBuffer()
+	fmt.Fprintf(q, "UPDATE %s SET %s WHERE (%s) <= (", quoteIdent(t.Name, dbt), b.Set, quoteIdents(keys, dbt))
+	writePlaceholders(q, len(b.Args), len(keys), dbt)
+	q.WriteByte(')')
+	if from != nil {
+		fmt.Fprintf(q, " AND (%s) > (", quoteIdents(keys, dbt))
+		writePlaceholders(q, len(args), len(keys), dbt)
+		q.WriteByte(')')
+		args = append(args, from...)
+	}
+	_, err := b.m.exec(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: putBuffer(q), Args: args})
+	return err
+}
+
+// waitForLag waits until the replication lag is at most MaxLag.
+func (b *Backfill) waitForLag(ctx context.Context) error {
+	if b.Lag == nil {
+		return nil
+	}
+	for {
+		lag, err := b.Lag(ctx)
+		if err != nil || lag <= b.MaxLag {
+			return err
+		}
+		timer := time.NewTimer(lagPoll)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package m
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// BindingError is returned instead of executing a statement whose number of bindings
+// doesn't match its placeholders, which drivers report less clearly or not at all.
+type BindingError struct {
+	Statement    *Statement
+	Placeholders []int // byte offsets of the placeholders in the SQL of the statement
+	Bindings     int
+}
+
+func (e *BindingError) Error() string {
+	n := len(e.Placeholders)
+	if e.Bindings > n {
+		return fmt.Sprintf("m: %d bindings for %d placeholders in %q", e.Bindings, n, e.Statement.SQL)
+	}
+	missing := make([]string, 0, n-e.Bindings)
+	for _, at := range e.Placeholders[e.Bindings:] {
+		missing = append(missing, fmt.Sprint(at))
+	}
+	return fmt.Sprintf("m: %d bindings for %d placeholders in %q, no binding for the placeholders at offsets %s",
+		e.Bindings, n, e.Statement.SQL, strings.Join(missing, ", "))
+}
+
+// checkBindings returns a *BindingError if the bindings of s don't match its
+// placeholders. Statements with named arguments aren't checked.
+func (m *Mapping) checkBindings(s *Statement) error {
+	for _, arg := range s.Args {
+		if _, ok := arg.(sql.NamedArg); ok {
+			return nil
+		}
+	}
+	placeholders := findPlaceholders(s.SQL, m.Type)
+	if len(placeholders) != len(s.Args) {
+		return &BindingError{s, placeholders, len(s.Args)}
+	}
+	return nil
+}
+
+// findPlaceholders returns the offsets of the placeholders of query, ordered by their
+// number on PostgreSQL. Literals, quoted identifiers and comments are skipped.
+func findPlaceholders(query string, dbt DBType) []int {
+	var at []int
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, c, dbt)
+		case c == '-' && strings.HasPrefix(query[i:], "--") || c == '#' && dbt == MySQL:
+			i = skipUntil(query, i, "\n")
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipUntil(query, i+2, "*/") + 1
+		case c == '?' && dbt != PostgreSQL:
+			at = append(at, i)
+		case c == '$' && dbt == PostgreSQL:
+			j := i + 1
+			n := 0
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				n = n*10 + int(query[j]-'0')
+				j++
+			}
+			if j == i+1 {
+				i = skipDollarQuoted(query, i)
+				continue
+			}
+			// $1 can be used more than once, its offset is the first one
+			for len(at) < n {
+				at = append(at, -1)
+			}
+			if at[n-1] < 0 {
+				at[n-1] = i
+			}
+			i = j - 1
+		}
+	}
+	return at
+}
+
+// skipQuoted returns the offset of the quote closing the literal or identifier starting
+// at i. Doubled quotes and, in string literals on MySQL, backslashes escape quotes.
+func skipQuoted(query string, i int, quote byte, dbt DBType) int {
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if quote == '\'' && dbt == MySQL {
+				j++
+			}
+		case quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(query)
+}
+
+// skipUntil returns the offset of the first byte of end after i, or the end of query.
+func skipUntil(query string, i int, end string) int {
+	if j := strings.Index(query[i:], end); j >= 0 {
+		return i + j
+	}
+	return len(query)
+}
+
+// skipDollarQuoted returns the offset of the end of the PostgreSQL dollar-quoted string
+// starting at i, or i if there is none.
+func skipDollarQuoted(query string, i int) int {
+	j := i + 1
+	for j < len(query) && (query[j] == '_' || query[j] >= 'a' && query[j] <= 'z' || query[j] >= 'A' && query[j] <= 'Z' || query[j] >= '0' && query[j] <= '9') {
+		j++
+	}
+	if j == len(query) || query[j] != '$' {
+		return i
+	}
+	tag := query[i : j+1]
+	return skipUntil(query, j+1, tag) + len(tag) - 1
+}
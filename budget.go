@@ -0,0 +1,113 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Budget limits the number of statements and the total database time of a single
+// request, catching N+1 query regressions. A Budget is attached to a context with
+// WithBudget and is charged by every statement executed with that context.
+type Budget struct {
+	MaxStatements int           // zero means no limit
+	MaxDuration   time.Duration // zero means no limit
+
+	// Report is called the first time the budget is exceeded. If it is nil the error,
+	// which lists the call sites of the statements, is logged with the log package.
+	Report func(*BudgetError)
+
+	mu         sync.Mutex
+	statements int
+	elapsed    time.Duration
+	callers    []string
+	reported   bool
+}
+
+// BudgetError is returned when a statement would exceed a Budget.
+type BudgetError struct {
+	Statements int
+	Elapsed    time.Duration
+	Callers    []string // call site of each statement executed within the budget
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("m: query budget exceeded after %d statements in %v, called from:\n\t%s", e.Statements, e.Elapsed, strings.Join(e.Callers, "\n\t"))
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a copy of ctx that carries b.
+func WithBudget(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// Used returns the number of statements executed and the database time spent so far.
+func (b *Budget) Used() (int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.statements, b.elapsed
+}
+
+// chargeBudget charges a statement to the budget in ctx, if there is one. The returned
+// function must be called once the statement has finished.
+func chargeBudget(ctx context.Context) (done func(), err error) {
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	if b == nil {
+		return func() {}, nil
+	}
+
+	caller := callSite()
+	b.mu.Lock()
+	if (b.MaxStatements > 0 && b.statements >= b.MaxStatements) || (b.MaxDuration > 0 && b.elapsed >= b.MaxDuration) {
+		err := &BudgetError{
+			Statements: b.statements,
+			Elapsed:    b.elapsed,
+			Callers:    append(append([]string(nil), b.callers...), caller),
+		}
+		report := !b.reported
+		b.reported = true
+		b.mu.Unlock()
+
+		if report {
+			if b.Report != nil {
+				b.Report(err)
+			} else {
+				log.Print(err)
+			}
+		}
+		return nil, err
+	}
+	b.statements++
+	b.callers = append(b.callers, caller)
+	b.mu.Unlock()
+
+	start := time.Now()
+	return func() {
+		b.mu.Lock()
+		b.elapsed += time.Since(start)
+		b.mu.Unlock()
+	}, nil
+}
+
+var pkgPath = reflect.TypeOf(Mapping{}).PkgPath()
+
+// callSite returns the location of the first caller outside of this package.
+func callSite() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPath+".") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
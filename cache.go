@@ -0,0 +1,249 @@
+package m
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TableCache is an in-process LRU cache of the rows of one table, keyed by primary key.
+// Rows inserted through the Mapping that owns the cache are written through to it, and
+// rows updated or deleted through it are removed, as updates may only set some of the
+// columns. It only goes stale when the table is modified in other ways, for at most
+// the TTL.
+type TableCache struct {
+	m       *Mapping
+	t       *tableMap
+	size    int
+	ttl     time.Duration
+	missTTL time.Duration
+
+	mu    sync.Mutex
+	lru   *list.List // of *cacheEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   reflect.Value // a struct, not a pointer to one
+	missing bool          // the row doesn't exist
+	expires time.Time
+}
+
+// tableCaches are the caches of a Mapping by table type.
+type tableCaches struct {
+	mu     sync.RWMutex
+	byType map[reflect.Type]*TableCache
+}
+
+// CacheTable adds a write-through cache of up to size rows of the table for thing to
+// m. Rows are reloaded from the database after ttl, a zero ttl means rows never expire.
+// The first cache should be added before m is used concurrently.
+//
+//	users := M.CacheTable(User{}, 10000, time.Minute)
+//	u, err := users.Get(id)
+func (m *Mapping) CacheTable(thing interface{}, size int, ttl time.Duration) *TableCache {
+	t := m.lookupTable(thing)
+	c := &TableCache{m: m, t: t, size: size, ttl: ttl, lru: list.New(), items: make(map[string]*list.Element)}
+	if m.caches == nil {
+		m.caches = &tableCaches{byType: make(map[reflect.Type]*TableCache)}
+	}
+	m.caches.mu.Lock()
+	m.caches.byType[t.Type] = c
+	m.caches.mu.Unlock()
+	return c
+}
+
+// CacheMisses makes c remember for ttl that a row doesn't exist, so that repeated
+// lookups of keys that don't exist don't all go to the database. Rows inserted through
+// the Mapping replace the remembered miss immediately.
+func (c *TableCache) CacheMisses(ttl time.Duration) {
+	c.mu.Lock()
+	c.missTTL = ttl
+	c.mu.Unlock()
+}
+
+// Get returns the row with the primary key values keys, in the order the pk columns are
+// declared, from the cache or from the database. It returns nil if there is no such row.
+func (c *TableCache) Get(keys ...interface{}) (interface{}, error) {
+	key, err := c.key(keys)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := c.get(key); ok {
+		return v, nil
+	}
+
+	res, err := c.m.get(context.Background(), c.t, keys)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		c.putMissing(key)
+		return nil, nil
+	}
+	c.put(key, res)
+	return res, nil
+}
+
+// Invalidate removes the row with the primary key values keys from the cache.
+func (c *TableCache) Invalidate(keys ...interface{}) {
+	if key, err := c.key(keys); err == nil {
+		c.remove(key)
+	}
+}
+
+// Purge removes every row from the cache.
+func (c *TableCache) Purge() {
+	c.mu.Lock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+}
+
+func (c *TableCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	if entry.missing {
+		return nil, true
+	}
+
+	// callers get their own copy, so that modifying it doesn't modify the cache
+	v := reflect.New(c.t.Type)
+	v.Elem().Set(entry.value)
+	return v.Interface(), true
+}
+
+func (c *TableCache) put(key string, thing interface{}) {
+	value := reflect.New(c.t.Type).Elem()
+	value.Set(reflect.Indirect(reflect.ValueOf(thing)))
+	entry := &cacheEntry{key: key, value: value}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.mu.Lock()
+	c.add(entry)
+	c.mu.Unlock()
+}
+
+func (c *TableCache) putMissing(key string) {
+	c.mu.Lock()
+	if c.missTTL > 0 {
+		c.add(&cacheEntry{key: key, missing: true, expires: time.Now().Add(c.missTTL)})
+	}
+	c.mu.Unlock()
+}
+
+// add adds entry to the cache, c.mu must be held.
+func (c *TableCache) add(entry *cacheEntry) {
+	key := entry.key
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.size > 0 && c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *TableCache) remove(key string) {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.lru.Remove(e)
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+}
+
+// key returns the cache key for primary key values, which are converted to the types of
+// the pk fields so that Get(1) and a struct with an int64 key use the same entry.
+func (c *TableCache) key(values []interface{}) (string, error) {
+	var b strings.Builder
+	n := 0
+	for _, column := range c.t.Columns {
+		if !column.PrimaryKey {
+			continue
+		}
+		if n >= len(values) {
+			return "", fmt.Errorf("m: %d primary key values given for %s, expected more", len(values), c.t.Name)
+		}
+		fieldType := c.t.Type.FieldByIndex(column.Field).Type
+		if !assignable(values[n], fieldType) {
+			return "", fmt.Errorf("m: can't use %T as primary key %s of %s", values[n], column.Name, c.t.Name)
+		}
+		v := reflect.New(fieldType).Elem()
+		assign(v, values[n])
+		fmt.Fprintf(&b, "%v\x00", reflect.Indirect(v).Interface())
+		n++
+	}
+	if n != len(values) {
+		return "", fmt.Errorf("m: %d primary key values given for %s, expected %d", len(values), c.t.Name, n)
+	}
+	return b.String(), nil
+}
+
+// thingKey returns the cache key for the primary key of thing.
+func (c *TableCache) thingKey(thing interface{}) (string, error) {
+	_, values := keysForUpdate(thing, c.t)
+	return c.key(values)
+}
+
+// putRow caches thing, which was inserted as a whole. If its key can't be made the
+// cache is purged, as a miss of the key could be remembered.
+func (c *TableCache) putRow(thing interface{}) {
+	key, err := c.thingKey(thing)
+	if err != nil {
+		c.Purge()
+		return
+	}
+	c.put(key, thing)
+}
+
+// removeRow removes the row of thing, which was updated or deleted, so that it is
+// reloaded from the database. If its key can't be made the cache is purged.
+func (c *TableCache) removeRow(thing interface{}) {
+	key, err := c.thingKey(thing)
+	if err != nil {
+		c.Purge()
+		return
+	}
+	c.remove(key)
+}
+
+// cached calls fn with the cache for t, if m has one. In a transaction fn is only called
+// once the transaction has been committed.
+func (m *Mapping) cached(t *tableMap, fn func(*TableCache)) {
+	if m.caches == nil {
+		return
+	}
+	m.caches.mu.RLock()
+	c := m.caches.byType[t.Type]
+	m.caches.mu.RUnlock()
+	if c == nil {
+		return
+	}
+	if m.tx != nil {
+		m.tx.AfterCommit(func() { fn(c) })
+		return
+	}
+	fn(c)
+}
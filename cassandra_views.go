@@ -0,0 +1,106 @@
+package m
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cassandra can only efficiently query a table by its partition key, or by columns with a
+// secondary index. To query the same rows by other columns they are denormalized into
+// materialized views with different partition keys, and Query routes each query to the
+// table or view whose partition key is constrained by its conditions.
+
+type cassandraView struct {
+	PartitionKey []string
+	table        *tableMap // copy of the base table named after the view
+}
+
+// SetPartitionKey sets the partition key columns of the Cassandra table for thing, which
+// default to the columns tagged pk.
+func (m *Mapping) SetPartitionKey(thing interface{}, columns ...string) {
+	t := m.lookupTable(thing)
+	t.checkColumns(columns)
+	t.PartitionKey = columns
+}
+
+// AddCassandraView adds a materialized view of the Cassandra table for thing that is
+// partitioned by partitionKey. Queries with equality conditions on every partition key
+// column of the view, but not on the partition key of the table, select from the view.
+//
+//	M.AddCassandraView(Post{}, "posts_by_author", "author_id")
+//	M.Query(Post{}, "*").Where("author_id", id).Do() // SELECT * FROM posts_by_author ...
+func (m *Mapping) AddCassandraView(thing interface{}, view string, partitionKey ...string) {
+	t := m.lookupTable(thing)
+	t.checkColumns(partitionKey)
+	vt := *t
+	vt.Name = intern(view)
+	vt.Views = nil
+	vt.View = true
+	t.Views = append(t.Views, &cassandraView{PartitionKey: partitionKey, table: &vt})
+}
+
+// AddIndex records that column of the Cassandra table for thing has a secondary index,
+// which is created with the table. Queries with an equality condition on it that don't
+// match a view select from the table itself.
+func (m *Mapping) AddIndex(thing interface{}, column string) {
+	t := m.lookupTable(thing)
+	t.checkColumns([]string{column})
+	t.Indexes = append(t.Indexes, column)
+}
+
+func (t *tableMap) checkColumns(columns []string) {
+	for _, c := range columns {
+		if t.column(c) == nil {
+			panic(fmt.Sprintf("Unknown column %s for type: %v", c, t.Type))
+		}
+	}
+}
+
+// route returns the table or view q should select from.
+func (q *Query) route() *tableMap {
+	if len(q.t.Views) == 0 || len(q.keyed) == 0 {
+		return q.t
+	}
+	if q.constrains(q.t.partitionKey()) {
+		return q.t
+	}
+	for _, v := range q.t.Views {
+		if q.constrains(v.PartitionKey) {
+			return v.table
+		}
+	}
+	return q.t
+}
+
+// constrains reports whether q has equality conditions on all columns.
+func (q *Query) constrains(columns []string) bool {
+	if len(columns) == 0 {
+		return false
+	}
+outer:
+	for _, c := range columns {
+		for _, k := range q.keyed {
+			if k == c {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (t *tableMap) partitionKey() []string {
+	if len(t.PartitionKey) > 0 {
+		return t.PartitionKey
+	}
+	return t.primaryKey()
+}
+
+// keyColumn returns the column of condition if it is an equality condition.
+func keyColumn(condition string) (string, bool) {
+	fields := strings.Fields(condition)
+	if len(fields) == 2 && fields[1] == "=" {
+		return fields[0], true
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package m
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec marshals the values of fields tagged serialize. Fields are serialized as JSON
+// unless their tag names a codec registered with RegisterCodec, or SetCodec changes
+// the codec of the Mapping:
+//
+//	Payload Payload `db:"payload,serialize=gob"`
+//
+// Values of codecs other than JSON are stored as bytes, in blob columns.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{"json": jsonCodec{}, "gob": gobCodec{}}
+)
+
+// RegisterCodec makes a codec available by name to the serialize tag and SetCodec.
+// Codecs have to be registered before the tables using them are added, it panics if
+// the name is already taken.
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if c == nil {
+		panic("RegisterCodec codec is nil")
+	}
+	if _, dup := codecs[name]; dup {
+		panic("RegisterCodec called twice for codec " + name)
+	}
+	codecs[name] = c
+}
+
+// SetCodec sets the codec of the serialized fields of the tables of m whose tag doesn't
+// name a codec.
+func (m *Mapping) SetCodec(name string) {
+	codec := lookupCodec(name)
+	m.registry.codec = name
+	for _, t := range m.registry.tables {
+		for _, c := range t.Columns {
+			if c.Serialize && c.CodecName == "" {
+				c.Codec = codec
+			}
+		}
+	}
+}
+
+func lookupCodec(name string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		panic(fmt.Sprintf("Unknown codec %s, see RegisterCodec", name))
+	}
+	return c
+}
+
+func registeredCodec(name string) bool {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	_, ok := codecs[name]
+	return ok
+}
+
+// setCodecs sets the codecs of the serialized columns, codec is the name of the codec
+// of the columns whose tag doesn't name one.
+func setCodecs(columns []*columnMap, codec string) {
+	for _, c := range columns {
+		switch {
+		case !c.Serialize:
+		case c.CodecName != "":
+			c.Codec = lookupCodec(c.CodecName)
+		case codec != "":
+			c.Codec = lookupCodec(codec)
+		default:
+			c.Codec = jsonCodec{}
+		}
+	}
+}
+
+// binary reports whether the values of c are stored as bytes rather than text.
+func (c *columnMap) binary() bool {
+	_, text := c.Codec.(jsonCodec)
+	return c.Serialize && !text
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
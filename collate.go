@@ -0,0 +1,47 @@
+package m
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Text columns can be tagged with their collation and, on MySQL, their character set,
+// which are used by CreateTables and WhereEqualFold:
+//
+//	Email string `db:"email,collate=utf8mb4_0900_ai_ci,charset=utf8mb4"`
+//	Name  string `db:"name,collate=NOCASE"` // SQLite
+
+// setOption sets the tag option key to value.
+func (c *columnMap) setOption(key, value string) {
+	switch key {
+	case "collate":
+		c.Collate = value
+	case "charset":
+		c.Charset = value
+	case "serialize":
+		c.Serialize, c.CodecName = true, value
+	case "default":
+		c.Default = value
+	default:
+		panic(fmt.Sprintf("Unknown option %s for column %s", key, c.Name))
+	}
+}
+
+// writeCollation writes the character set and collation clauses of c for CREATE TABLE.
+// PostgreSQL databases have a single encoding and Cassandra has neither.
+func writeCollation(b *bytes.Buffer, c ddlColumn, dbt DBType) {
+	if c.charset != "" && dbt == MySQL {
+		b.WriteString(" CHARACTER SET ")
+		b.WriteString(c.charset)
+	}
+	if c.collate == "" || dbt == Cassandra {
+		return
+	}
+	b.WriteString(" COLLATE ")
+	if dbt == PostgreSQL {
+		// collation names are identifiers that are usually mixed case, like "en-US-x-icu"
+		b.WriteString(`"` + c.collate + `"`)
+	} else {
+		b.WriteString(c.collate)
+	}
+}
@@ -0,0 +1,59 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// ResultColumns receives the column types of the rows returned by the queries run with
+// a context from WithResultColumns, with their database type names, nullability,
+// lengths and precisions as far as the driver reports them. It is meant for generic
+// grids and exporters:
+//
+//	var rc m.ResultColumns
+//	posts, err := M.Query(Post{}, "*").DoContext(m.WithResultColumns(ctx, &rc))
+//	for _, c := range rc.Columns() {
+//		fmt.Println(c.Name(), c.DatabaseTypeName())
+//	}
+type ResultColumns struct {
+	mu      sync.Mutex
+	columns []*sql.ColumnType
+}
+
+type resultColumnsKey struct{}
+
+// WithResultColumns returns a copy of ctx whose queries set the column types of rc.
+func WithResultColumns(ctx context.Context, rc *ResultColumns) context.Context {
+	return context.WithValue(ctx, resultColumnsKey{}, rc)
+}
+
+// Columns returns the column types of the last query run with rc, or nil if none ran.
+func (rc *ResultColumns) Columns() []*sql.ColumnType {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.columns
+}
+
+// setResultColumns sets the column types of rows on the ResultColumns of ctx, if there
+// is one.
+func setResultColumns(ctx context.Context, rows *sql.Rows) error {
+	rc, _ := ctx.Value(resultColumnsKey{}).(*ResultColumns)
+	if rc == nil {
+		return nil
+	}
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	rc.columns = columns
+	rc.mu.Unlock()
+	return nil
+}
+
+// ColumnTypes returns the column types of the rows, see ResultColumns. It can't be
+// called after the rows are closed.
+func (r *Rows) ColumnTypes() ([]*sql.ColumnType, error) {
+	return r.rows.ColumnTypes()
+}
@@ -0,0 +1,149 @@
+package m
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// OrWhere is like Where but ORs the condition with the previous condition rather than
+// ANDing it. As AND binds tighter than OR, q.Where(a).Where(b).OrWhere(c) selects the
+// rows matching a and b, or c, use Group to group conditions differently. OR is not
+// supported on Cassandra.
+func (q *Query) OrWhere(condition string, binding interface{}) *Query {
+	q.or(comparison(condition) + " ?")
+	q.bindings = append(q.bindings, binding)
+	return q
+}
+
+// Group ANDs the conditions added to g by fn, grouped in parentheses, with the conditions
+// of q.
+//
+//	// WHERE a = ? AND (b = ? OR c = ?)
+//	q.Where("a", 1).Group(func(g *m.Query) { g.Where("b", 2).OrWhere("c", 3) })
+func (q *Query) Group(fn func(g *Query)) *Query {
+	condition, bindings, err := q.group(fn)
+	if err != nil {
+		q.fail(err)
+		return q
+	}
+	if condition != "" {
+		q.conditions = append(q.conditions, condition)
+		q.bindings = append(q.bindings, bindings...)
+	}
+	return q
+}
+
+// OrGroup is like Group but ORs the group with the previous condition.
+func (q *Query) OrGroup(fn func(g *Query)) *Query {
+	condition, bindings, err := q.group(fn)
+	if err != nil {
+		q.fail(err)
+		return q
+	}
+	if condition != "" {
+		q.or(condition)
+		q.bindings = append(q.bindings, bindings...)
+	}
+	return q
+}
+
+// or ORs condition with the last condition of q.
+func (q *Query) or(condition string) {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: OR conditions are not supported on Cassandra"))
+		return
+	}
+	if len(q.conditions) == 0 {
+		q.conditions = append(q.conditions, condition)
+		return
+	}
+	q.conditions[len(q.conditions)-1] += " OR " + condition
+}
+
+// group returns the conditions added by fn in parentheses and their bindings, or the
+// error of a condition that couldn't be added.
+func (q *Query) group(fn func(g *Query)) (string, []interface{}, error) {
+	g := &Query{t: q.t, m: q.m}
+	fn(g)
+	if g.err != nil {
+		return "", nil, g.err
+	}
+	if len(g.conditions) == 0 {
+		return "", nil, nil
+	}
+	b := getBuffer()
+	b.WriteByte('(')
+	writeJoined(b, g.conditions, " AND ")
+	b.WriteByte(')')
+	return putBuffer(b), g.bindings, nil
+}
+
+// WhereEqOrNull adds a condition that column equals value, or is NULL if value is nil, a
+// nil pointer or a driver.Valuer of NULL like an invalid sql.NullString. It is the
+// null-safe equality of IS NOT DISTINCT FROM on PostgreSQL and <=> on MySQL, written as
+// IS NULL or = so that indexes are used everywhere.
+func (q *Query) WhereEqOrNull(column string, value interface{}) *Query {
+	if isNull(value) {
+		if q.m.Type == Cassandra {
+			q.fail(fmt.Errorf("m: NULL conditions are not supported on Cassandra"))
+			return q
+		}
+		q.conditions = append(q.conditions, column+" IS NULL")
+		return q
+	}
+	return q.Where(column+" =", value)
+}
+
+// isNull reports whether v is written as NULL.
+func isNull(v interface{}) bool {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return true
+		}
+		x, err := valuer.Value()
+		return err == nil && x == nil
+	}
+	rv := reflect.ValueOf(v)
+	return v == nil || rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// WhereRaw ANDs the SQL fragment condition, with ? placeholders for bindings, with the
+// conditions of q, for conditions the other methods can't express:
+//
+//	q.WhereRaw("lower(email) = lower(?) OR backup_email = ?", email, email)
+//
+// The bindings can also be a single Args for :name parameters. As values have to be
+// bound, the query fails if the number of placeholders doesn't match the bindings, or
+// if condition contains string literals, comments or semicolons, which are signs of
+// values concatenated into it.
+func (q *Query) WhereRaw(condition string, bindings ...interface{}) *Query {
+	condition, bindings, err := bindNamed(condition, bindings, MySQL)
+	if err != nil {
+		q.fail(err)
+		return q
+	}
+	if err = checkRaw(condition, len(bindings)); err != nil {
+		q.fail(err)
+		return q
+	}
+	q.conditions = append(q.conditions, "("+condition+")")
+	q.bindings = append(q.bindings, bindings...)
+	return q
+}
+
+// checkRaw returns an error if the raw condition doesn't have n placeholders or looks
+// like it has values concatenated into it.
+func checkRaw(condition string, n int) error {
+	if i := strings.IndexAny(condition, "'\";"); i >= 0 {
+		return fmt.Errorf("m: raw condition %q has %q at offset %d, bind values instead", condition, condition[i], i)
+	}
+	if strings.Contains(condition, "--") || strings.Contains(condition, "/*") {
+		return fmt.Errorf("m: raw condition %q has a comment, bind values instead", condition)
+	}
+	if placeholders := findPlaceholders(condition, MySQL); len(placeholders) != n {
+		return fmt.Errorf("m: raw condition %q has %d placeholders for %d bindings", condition, len(placeholders), n)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package m
+
+import "testing"
+
+type groupPost struct {
+	ID   int64  `db:"id,pk"`
+	Data string `db:"data"`
+}
+
+func TestGroupErrors(t *testing.T) {
+	m := PostgreSQL.NewMapping()
+	m.AddTable("posts", groupPost{})
+	for name, q := range map[string]*Query{
+		"Group": m.Query(groupPost{}, "*").Where("id =", 1).Group(func(g *Query) {
+			g.WhereRaw("data = 'x'")
+		}),
+		"OrGroup": m.Query(groupPost{}, "*").Where("id =", 1).OrGroup(func(g *Query) {
+			g.WhereRaw("data = ?")
+		}),
+	} {
+		if _, err := q.Do(); err == nil {
+			t.Errorf("%s with an invalid raw condition didn't return an error", name)
+		}
+	}
+}
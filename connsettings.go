@@ -0,0 +1,141 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// ConnSettings are settings applied to every new connection of a Mapping opened with
+// Open, so that each connection is set up the same way without the application doing
+// it. Settings that the database doesn't have return an error from Open.
+type ConnSettings struct {
+	SearchPath      string // PostgreSQL schemas, comma separated, like "app, public"
+	TimeZone        string // PostgreSQL and MySQL, like "UTC"
+	ApplicationName string // PostgreSQL
+	Keyspace        string // Cassandra
+
+	// Statements are executed after the settings, for the settings not covered above,
+	// like "SET lock_timeout = '5s'" or "PRAGMA foreign_keys = ON".
+	Statements []string
+}
+
+// statements returns the statements applying s on a connection to a database of type
+// dbt.
+func (s ConnSettings) statements(dbt DBType) ([]string, error) {
+	var stmts []string
+	add := func(value, name string, types [4]string) error {
+		if value == "" {
+			return nil
+		}
+		if types[dbt] == "" {
+			return fmt.Errorf("m: the %s connection setting is not supported on %s", name, [...]string{"Cassandra", "PostgreSQL", "MySQL", "SQLite"}[dbt])
+		}
+		stmts = append(stmts, types[dbt]+value)
+		return nil
+	}
+	// indexed by DBType
+	if err := add(s.SearchPath, "search path", [4]string{"", "SET search_path TO ", "", ""}); err != nil {
+		return nil, err
+	}
+	if err := add(quoteSetting(s.TimeZone), "time zone", [4]string{"", "SET TIME ZONE ", "SET time_zone = ", ""}); err != nil {
+		return nil, err
+	}
+	if err := add(quoteSetting(s.ApplicationName), "application name", [4]string{"", "SET application_name TO ", "", ""}); err != nil {
+		return nil, err
+	}
+	if err := add(s.Keyspace, "keyspace", [4]string{"USE ", "", "", ""}); err != nil {
+		return nil, err
+	}
+	return append(stmts, s.Statements...), nil
+}
+
+func quoteSetting(s string) string {
+	if s == "" {
+		return ""
+	}
+	return quoteString(s)
+}
+
+// Open sets the DB of m to a pool of connections made by connector, which are set up
+// with settings before they are used. Drivers that take a DSN have a connector for it,
+// see OpenDSN. Cassandra sessions of gocql are opened with cql.NewConnector.
+func (m *Mapping) Open(connector driver.Connector, settings ConnSettings) error {
+	stmts, err := settings.statements(m.Type)
+	if err != nil {
+		return err
+	}
+	m.DB = sql.OpenDB(&settingsConnector{Connector: connector, statements: stmts})
+	return nil
+}
+
+// OpenDSN is like Open but makes the connections with the registered driver driverName
+// and the data source name dsn, like sql.Open.
+//
+//	err := M.OpenDSN("postgres", dsn, m.ConnSettings{SearchPath: "app, public", TimeZone: "UTC"})
+func (m *Mapping) OpenDSN(driverName, dsn string, settings ConnSettings) error {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return err
+	}
+	d := db.Driver()
+	db.Close()
+	var connector driver.Connector = dsnConnector{d, dsn}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dsn); err != nil {
+			return err
+		}
+	}
+	return m.Open(connector, settings)
+}
+
+// settingsConnector executes the statements of ConnSettings on every new connection.
+type settingsConnector struct {
+	driver.Connector
+	statements []string
+}
+
+func (c *settingsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range c.statements {
+		if err = execConn(ctx, conn, query); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("m: connection setting %q: %v", query, err)
+		}
+	}
+	return conn, nil
+}
+
+// execConn executes query, which has no arguments, on conn.
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if e, ok := conn.(driver.ExecerContext); ok {
+		_, err := e.ExecContext(ctx, query, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	st, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	if e, ok := st.(driver.StmtExecContext); ok {
+		_, err = e.ExecContext(ctx, nil)
+	} else {
+		_, err = st.Exec(nil)
+	}
+	return err
+}
+
+// dsnConnector is the connector of drivers that don't implement driver.DriverContext.
+type dsnConnector struct {
+	d   driver.Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.d }
@@ -0,0 +1,75 @@
+package m
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A ConnObserver is told, for every statement, how long it waited for a connection from
+// the pool and how long it then took to execute, so pool saturation can be told apart
+// from slow queries. ctx is the context of the statement and can be used to attach the
+// timings to a trace.
+type ConnObserver interface {
+	ObserveStatement(ctx context.Context, s *Statement, wait, exec time.Duration, err error)
+}
+
+// SetConnObserver sets the ConnObserver for statements executed by m. Observing
+// statements requires taking each connection from the pool explicitly, so there is a
+// small cost to it.
+func (m *Mapping) SetConnObserver(o ConnObserver) {
+	m.connObserver = o
+}
+
+// ConnStats is a ConnObserver that aggregates timings per operation.
+type ConnStats struct {
+	mu  sync.Mutex
+	ops map[Op]*OpConnStats
+}
+
+// OpConnStats are the aggregated timings of statements of one operation.
+type OpConnStats struct {
+	Statements int
+	Errors     int
+	Wait       time.Duration // total time spent waiting for a connection
+	MaxWait    time.Duration
+	Exec       time.Duration // total time spent executing
+	MaxExec    time.Duration
+}
+
+func (c *ConnStats) ObserveStatement(ctx context.Context, s *Statement, wait, exec time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ops == nil {
+		c.ops = make(map[Op]*OpConnStats)
+	}
+	o := c.ops[s.Op]
+	if o == nil {
+		o = &OpConnStats{}
+		c.ops[s.Op] = o
+	}
+
+	o.Statements++
+	if err != nil {
+		o.Errors++
+	}
+	o.Wait += wait
+	if wait > o.MaxWait {
+		o.MaxWait = wait
+	}
+	o.Exec += exec
+	if exec > o.MaxExec {
+		o.MaxExec = exec
+	}
+}
+
+// Snapshot returns a copy of the current timings of each operation.
+func (c *ConnStats) Snapshot() map[Op]OpConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[Op]OpConnStats, len(c.ops))
+	for op, o := range c.ops {
+		res[op] = *o
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package m
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// Fields of types implementing driver.Valuer and sql.Scanner are written and scanned
+// with their methods. Types that can't implement them, such as types of other packages,
+// can be converted with RegisterConverter instead of being tagged serialize.
+
+// converter converts the values of fields of a type, see RegisterConverter.
+type converter struct {
+	toDB   func(v interface{}) (driver.Value, error)
+	fromDB func(src interface{}) (interface{}, error)
+}
+
+// RegisterConverter converts the fields of the type of thing, and of pointers to it, to
+// the values written to the database with toDB and from the values scanned from the
+// database with fromDB, which returns a value of the type. NULL is written for nil
+// pointers and scanned as nil or the zero value without calling fromDB. Converters
+// apply to every Mapping sharing the Registry of m. Like tables, they have to be
+// registered before the Mappings are used, RegisterConverter isn't safe for concurrent
+// use.
+//
+//	M.RegisterConverter(Money(0), func(v interface{}) (driver.Value, error) {
+//		return int64(v.(Money)), nil
+//	}, func(src interface{}) (interface{}, error) {
+//		cents, ok := src.(int64)
+//		if !ok {
+//			return nil, fmt.Errorf("can't convert %T to Money", src)
+//		}
+//		return Money(cents), nil
+//	})
+func (m *Mapping) RegisterConverter(thing interface{}, toDB func(v interface{}) (driver.Value, error), fromDB func(src interface{}) (interface{}, error)) {
+	if toDB == nil || fromDB == nil {
+		panic("RegisterConverter needs both conversions")
+	}
+	typ := reflect.TypeOf(thing)
+	r := m.registry
+	if r.converters == nil {
+		r.converters = make(map[reflect.Type]*converter)
+	}
+	r.converters[typ] = &converter{toDB, fromDB}
+	for _, t := range r.tables {
+		setConverters(t.Type, t.Columns, r.converters)
+	}
+}
+
+// setConverters sets the converters of the columns of typ whose field type has one.
+func setConverters(typ reflect.Type, columns []*columnMap, converters map[reflect.Type]*converter) {
+	for _, c := range columns {
+		if c.Serialize {
+			continue
+		}
+		ft := typ.FieldByIndex(c.Field).Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if conv, ok := converters[ft]; ok {
+			c.Converter = conv
+		}
+	}
+}
+
+// value returns the value written for field, which isn't NULL.
+func (conv *converter) value(t *tableMap, c *columnMap, field reflect.Value) (interface{}, error) {
+	v, err := conv.toDB(reflect.Indirect(field).Interface())
+	if err != nil {
+		return nil, fmt.Errorf("m: converting column %s of %v: %v", c.Name, t.Type, err)
+	}
+	return v, nil
+}
+
+// convertTarget is the destination of a column with a converter, which is scanned into
+// src first.
+type convertTarget struct {
+	src    *interface{}
+	field  reflect.Value
+	column *columnMap
+}
+
+// set sets the field to the converted value, leaving it zero for NULL.
+func (d convertTarget) set(t *tableMap) error {
+	if *d.src == nil {
+		return nil
+	}
+	c := d.column
+	v, err := c.Converter.fromDB(*d.src)
+	if err != nil {
+		return fmt.Errorf("m: converting column %s of %v: %v", c.Name, t.Type, err)
+	}
+	rv := reflect.ValueOf(v)
+	field := d.field
+	if field.Kind() == reflect.Ptr {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+	if !rv.IsValid() || !rv.Type().AssignableTo(field.Type()) {
+		return fmt.Errorf("m: converter of column %s of %v returned %T, not %v", c.Name, t.Type, v, field.Type())
+	}
+	field.Set(rv)
+	return nil
+}
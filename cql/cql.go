@@ -0,0 +1,250 @@
+// Package cql is a database/sql driver that runs statements over a gocql session, so
+// that a Mapping of type m.Cassandra keeps the paging, consistency levels and batches of
+// gocql behind the same API as the other databases:
+//
+//	session, err := cluster.CreateSession()
+//	M := m.Cassandra.NewMapping()
+//	err = M.Open(cql.NewConnector(session), m.ConnSettings{})
+//	posts, err := M.SelectContext(cql.WithConsistency(ctx, gocql.Quorum), Post{}, "SELECT * FROM posts")
+//
+// Rows are read a page at a time as they are scanned, see WithPageSize. Transactions
+// are logged batches: the writes of a transaction are sent together when it commits,
+// and its reads run outside of it.
+package cql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/gocql/gocql"
+)
+
+// NewConnector returns a connector for sql.OpenDB or m.Mapping.Open whose connections
+// run statements on session. The session is shared by the connections and isn't closed
+// with them.
+func NewConnector(session *gocql.Session) driver.Connector {
+	return &connector{session}
+}
+
+type optionsKey struct{}
+
+type options struct {
+	consistency    gocql.Consistency
+	hasConsistency bool
+	pageSize       int
+}
+
+// WithConsistency returns a copy of ctx whose statements and batches run with
+// consistency c instead of the default consistency of the session.
+func WithConsistency(ctx context.Context, c gocql.Consistency) context.Context {
+	o := contextOptions(ctx)
+	o.consistency, o.hasConsistency = c, true
+	return context.WithValue(ctx, optionsKey{}, o)
+}
+
+// WithPageSize returns a copy of ctx whose queries read n rows at a time instead of the
+// page size of the session.
+func WithPageSize(ctx context.Context, n int) context.Context {
+	o := contextOptions(ctx)
+	o.pageSize = n
+	return context.WithValue(ctx, optionsKey{}, o)
+}
+
+func contextOptions(ctx context.Context) options {
+	o, _ := ctx.Value(optionsKey{}).(options)
+	return o
+}
+
+type connector struct {
+	session *gocql.Session
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{session: c.session}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return cqlDriver{}
+}
+
+type cqlDriver struct{}
+
+func (cqlDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("cql: connections are opened with NewConnector")
+}
+
+type conn struct {
+	session *gocql.Session
+	batch   *gocql.Batch // writes of the open transaction
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	// gocql prepares statements itself
+	return &stmt{c, query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly || opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("cql: transactions are batches, which have no isolation levels")
+	}
+	c.batch = c.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	if o := contextOptions(ctx); o.hasConsistency {
+		c.batch.SetConsistency(o.consistency)
+	}
+	return tx{c}, nil
+}
+
+// CheckNamedValue passes arguments to gocql as they are, so that it can marshal UUIDs,
+// collections and other types database/sql doesn't know about.
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if v.Name != "" {
+		return errors.New("cql: named arguments are not supported")
+	}
+	return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.batch != nil {
+		c.batch.Query(query, values(args)...)
+		return driver.ResultNoRows, nil
+	}
+	// Cassandra doesn't report the number of written rows
+	return driver.ResultNoRows, c.query(ctx, query, args).Exec()
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	iter := c.query(ctx, query, args).Iter()
+	data, err := iter.RowData()
+	if err != nil {
+		iter.Close()
+		return nil, err
+	}
+	// rows are scanned into pointers to pointers, which are nil for null columns
+	dest := make([]interface{}, len(data.Values))
+	for i, v := range data.Values {
+		dest[i] = reflect.New(reflect.TypeOf(v)).Interface()
+	}
+	return &rows{iter: iter, columns: data.Columns, dest: dest}, nil
+}
+
+func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue) *gocql.Query {
+	q := c.session.Query(query, values(args)...).WithContext(ctx)
+	o := contextOptions(ctx)
+	if o.hasConsistency {
+		q = q.Consistency(o.consistency)
+	}
+	if o.pageSize > 0 {
+		q = q.PageSize(o.pageSize)
+	}
+	return q
+}
+
+func values(args []driver.NamedValue) []interface{} {
+	v := make([]interface{}, len(args))
+	for i, arg := range args {
+		v[i] = arg.Value
+	}
+	return v
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.c.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+func (s *stmt) CheckNamedValue(v *driver.NamedValue) error {
+	return s.c.CheckNamedValue(v)
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	n := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		n[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return n
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t tx) Commit() error {
+	b := t.c.batch
+	t.c.batch = nil
+	if b.Size() == 0 {
+		return nil
+	}
+	return t.c.session.ExecuteBatch(b)
+}
+
+func (t tx) Rollback() error {
+	t.c.batch = nil
+	return nil
+}
+
+type rows struct {
+	iter    *gocql.Iter
+	columns []string
+	dest    []interface{}
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return r.iter.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.iter.Scan(r.dest...) {
+		if err := r.iter.Close(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, p := range r.dest {
+		v := reflect.ValueOf(p).Elem()
+		if v.IsNil() {
+			dest[i] = nil
+		} else {
+			dest[i] = v.Elem().Interface()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package m
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CRUDHandler returns an http.Handler serving a JSON API for the table of thing, as a
+// quick admin or data API. Rows are JSON objects keyed by column name, and a row is
+// addressed by the values of its primary key columns as path segments:
+//
+//	GET    /                 list rows, see below
+//	GET    /{key}[/{key}...] get a row
+//	POST   /                 insert the row in the body
+//	PATCH  /{key}[/{key}...] update the row with the columns in the body, PUT works too
+//	DELETE /{key}[/{key}...] delete a row
+//
+// Lists take the parameters limit (50 by default, at most 1000), offset and order, a
+// column name prefixed with - for descending order, which defaults to the primary key.
+// Other parameters are column names that rows are filtered by:
+//
+//	GET /posts/?author_id=3&order=-created_at&limit=20
+//
+// returns {"rows": [...], "total": 57}. The handler has no access control, mount it
+// behind authentication, with http.StripPrefix:
+//
+//	mux.Handle("/admin/posts/", auth(http.StripPrefix("/admin/posts", M.CRUDHandler(Post{}))))
+func (m *Mapping) CRUDHandler(thing interface{}) http.Handler {
+	return &crudHandler{m: m, t: m.lookupTable(thing)}
+}
+
+const (
+	crudDefaultLimit = 50
+	crudMaxLimit     = 1000
+)
+
+type crudHandler struct {
+	m *Mapping
+	t *tableMap
+}
+
+var (
+	// errBadRequest wraps the errors of invalid requests
+	errBadRequest  = errors.New("bad request")
+	errNotFoundRow = errors.New("row not found")
+)
+
+func badRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{errBadRequest}, args...)...)
+}
+
+func (h *crudHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if path := strings.Trim(r.URL.Path, "/"); path != "" {
+		keys = strings.Split(path, "/")
+	}
+	var status int
+	var res interface{}
+	var err error
+	switch {
+	case keys == nil && r.Method == http.MethodGet:
+		status = http.StatusOK
+		res, err = h.list(r)
+	case keys == nil && r.Method == http.MethodPost:
+		status = http.StatusCreated
+		res, err = h.create(r)
+	case keys != nil && r.Method == http.MethodGet:
+		status = http.StatusOK
+		res, err = h.get(r, keys)
+	case keys != nil && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		status = http.StatusOK
+		res, err = h.update(r, keys)
+	case keys != nil && r.Method == http.MethodDelete:
+		status = http.StatusNoContent
+		_, err = h.delete(r, keys)
+	default:
+		status, err = http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed))
+	}
+
+	var ue *UpdateError
+	var te *TypeError
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotFoundRow):
+		status = http.StatusNotFound
+	case errors.Is(err, errBadRequest) || errors.As(err, &ue) || errors.As(err, &te):
+		status = http.StatusBadRequest
+	case status != http.StatusMethodNotAllowed:
+		// database errors aren't shown to clients
+		status, err = http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError))
+	}
+	if err != nil {
+		res = map[string]string{"error": strings.TrimPrefix(err.Error(), errBadRequest.Error()+": ")}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status != http.StatusNoContent {
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (h *crudHandler) list(r *http.Request) (interface{}, error) {
+	q := h.m.Query(h.thing(), "*")
+	limit, offset := crudDefaultLimit, 0
+	order := strings.Join(h.t.primaryKey(), ", ")
+	if h.m.Type == Cassandra {
+		// rows are in the order of their clustering columns
+		order = ""
+	}
+	for name, values := range r.URL.Query() {
+		value := values[len(values)-1]
+		var err error
+		switch name {
+		case "limit":
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > crudMaxLimit {
+				return nil, badRequest("limit must be between 1 and %d", crudMaxLimit)
+			}
+		case "offset":
+			if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+				return nil, badRequest("invalid offset %q", value)
+			}
+			if offset > 0 && h.m.Type == Cassandra {
+				return nil, badRequest("offset is not supported on Cassandra")
+			}
+		case "order":
+			column, direction := value, ""
+			if strings.HasPrefix(column, "-") {
+				column, direction = column[1:], " DESC"
+			}
+			if h.t.column(column) == nil {
+				return nil, badRequest("unknown column %s", column)
+			}
+			order = column + direction
+		default:
+			c := h.t.column(name)
+			if c == nil || c.Serialize {
+				return nil, badRequest("can't filter by %s", name)
+			}
+			v, err := parseValue(h.t.Type.FieldByIndex(c.Field).Type, value)
+			if err != nil {
+				return nil, badRequest("invalid value of %s: %v", name, err)
+			}
+			q.Where(name, v)
+		}
+	}
+	q.Limit(limit)
+	if order != "" {
+		q.Order(order)
+	}
+	if offset > 0 {
+		q.Offset(offset)
+	}
+	rows, total, err := q.PageContext(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		objects[i] = h.object(row)
+	}
+	return map[string]interface{}{"rows": objects, "total": total}, nil
+}
+
+func (h *crudHandler) get(r *http.Request, keys []string) (interface{}, error) {
+	row, err := h.row(r, keys)
+	if err != nil {
+		return nil, err
+	}
+	return h.object(row), nil
+}
+
+func (h *crudHandler) create(r *http.Request) (interface{}, error) {
+	body, err := decodeBody(r)
+	if err != nil {
+		return nil, err
+	}
+	thing := reflect.ValueOf(h.thing())
+	for name, raw := range body {
+		c := h.t.column(name)
+		if c == nil {
+			return nil, badRequest("unknown column %s", name)
+		}
+		if err := json.Unmarshal(raw, thing.Elem().FieldByIndex(c.Field).Addr().Interface()); err != nil {
+			return nil, badRequest("invalid value of %s: %v", name, err)
+		}
+	}
+	if err := h.m.InsertContext(r.Context(), thing.Interface()); err != nil {
+		return nil, err
+	}
+	return h.object(thing.Interface()), nil
+}
+
+func (h *crudHandler) update(r *http.Request, keys []string) (interface{}, error) {
+	body, err := decodeBody(r)
+	if err != nil {
+		return nil, err
+	}
+	row, err := h.row(r, keys)
+	if err != nil {
+		return nil, err
+	}
+	t := h.m.lookupTable(row)
+	data := make(map[string]interface{}, len(body))
+	for name, raw := range body {
+		c := t.column(name)
+		if c == nil {
+			return nil, badRequest("unknown column %s", name)
+		}
+		v := reflect.New(t.Type.FieldByIndex(c.Field).Type)
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return nil, badRequest("invalid value of %s: %v", name, err)
+		}
+		data[name] = v.Elem().Interface()
+	}
+	if err := h.m.UpdateContext(r.Context(), row, data); err != nil {
+		return nil, err
+	}
+	return h.object(row), nil
+}
+
+func (h *crudHandler) delete(r *http.Request, keys []string) (interface{}, error) {
+	row, err := h.row(r, keys)
+	if err != nil {
+		return nil, err
+	}
+	return nil, h.m.DeleteContext(r.Context(), row)
+}
+
+// row returns the row with the primary key values in keys.
+func (h *crudHandler) row(r *http.Request, keys []string) (interface{}, error) {
+	columns := h.t.primaryKey()
+	if len(keys) != len(columns) {
+		return nil, badRequest("expected %d primary key values, got %d", len(columns), len(keys))
+	}
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		c := h.t.column(columns[i])
+		v, err := parseValue(h.t.Type.FieldByIndex(c.Field).Type, key)
+		if err != nil {
+			return nil, badRequest("invalid value of %s: %v", c.Name, err)
+		}
+		values[i] = v
+	}
+	row, err := h.m.GetContext(r.Context(), h.thing(), values...)
+	if err == nil && row == nil {
+		err = errNotFoundRow
+	}
+	return row, err
+}
+
+// thing returns a new row of the table.
+func (h *crudHandler) thing() interface{} {
+	return reflect.New(h.t.Type).Interface()
+}
+
+// object returns the columns of row by name.
+func (h *crudHandler) object(row interface{}) map[string]interface{} {
+	t := h.m.lookupTable(row)
+	v := reflect.Indirect(reflect.ValueOf(row))
+	object := make(map[string]interface{}, len(t.Columns))
+	for _, c := range t.Columns {
+		object[c.Name] = v.FieldByIndex(c.Field).Interface()
+	}
+	return object
+}
+
+func decodeBody(r *http.Request) (map[string]json.RawMessage, error) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("invalid body: %v", err)
+	}
+	return body, nil
+}
+
+// parseValue parses s, from a URL or a tag, as a value of typ. Strings are taken as they
+// are, other values are parsed as JSON, with or without quotes, so that times can be
+// given as 2006-01-02T15:04:05Z.
+func parseValue(typ reflect.Type, s string) (interface{}, error) {
+	v := reflect.New(typ)
+	if typ.Kind() == reflect.String {
+		v.Elem().SetString(s)
+		return v.Elem().Interface(), nil
+	}
+	if err := json.Unmarshal([]byte(s), v.Interface()); err != nil {
+		if json.Unmarshal([]byte(strconv.Quote(s)), v.Interface()) != nil {
+			return nil, err
+		}
+	}
+	return v.Elem().Interface(), nil
+}
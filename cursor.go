@@ -0,0 +1,90 @@
+package m
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultCursorBatch is the number of rows fetched at a time by SelectCursor when
+// batch is not positive.
+const DefaultCursorBatch = 1000
+
+// SelectCursor runs query through a PostgreSQL server-side cursor, fetching batch rows
+// at a time inside a transaction so that very large results never have to be buffered
+// by the driver. fn is called with each row scanned into a struct with the same type as
+// thing, returning an error from fn stops the scan and is returned.
+func (m *Mapping) SelectCursor(thing interface{}, batch int, fn func(interface{}) error, query string, bindings ...interface{}) error {
+	return m.SelectCursorContext(context.Background(), thing, batch, fn, query, bindings...)
+}
+
+// SelectCursorContext is like SelectCursor but runs the scan with ctx, cancelling ctx
+// stops the scan between batches and rolls back its transaction. In a Tx the cursor is
+// declared in the transaction of the Tx, with a name of its own so that several cursors
+// can be open at once. The DECLARE, FETCH and CLOSE statements are run like the other
+// statements of m, with its policies, limits and logger.
+func (m *Mapping) SelectCursorContext(ctx context.Context, thing interface{}, batch int, fn func(interface{}) error, query string, bindings ...interface{}) (err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	if m.Type != PostgreSQL {
+		return fmt.Errorf("m: cursors are only supported on PostgreSQL")
+	}
+	if batch <= 0 {
+		batch = DefaultCursorBatch
+	}
+
+	// a cursor needs a transaction, the one of m if there is one
+	inTx := m.tx != nil
+	return m.inTx(ctx, func(m *Mapping) (err error) {
+		name := m.tx.cursorName()
+		s := &Statement{Op: OpSelect, Table: t.Name, SQL: "DECLARE " + name + " NO SCROLL CURSOR FOR " + query, Args: bindings}
+		err = m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+			_, err := q.ExecContext(ctx, s.SQL, s.Args...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if inTx {
+			// the transaction goes on, so a scan that stops early closes its cursor
+			defer func() {
+				if err != nil {
+					m.closeCursor(context.Background(), t, name)
+				}
+			}()
+		}
+
+		fetch := fmt.Sprintf("FETCH FORWARD %d FROM %s", batch, name)
+		for {
+			n := 0
+			s := &Statement{Op: OpSelect, Table: t.Name, SQL: fetch}
+			err = m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+				rows, err := q.QueryContext(ctx, s.SQL)
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+				n = 0
+				return m.scanRows(t, rows, func(instance interface{}) error {
+					n++
+					return fn(instance)
+				})
+			})
+			if err != nil {
+				return err
+			}
+			if n < batch {
+				break
+			}
+		}
+		return m.closeCursor(ctx, t, name)
+	})
+}
+
+// closeCursor closes the cursor name declared by SelectCursor.
+func (m *Mapping) closeCursor(ctx context.Context, t *tableMap, name string) error {
+	s := &Statement{Op: OpSelect, Table: t.Name, SQL: "CLOSE " + name}
+	return m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+		_, err := q.ExecContext(ctx, s.SQL)
+		return err
+	})
+}
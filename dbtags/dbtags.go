@@ -0,0 +1,209 @@
+// Package dbtags defines an analyzer that checks the db tags of structs mapped by
+// package m, the static counterpart of m.CheckStructs. It reports unknown flags and
+// options, duplicate column names, primary keys of types that can't be keys and
+// serialized fields that encoding/json can't encode.
+//
+// It is a module of its own, so that package m doesn't depend on golang.org/x/tools.
+// It can be run with go vet through a driver like singlechecker:
+//
+//	func main() { singlechecker.Main(dbtags.Analyzer) }
+package dbtags
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name: "dbtags",
+	Doc:  "check db struct tags of structs mapped by github.com/titanous/m",
+	Run:  run,
+}
+
+var (
+	flags   = map[string]bool{"pk": true, "serialize": true, "discriminator": true, "counter": true, "expires": true, "autoincrement": true, "nullzero": true, "autocreate": true, "autoupdate": true, "softdelete": true, "inline": true, "readonly": true, "createonly": true}
+	options = map[string]bool{"collate": true, "charset": true, "serialize": true, "default": true}
+	// timestamp flags need time.Time fields
+	timeFlags = map[string]bool{"autocreate": true, "autoupdate": true, "softdelete": true}
+)
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, file := range pass.Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			st, ok := n.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			if s, ok := pass.TypesInfo.TypeOf(st).(*types.Struct); ok && hasDBTags(s) {
+				check(pass, st.Pos(), s)
+			}
+			return true
+		})
+	}
+	return nil, nil
+}
+
+func hasDBTags(s *types.Struct) bool {
+	for i := 0; i < s.NumFields(); i++ {
+		if _, ok := reflect.StructTag(s.Tag(i)).Lookup("db"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports the mistakes in the db tags of s.
+func check(pass *analysis.Pass, pos token.Pos, s *types.Struct) {
+	seen := make(map[string]bool)
+	var walk func(s *types.Struct, prefix string)
+	walk = func(s *types.Struct, prefix string) {
+		for i := 0; i < s.NumFields(); i++ {
+			field := s.Field(i)
+			tag := strings.Split(reflect.StructTag(s.Tag(i)).Get("db"), ",")
+			if inner, ok := field.Type().Underlying().(*types.Struct); ok && !isTime(field.Type()) &&
+				(field.Embedded() && tag[0] == "" || contains(tag[1:], "inline")) {
+				walk(inner, prefix+tag[0])
+				continue
+			}
+			if tag[0] == "" {
+				continue
+			}
+			fieldPos := field.Pos()
+			if !fieldPos.IsValid() {
+				fieldPos = pos
+			}
+			name := prefix + tag[0]
+			if seen[name] {
+				pass.Reportf(fieldPos, "column %s is mapped twice", name)
+			}
+			seen[name] = true
+
+			var pk, serialize bool
+			codec := ""
+			for _, flag := range tag[1:] {
+				if i := strings.IndexByte(flag, '='); i > 0 {
+					if !options[flag[:i]] {
+						pass.Reportf(fieldPos, "unknown option %s of field %s", flag[:i], field.Name())
+					}
+					if flag[:i] == "serialize" {
+						serialize, codec = true, flag[i+1:]
+					}
+					continue
+				}
+				switch {
+				case !flags[flag]:
+					pass.Reportf(fieldPos, "unknown flag %s of field %s", strconv.Quote(flag), field.Name())
+				case timeFlags[flag] && !isTime(field.Type()) && !isTime(deref(field.Type())):
+					pass.Reportf(fieldPos, "field %s tagged %s is of type %s, not time.Time", field.Name(), flag, field.Type())
+				}
+				pk = pk || flag == "pk"
+				serialize = serialize || flag == "serialize"
+			}
+			if pk && !keyType(field.Type()) {
+				pass.Reportf(fieldPos, "field %s of type %s can't be a primary key", field.Name(), field.Type())
+			}
+			if serialize && (codec == "" || codec == "json") {
+				if bad := jsonUnsupported(field.Type(), make(map[types.Type]bool)); bad != nil {
+					pass.Reportf(fieldPos, "serialized field %s contains %s, which encoding/json can't encode", field.Name(), bad)
+				}
+			}
+		}
+	}
+	walk(s, "")
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func deref(typ types.Type) types.Type {
+	if p, ok := typ.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return typ
+}
+
+func isTime(typ types.Type) bool {
+	named, ok := typ.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Time"
+}
+
+// hasMethod reports whether typ or a pointer to it has the method name.
+func hasMethod(typ types.Type, name string) bool {
+	for _, t := range []types.Type{typ, types.NewPointer(typ)} {
+		if obj, _, _ := types.LookupFieldOrMethod(t, true, nil, name); obj != nil {
+			if _, ok := obj.(*types.Func); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyType reports whether values of typ can be primary keys, which can't be null or
+// collections.
+func keyType(typ types.Type) bool {
+	if isTime(typ) || hasMethod(typ, "Value") {
+		return true
+	}
+	switch u := typ.Underlying().(type) {
+	case *types.Basic:
+		return u.Info()&(types.IsBoolean|types.IsInteger|types.IsFloat|types.IsString) != 0
+	case *types.Slice:
+		b, ok := u.Elem().Underlying().(*types.Basic)
+		return ok && b.Kind() == types.Byte
+	}
+	return false
+}
+
+// jsonUnsupported returns a type in typ that encoding/json can't encode, or nil.
+func jsonUnsupported(typ types.Type, seen map[types.Type]bool) types.Type {
+	if seen[typ] {
+		return nil
+	}
+	seen[typ] = true
+	if hasMethod(typ, "MarshalJSON") || hasMethod(typ, "MarshalText") {
+		return nil
+	}
+	switch u := typ.Underlying().(type) {
+	case *types.Basic:
+		if u.Info()&types.IsComplex != 0 || u.Kind() == types.UnsafePointer {
+			return typ
+		}
+	case *types.Chan, *types.Signature:
+		return typ
+	case *types.Pointer:
+		return jsonUnsupported(u.Elem(), seen)
+	case *types.Slice:
+		return jsonUnsupported(u.Elem(), seen)
+	case *types.Array:
+		return jsonUnsupported(u.Elem(), seen)
+	case *types.Map:
+		if key, ok := u.Key().Underlying().(*types.Basic); (!ok || key.Info()&(types.IsInteger|types.IsString) == 0) && !hasMethod(u.Key(), "MarshalText") {
+			return typ
+		}
+		return jsonUnsupported(u.Elem(), seen)
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			field := u.Field(i)
+			if !field.Exported() && !field.Embedded() || reflect.StructTag(u.Tag(i)).Get("json") == "-" {
+				continue
+			}
+			if bad := jsonUnsupported(field.Type(), seen); bad != nil {
+				return bad
+			}
+		}
+	}
+	return nil
+}
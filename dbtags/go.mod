@@ -0,0 +1,5 @@
+module github.com/titanous/m/dbtags
+
+go 1.26.0
+
+require golang.org/x/tools v0.50.0
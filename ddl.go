@@ -0,0 +1,319 @@
+package m
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CreateTables creates the registered tables, with column types inferred from the types
+// of the mapped fields. Serialized fields are stored as jsonb on PostgreSQL, json on
+// MySQL and text on SQLite and Cassandra, or as blobs for codecs other than JSON.
+// Tables shared by several subtypes get the columns of every subtype, history tables
+// are created next to their table, and on Cassandra the materialized views and
+// secondary indexes are created as well.
+//
+// Fields whose type has no obvious column type, such as slices that aren't serialized,
+// return an error. The generated schema is a starting point, production schemas are
+// usually better written by hand.
+func (m *Mapping) CreateTables() error {
+	return m.CreateTablesContext(context.Background())
+}
+
+// CreateTablesContext is like CreateTables but runs the statements with ctx.
+func (m *Mapping) CreateTablesContext(ctx context.Context) error {
+	return m.createTables(ctx, false)
+}
+
+// CreateTablesIfNotExists is like CreateTables but skips tables that already exist.
+func (m *Mapping) CreateTablesIfNotExists() error {
+	return m.CreateTablesIfNotExistsContext(context.Background())
+}
+
+// CreateTablesIfNotExistsContext is like CreateTablesIfNotExists but runs the
+// statements with ctx.
+func (m *Mapping) CreateTablesIfNotExistsContext(ctx context.Context) error {
+	return m.createTables(ctx, true)
+}
+
+func (m *Mapping) createTables(ctx context.Context, ifNotExists bool) error {
+	stmts, err := m.schema(ifNotExists)
+	if err != nil {
+		return err
+	}
+	for _, query := range stmts {
+		if err = m.execDDL(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schema returns the statements creating the registered tables, in order of their
+// names.
+func (m *Mapping) schema(ifNotExists bool) ([]string, error) {
+	families := make(map[string][]*tableMap)
+	for _, t := range m.registry.tables {
+		if !t.View {
+			families[t.Name] = append(families[t.Name], t)
+		}
+	}
+	names := make([]string, 0, len(families))
+	for name, family := range families {
+		names = append(names, name)
+		// the base table, without a discriminator value, decides the table options
+		sort.Slice(family, func(i, j int) bool { return family[i].Discriminator < family[j].Discriminator })
+	}
+	sort.Strings(names)
+
+	var stmts []string
+	for _, name := range names {
+		family := families[name]
+		s, err := m.createTable(family, ifNotExists)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s...)
+	}
+	return stmts, nil
+}
+
+// ddlColumn is a column of a table being created.
+type ddlColumn struct {
+	name          string
+	typ           string
+	nullable      bool
+	autoIncrement bool
+	collate       string
+	charset       string
+	def           string // DEFAULT value
+}
+
+// createTable returns the statements creating the table shared by the tables of family.
+func (m *Mapping) createTable(family []*tableMap, ifNotExists bool) ([]string, error) {
+	t := family[0]
+	var columns []ddlColumn
+	seen := make(map[string]bool)
+	for _, ft := range family {
+		for _, c := range ft.Columns {
+			if seen[c.Name] {
+				continue
+			}
+			seen[c.Name] = true
+			col, err := m.columnType(ft, c)
+			if err != nil {
+				return nil, err
+			}
+			// columns that only some subtypes have are null for the others
+			col.nullable = col.nullable || len(family) > 1 && !c.PrimaryKey
+			columns = append(columns, col)
+		}
+	}
+	keys := t.primaryKey()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("m: table %s has no primary key", t.Name)
+	}
+	if t.Partition != nil && !contains(keys, t.Partition.Column) {
+		// the primary key of a partitioned table has to include the partition column
+		keys = append(keys[:len(keys):len(keys)], t.Partition.Column)
+	}
+
+	stmts := []string{m.createTableString(t.Name, columns, keys, t, ifNotExists)}
+	if t.History != nil {
+		validTo, _ := m.sqlType(timeType, false)
+		history := append(columns[:len(columns):len(columns)], ddlColumn{name: ValidTo, typ: validTo})
+		// the history of a row is keyed by its key and the start of each version
+		historyKeys := append(keys[:len(keys):len(keys)], ValidFrom)
+		stmts = append(stmts, m.createTableString(t.History.Table, history, historyKeys, nil, ifNotExists))
+	}
+
+	if m.Type == Cassandra {
+		exists := ""
+		if ifNotExists {
+			exists = "IF NOT EXISTS "
+		}
+		for _, index := range t.Indexes {
+			stmts = append(stmts, fmt.Sprintf("CREATE INDEX %s%s_%s_idx ON %s (%s)", exists, t.Name, index, t.Name, index))
+		}
+		for _, v := range t.Views {
+			notNull := make([]string, len(keys))
+			for i, k := range keys {
+				notNull[i] = k + " IS NOT NULL"
+			}
+			clustering := make([]string, 0, len(keys))
+			for _, k := range keys {
+				if !contains(v.PartitionKey, k) {
+					clustering = append(clustering, k)
+				}
+			}
+			stmts = append(stmts, fmt.Sprintf("CREATE MATERIALIZED VIEW %s%s AS SELECT * FROM %s WHERE %s PRIMARY KEY (%s)",
+				exists, v.table.Name, t.Name, strings.Join(notNull, " AND "), cassandraPrimaryKey(v.PartitionKey, clustering)))
+		}
+	}
+	return stmts, nil
+}
+
+// createTableString returns a CREATE TABLE statement. t is nil for history tables, which
+// don't get the table options of their table.
+func (m *Mapping) createTableString(name string, columns []ddlColumn, keys []string, t *tableMap, ifNotExists bool) string {
+	b := getBuffer()
+	b.WriteString("CREATE TABLE ")
+	if ifNotExists {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	writeIdent(b, name, m.Type)
+	b.WriteString(" (")
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeIdent(b, c.name, m.Type)
+		b.WriteByte(' ')
+		// the versions of a row in a history table keep the generated values
+		autoIncrement := c.autoIncrement && t != nil
+		switch {
+		case autoIncrement && m.Type == PostgreSQL && c.typ == "integer":
+			b.WriteString("serial")
+		case autoIncrement && m.Type == PostgreSQL:
+			b.WriteString("bigserial")
+		default:
+			b.WriteString(c.typ)
+		}
+		writeCollation(b, c, m.Type)
+		if !c.nullable && m.Type != Cassandra {
+			b.WriteString(" NOT NULL")
+		}
+		if c.def != "" {
+			b.WriteString(" DEFAULT ")
+			b.WriteString(c.def)
+		}
+		if autoIncrement && m.Type == MySQL {
+			b.WriteString(" AUTO_INCREMENT")
+		}
+	}
+	b.WriteString(", PRIMARY KEY (")
+	if m.Type == Cassandra && t != nil {
+		var clustering []string
+		partition := t.partitionKey()
+		for _, k := range keys {
+			if !contains(partition, k) {
+				clustering = append(clustering, k)
+			}
+		}
+		b.WriteString(cassandraPrimaryKey(partition, clustering))
+	} else {
+		writeIdents(b, keys, ", ", m.Type)
+	}
+	b.WriteString("))")
+	if t != nil {
+		m.writeTableOptions(b, t)
+	}
+	return putBuffer(b)
+}
+
+// cassandraPrimaryKey returns the inside of a Cassandra PRIMARY KEY clause.
+func cassandraPrimaryKey(partition, clustering []string) string {
+	key := "(" + strings.Join(partition, ", ") + ")"
+	if len(clustering) > 0 {
+		key += ", " + strings.Join(clustering, ", ")
+	}
+	return key
+}
+
+func (m *Mapping) writeTableOptions(b *bytes.Buffer, t *tableMap) {
+	switch {
+	case m.Type == PostgreSQL && t.Partition != nil:
+		fmt.Fprintf(b, " PARTITION BY RANGE (%s)", t.Partition.Column)
+	case m.Type == Cassandra && t.TTL > 0:
+		fmt.Fprintf(b, " WITH default_time_to_live = %d", int64(t.TTL/time.Second))
+	}
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// columnType returns the column for c of t. Columns of pointer fields and of fields
+// tagged nullzero can be null.
+func (m *Mapping) columnType(t *tableMap, c *columnMap) (ddlColumn, error) {
+	field := t.Type.FieldByIndex(c.Field)
+	typ := field.Type
+	col := ddlColumn{name: c.Name, collate: c.Collate, charset: c.Charset, def: sqlDefault(c, m.Type)}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+		col.nullable = !c.PrimaryKey
+	}
+	if c.NullZero {
+		col.nullable = !c.PrimaryKey
+	}
+
+	switch {
+	case c.binary():
+		col.typ, _ = m.sqlType(bytesType, false)
+	case c.Serialize:
+		col.typ = [...]string{"text", "jsonb", "json", "TEXT"}[m.Type]
+	case c.Counter && m.Type == Cassandra:
+		col.typ = "counter"
+	case c.Converter != nil:
+		// the column type is the one of the converted zero value
+		v, err := c.Converter.toDB(reflect.Zero(typ).Interface())
+		var ok bool
+		if err == nil && v != nil {
+			col.typ, ok = m.sqlType(reflect.TypeOf(v), c.PrimaryKey || c.Discriminator)
+		}
+		if !ok {
+			return col, fmt.Errorf("m: can't infer the column type of field %s of %v from its converter", field.Name, t.Type)
+		}
+	default:
+		var ok bool
+		if col.typ, ok = m.sqlType(typ, c.PrimaryKey || c.Discriminator); !ok {
+			return col, fmt.Errorf("m: can't infer the column type of field %s of %v, tag it serialize or create the table by hand", field.Name, t.Type)
+		}
+		// on SQLite an INTEGER primary key is an alias for the rowid, which is
+		// generated anyway
+		col.autoIncrement = m.Type != Cassandra && (c.AutoIncrement || c.Name == t.Sequence)
+	}
+	return col, nil
+}
+
+// sqlType returns the column type for values of typ. key is true for columns that are
+// indexed.
+func (m *Mapping) sqlType(typ reflect.Type, key bool) (string, bool) {
+	var types [4]string // indexed by DBType
+	switch {
+	case typ == timeType:
+		types = [4]string{"timestamp", "timestamptz", "datetime(6)", "DATETIME"}
+	case typ == bytesType:
+		types = [4]string{"blob", "bytea", "longblob", "BLOB"}
+	default:
+		switch typ.Kind() {
+		case reflect.Bool:
+			types = [4]string{"boolean", "boolean", "boolean", "INTEGER"}
+		case reflect.Int8, reflect.Int16, reflect.Uint8:
+			types = [4]string{"smallint", "smallint", "smallint", "INTEGER"}
+		case reflect.Int32, reflect.Uint16:
+			types = [4]string{"int", "integer", "int", "INTEGER"}
+		case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+			types = [4]string{"bigint", "bigint", "bigint", "INTEGER"}
+		case reflect.Float32:
+			types = [4]string{"float", "real", "float", "REAL"}
+		case reflect.Float64:
+			types = [4]string{"double", "double precision", "double", "REAL"}
+		case reflect.String:
+			// MySQL can only index text columns by a prefix
+			mysql := "text"
+			if key {
+				mysql = "varchar(255)"
+			}
+			types = [4]string{"text", "text", mysql, "TEXT"}
+		default:
+			return "", false
+		}
+	}
+	return types[m.Type], true
+}
@@ -0,0 +1,79 @@
+package m
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Columns can be tagged with a default value, which inserts write instead of the zero
+// value of the field and CreateTables declares with DEFAULT. Values are parsed like
+// JSON, strings don't need quotes and can't contain commas:
+//
+//	Status   string `db:"status,default=pending"`
+//	Priority int    `db:"priority,default=3"`
+
+// parseDefaults parses the default values of the columns of typ, it panics if one
+// isn't a value of the type of its field.
+func parseDefaults(typ reflect.Type, columns []*columnMap) {
+	for _, c := range columns {
+		if c.Default == "" {
+			continue
+		}
+		v, err := parseValue(typ.FieldByIndex(c.Field).Type, c.Default)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid default %s for column %s of %v: %v", c.Default, c.Name, typ, err))
+		}
+		c.DefaultValue = v
+	}
+}
+
+// setDefaults sets the zero fields of thing that have a default value to it. If thing
+// isn't a pointer a modified copy is returned.
+func setDefaults(t *tableMap, thing interface{}) interface{} {
+	for _, c := range t.Columns {
+		if c.DefaultValue == nil {
+			continue
+		}
+		v := reflect.Indirect(reflect.ValueOf(thing))
+		if !v.FieldByIndex(c.Field).IsZero() {
+			continue
+		}
+		if !v.CanSet() {
+			p := reflect.New(t.Type)
+			p.Elem().Set(v)
+			thing, v = p.Interface(), p.Elem()
+		}
+		d := reflect.ValueOf(c.DefaultValue)
+		if d.Kind() == reflect.Ptr {
+			// each row gets a copy, the value pointed to can be changed through it
+			p := reflect.New(d.Type().Elem())
+			p.Elem().Set(d.Elem())
+			d = p
+		}
+		v.FieldByIndex(c.Field).Set(d)
+	}
+	return thing
+}
+
+// sqlDefault returns the DEFAULT clause of c for CREATE TABLE, Cassandra has none.
+func sqlDefault(c *columnMap, dbt DBType) string {
+	if c.DefaultValue == nil || dbt == Cassandra || c.Serialize {
+		return ""
+	}
+	switch v := reflect.Indirect(reflect.ValueOf(c.DefaultValue)); v.Kind() {
+	case reflect.Bool:
+		if dbt == SQLite {
+			if v.Bool() {
+				return "1"
+			}
+			return "0"
+		}
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return c.Default
+	}
+	return quoteString(c.Default)
+}
@@ -0,0 +1,222 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DiffKind is the kind of a Difference.
+type DiffKind int
+
+const (
+	DiffMissing DiffKind = iota // the row is only in the source
+	DiffExtra                   // the row is only in the destination
+	DiffChanged                 // the row is in both but some columns differ
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffMissing:
+		return "missing"
+	case DiffExtra:
+		return "extra"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// Difference is a row that differs between two databases, found by Diff.
+type Difference struct {
+	Kind    DiffKind
+	Table   string
+	Source  interface{} // the row in the source, nil for DiffExtra
+	Dest    interface{} // the row in the destination, nil for DiffMissing
+	Columns []string    // the columns that differ, for DiffChanged
+}
+
+// DefaultDiffBatch is the number of rows looked up at a time by Diff when batch is not
+// positive.
+const DefaultDiffBatch = 500
+
+// Diff compares the rows of the table for thing in m, the source, with the rows of the
+// same table in dest, which may be a database of another type, and calls fn with every
+// Difference. Rows are matched by primary key and compared column by column. Each
+// table is read once, and the rows read from one are looked up batch at a time in the
+// other. Returning an error from fn stops the comparison and is returned.
+//
+//	err := cassandra.Diff(postgres, User{}, 0, func(d m.Difference) error {
+//		return postgres.ApplyDiff(d)
+//	})
+func (m *Mapping) Diff(dest *Mapping, thing interface{}, batch int, fn func(Difference) error) error {
+	return m.DiffContext(context.Background(), dest, thing, batch, fn)
+}
+
+// DiffContext is like Diff but runs the queries with ctx.
+func (m *Mapping) DiffContext(ctx context.Context, dest *Mapping, thing interface{}, batch int, fn func(Difference) error) error {
+	if batch <= 0 {
+		batch = DefaultDiffBatch
+	}
+	src, err := m.table(thing)
+	if err != nil {
+		return err
+	}
+	dst, err := dest.table(thing)
+	if err != nil {
+		return err
+	}
+	if len(src.primaryKey()) == 0 {
+		return ErrNoPrimaryKey
+	}
+
+	// rows of the source that are missing or changed in the destination
+	err = diffPass(ctx, m, src, dest, dst, batch, func(row, other interface{}) error {
+		if other == nil {
+			return fn(Difference{Kind: DiffMissing, Table: src.Name, Source: row})
+		}
+		if columns := diffColumns(m.lookupTable(row), row, other); len(columns) > 0 {
+			return fn(Difference{Kind: DiffChanged, Table: src.Name, Source: row, Dest: other, Columns: columns})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// rows of the destination that aren't in the source
+	return diffPass(ctx, dest, dst, m, src, batch, func(row, other interface{}) error {
+		if other == nil {
+			return fn(Difference{Kind: DiffExtra, Table: src.Name, Dest: row})
+		}
+		return nil
+	})
+}
+
+// ApplyDiff makes the row of d in m, the destination of Diff, the same as in the source.
+func (m *Mapping) ApplyDiff(d Difference) error {
+	if d.Kind == DiffExtra {
+		return m.Delete(d.Dest)
+	}
+	return m.Replace(d.Source)
+}
+
+// diffPass reads every row of t in a and calls fn with it and the row with the same key
+// in ot in b, or nil if there is none.
+func diffPass(ctx context.Context, a *Mapping, t *tableMap, b *Mapping, ot *tableMap, batch int, fn func(row, other interface{}) error) error {
+	rows := make([]interface{}, 0, batch)
+	flush := func() error {
+		others, err := b.lookupKeys(ctx, ot, rows)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = fn(row, others[diffKey(t, row)]); err != nil {
+				return err
+			}
+		}
+		rows = rows[:0]
+		return nil
+	}
+	err := a.scanTable(ctx, t, func(row interface{}) error {
+		rows = append(rows, row)
+		if len(rows) < batch {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// lookupKeys returns the rows of t with the primary keys of rows, by diffKey.
+func (m *Mapping) lookupKeys(ctx context.Context, t *tableMap, rows []interface{}) (map[string]interface{}, error) {
+	found := make(map[string]interface{}, len(rows))
+	keys := t.primaryKey()
+	add := func(row interface{}) error {
+		found[diffKey(t, row)] = row
+		return nil
+	}
+
+	b := getBuffer()
+	fmt.Fprintf(b, "SELECT * FROM %s WHERE ", quoteIdent(t.Name, m.Type))
+	if m.Type == Cassandra && len(keys) > 1 {
+		// Cassandra can only look up one composite key at a time
+		writeColumnPlaceholders(b, keys, 0, " AND ", m.Type)
+		b.WriteString(" LIMIT 1")
+		query := putBuffer(b)
+		for _, row := range rows {
+			_, values := keysForUpdate(row, t)
+			if err := m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: values}, add); err != nil {
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+
+	var values []interface{}
+	if len(keys) == 1 {
+		fmt.Fprintf(b, "%s IN (", keys[0])
+	} else {
+		fmt.Fprintf(b, "(%s) IN (", strings.Join(keys, ", "))
+	}
+	for i, row := range rows {
+		_, rowValues := keysForUpdate(row, t)
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if len(keys) == 1 {
+			writePlaceholder(b, len(values), m.Type)
+		} else {
+			b.WriteByte('(')
+			writePlaceholders(b, len(values), len(keys), m.Type)
+			b.WriteByte(')')
+		}
+		values = append(values, rowValues...)
+	}
+	fmt.Fprintf(b, ") LIMIT %d", len(rows))
+	err := m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: putBuffer(b), Args: values}, add)
+	return found, err
+}
+
+// diffKey identifies the primary key of row across databases.
+func diffKey(t *tableMap, row interface{}) string {
+	_, values := keysForUpdate(row, t)
+	var b strings.Builder
+	for _, v := range values {
+		if tm, ok := v.(time.Time); ok {
+			v = tm.UTC()
+		}
+		fmt.Fprintf(&b, "%v\x00", v)
+	}
+	return b.String()
+}
+
+// diffColumns returns the columns of t that differ between a and b.
+func diffColumns(t *tableMap, a, b interface{}) []string {
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	if av.Type() != bv.Type() {
+		return []string{t.discriminatorColumn().Name}
+	}
+	var columns []string
+	for _, c := range t.Columns {
+		x, y := av.FieldByIndex(c.Field).Interface(), bv.FieldByIndex(c.Field).Interface()
+		if tx, ok := x.(time.Time); ok {
+			if !tx.Equal(y.(time.Time)) {
+				columns = append(columns, c.Name)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(x, y) {
+			columns = append(columns, c.Name)
+		}
+	}
+	return columns
+}
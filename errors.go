@@ -0,0 +1,85 @@
+package m
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrNoColumns is returned when a write would not set any columns, for example
+	// when every mapped field of a struct passed to Insert is empty.
+	ErrNoColumns = errors.New("m: no columns to write")
+
+	// ErrNoPrimaryKey is returned when a statement needs to identify a row by its
+	// primary key but the table has no columns tagged pk.
+	ErrNoPrimaryKey = errors.New("m: table has no primary key columns")
+
+	// ErrReadOnly is returned when writing to a view.
+	ErrReadOnly = errors.New("m: can't write to a view")
+
+	// ErrAppendOnly is returned when updating or deleting rows of an append-only table.
+	ErrAppendOnly = errors.New("m: can't modify rows of an append-only table")
+
+	// ErrLocked is returned by Lock when the lock is held by someone else.
+	ErrLocked = errors.New("m: lock is held")
+
+	// ErrUnknownTable is returned, wrapped in a *TypeError, when a type that wasn't
+	// registered with AddTable is passed as the row of a table.
+	ErrUnknownTable = errors.New("m: unknown table")
+
+	// ErrInvalidType is returned, wrapped in a *TypeError, when a value that isn't a
+	// struct or a struct pointer is passed as the row of a table.
+	ErrInvalidType = errors.New("m: invalid type")
+)
+
+// TypeError is returned when a value passed as the row of a table can't be mapped. Err is
+// ErrUnknownTable or ErrInvalidType, which errors.Is finds.
+type TypeError struct {
+	Type reflect.Type // nil for a nil interface
+	Err  error
+}
+
+func (e *TypeError) Error() string {
+	if e.Err == ErrInvalidType {
+		return fmt.Sprintf("m: expecting a struct or struct pointer, got %v", e.Type)
+	}
+	return fmt.Sprintf("m: unknown table for type %v, register it with AddTable", e.Type)
+}
+
+func (e *TypeError) Unwrap() error {
+	return e.Err
+}
+
+// UpdateError is returned by Update and UpdateKey when the data map contains columns that
+// are not mapped or values that can't be assigned to the struct fields. Nothing is modified
+// when an UpdateError is returned.
+type UpdateError struct {
+	Type            reflect.Type
+	UnknownColumns  []string
+	Incompatible    []string
+	KeyColumns      []string // primary key columns passed to Update
+	NonKeyColumns   []string // other columns passed to UpdateKey
+	ReadOnlyColumns []string // columns tagged readonly or createonly
+}
+
+func (e *UpdateError) Error() string {
+	var problems []string
+	if len(e.UnknownColumns) > 0 {
+		problems = append(problems, "unknown columns: "+strings.Join(e.UnknownColumns, ", "))
+	}
+	if len(e.Incompatible) > 0 {
+		problems = append(problems, "incompatible values: "+strings.Join(e.Incompatible, ", "))
+	}
+	if len(e.KeyColumns) > 0 {
+		problems = append(problems, "primary key columns must be changed with UpdateKey: "+strings.Join(e.KeyColumns, ", "))
+	}
+	if len(e.NonKeyColumns) > 0 {
+		problems = append(problems, "not primary key columns: "+strings.Join(e.NonKeyColumns, ", "))
+	}
+	if len(e.ReadOnlyColumns) > 0 {
+		problems = append(problems, "read-only columns: "+strings.Join(e.ReadOnlyColumns, ", "))
+	}
+	return "m: invalid update of " + e.Type.String() + ": " + strings.Join(problems, "; ")
+}
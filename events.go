@@ -0,0 +1,124 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// DefaultStreamBatch is the number of events fetched at a time by ReadStream when batch
+// is not positive.
+const DefaultStreamBatch = 1000
+
+// SetAppendOnly makes the table for thing an append-only event table. Rows can only be
+// inserted, Update and Delete return ErrAppendOnly. sequence is an integer column that
+// the database assigns in increasing order, such as a PostgreSQL bigserial column: it
+// is left out of inserts and set on the struct afterwards, and orders ReadStream.
+//
+//	M.SetAppendOnly(Event{}, "seq")
+func (m *Mapping) SetAppendOnly(thing interface{}, sequence string) {
+	t := m.lookupTable(thing)
+	c := t.column(sequence)
+	if c == nil {
+		panic(fmt.Sprintf("Unknown sequence column %s for type: %v", sequence, t.Type))
+	}
+	switch t.Type.FieldByIndex(c.Field).Type.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+	default:
+		panic(fmt.Sprintf("Sequence column %s of type %v is not an integer", sequence, t.Type))
+	}
+	t.Sequence = sequence
+}
+
+// ReadStream calls fn with each event of the append-only table for thing whose sequence
+// is greater than from, in sequence order. Events are fetched batch at a time, so a
+// consumer can resume a stream of any length from the last sequence it processed.
+// Returning an error from fn stops reading and is returned.
+func (m *Mapping) ReadStream(thing interface{}, from int64, batch int, fn func(interface{}) error) error {
+	return m.ReadStreamContext(context.Background(), thing, from, batch, fn)
+}
+
+// ReadStreamContext is like ReadStream but runs the queries with ctx.
+func (m *Mapping) ReadStreamContext(ctx context.Context, thing interface{}, from int64, batch int, fn func(interface{}) error) error {
+	t, err := m.table(thing)
+	if err != nil {
+		return err
+	}
+	if t.Sequence == "" {
+		return fmt.Errorf("m: table %s is not append-only", t.Name)
+	}
+	if m.Type == Cassandra {
+		return fmt.Errorf("m: event streams are not supported on Cassandra")
+	}
+	if batch <= 0 {
+		batch = DefaultStreamBatch
+	}
+
+	b := getBuffer()
+	fmt.Fprintf(b, "SELECT * FROM %s WHERE %s > ", quoteIdent(t.Name, m.Type), quoteIdent(t.Sequence, m.Type))
+	writePlaceholder(b, 0, m.Type)
+	fmt.Fprintf(b, " ORDER BY %s LIMIT %d", quoteIdent(t.Sequence, m.Type), batch)
+	query := putBuffer(b)
+
+	field := t.column(t.Sequence).Field
+	for {
+		n := 0
+		err := m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: []interface{}{from}}, func(event interface{}) error {
+			n++
+			seq := reflect.ValueOf(event).Elem().FieldByIndex(field)
+			if seq.Kind() >= reflect.Uint && seq.Kind() <= reflect.Uint64 {
+				from = int64(seq.Uint())
+			} else {
+				from = seq.Int()
+			}
+			return fn(event)
+		})
+		if err != nil || n < batch {
+			return err
+		}
+	}
+}
+
+// insertEvent inserts thing into an append-only table and sets its sequence field to
+// the value assigned by the database.
+func (m *Mapping) insertEvent(ctx context.Context, t *tableMap, thing interface{}, columns []string, values []interface{}) error {
+	if m.Type == Cassandra {
+		return fmt.Errorf("m: append-only tables are not supported on Cassandra")
+	}
+	columns, values = withoutColumn(columns, values, t.Sequence)
+	query, err := sqlInsertString(t.Name, columns, m.Type)
+	if err != nil {
+		return err
+	}
+
+	var seq int64
+	s := &Statement{Op: OpInsert, Table: t.Name, SQL: query, Args: values}
+	if m.Type == PostgreSQL {
+		s.SQL += " RETURNING " + t.Sequence
+		err = m.run(ctx, s, t.WriteTimeout, func(ctx context.Context, q querier) error {
+			rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			if rows.Next() {
+				err = rows.Scan(&seq)
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+			return err
+		})
+	} else {
+		var res sql.Result
+		if res, err = m.exec(ctx, t, s); err == nil {
+			seq, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	assign(reflect.Indirect(reflect.ValueOf(thing)).FieldByIndex(t.column(t.Sequence).Field), seq)
+	return nil
+}
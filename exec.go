@@ -0,0 +1,190 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// SetTimeouts sets default deadlines for statements that read from and write to the
+// table for thing, for example a few seconds for analytics tables and a few hundred
+// milliseconds for OLTP tables. They are only applied when the context of a call has no
+// deadline of its own, a zero duration means no default.
+func (m *Mapping) SetTimeouts(thing interface{}, read, write time.Duration) {
+	t := m.lookupTable(thing)
+	t.ReadTimeout = read
+	t.WriteTimeout = write
+}
+
+// Op is the kind of operation performed by a Statement.
+type Op int
+
+const (
+	OpSelect Op = iota
+	OpInsert
+	OpUpdate
+	OpDelete
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpSelect:
+		return "SELECT"
+	case OpInsert:
+		return "INSERT"
+	case OpUpdate:
+		return "UPDATE"
+	case OpDelete:
+		return "DELETE"
+	}
+	return "Op(" + strconv.Itoa(int(o)) + ")"
+}
+
+// Statement is a statement that is about to be executed by a Mapping.
+type Statement struct {
+	Op    Op
+	Table string // empty if the statement isn't for a registered table
+	SQL   string
+	Args  []interface{}
+
+	// Idempotent is true if executing the statement more than once has the same effect
+	// as executing it once, so that it can be retried safely after a timeout.
+	Idempotent bool
+
+	// Label is the label of the context of the statement, see WithLabel.
+	Label string
+}
+
+// querier is implemented by the things statements can be executed on.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// exec runs a statement that writes to t, which is nil for statements that aren't
+// generated from a registered table.
+func (m *Mapping) exec(ctx context.Context, t *tableMap, s *Statement) (res sql.Result, err error) {
+	var timeout time.Duration
+	if t != nil {
+		timeout = t.WriteTimeout
+	}
+	err = m.run(ctx, s, timeout, func(ctx context.Context, q querier) error {
+		res, err = q.ExecContext(ctx, s.SQL, s.Args...)
+		return err
+	})
+	return res, err
+}
+
+// query runs a statement that returns rows of t, usually a SELECT, and passes each
+// returned row to fn scanned into a struct.
+func (m *Mapping) query(ctx context.Context, t *tableMap, s *Statement, fn func(interface{}) error) error {
+	timeout := t.WriteTimeout
+	if s.Op == OpSelect {
+		var err error
+		if s.SQL, err = t.guardQuery(s.SQL, m.Type); err != nil {
+			return err
+		}
+		s.Idempotent = true
+		timeout = t.ReadTimeout
+	}
+	return m.run(ctx, s, timeout, func(ctx context.Context, q querier) error {
+		rows, done, err := m.queryRows(ctx, s, q)
+		if err != nil {
+			done()
+			return err
+		}
+		defer done()
+		defer rows.Close()
+		if err = setResultColumns(ctx, rows); err != nil {
+			return err
+		}
+
+		return m.scanRows(t, rows, func(instance interface{}) error {
+			// once rows have been handed out, retrying would hand them out again
+			s.Idempotent = false
+			return fn(instance)
+		})
+	})
+}
+
+// run takes s through the checks every statement goes through and then calls fn to
+// execute it.
+func (m *Mapping) run(ctx context.Context, s *Statement, timeout time.Duration, fn func(context.Context, querier) error) (err error) {
+	defer m.recover(&err)
+	if s.SQL, s.Args, err = bindNamed(s.SQL, s.Args, m.Type); err != nil {
+		return err
+	}
+	label(ctx, s)
+	if m.stats != nil {
+		start := time.Now()
+		defer func() { m.stats.Observe(ctx, s, time.Since(start), err) }()
+	}
+	if err := m.checkPolicy(s); err != nil {
+		return err
+	}
+	if err := m.checkBindings(s); err != nil {
+		return err
+	}
+	if err := m.wait(ctx, s); err != nil {
+		return err
+	}
+	done, err := chargeBudget(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := withDefaultTimeout(ctx, timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err = m.attempt(ctx, s, fn)
+		m.log(s, start, err)
+		if s.Op != OpSelect {
+			m.wrote(ctx, s)
+		}
+		// a failed statement aborts a transaction, so it can't be retried
+		if err == nil || m.retry == nil || m.tx != nil || !m.retry.Retry(ctx, s, attempt, err) {
+			return err
+		}
+	}
+}
+
+// attempt calls fn once to execute s.
+func (m *Mapping) attempt(ctx context.Context, s *Statement, fn func(context.Context, querier) error) error {
+	if m.tx != nil {
+		return fn(ctx, m.prepared(m.tx.tx))
+	}
+	db := m.route(ctx, s)
+	if m.connObserver == nil {
+		return fn(ctx, m.prepared(db))
+	}
+
+	// take the connection from the pool explicitly to tell the time spent waiting
+	// for it apart from the time spent executing the statement
+	start := time.Now()
+	conn, err := db.Conn(ctx)
+	wait := time.Since(start)
+	if err != nil {
+		m.connObserver.ObserveStatement(ctx, s, wait, 0, err)
+		return err
+	}
+	defer conn.Close()
+
+	start = time.Now()
+	err = fn(ctx, conn)
+	m.connObserver.ObserveStatement(ctx, s, wait, time.Since(start), err)
+	return err
+}
+
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,54 @@
+package m
+
+import (
+	"context"
+	"fmt"
+)
+
+// Get returns the row of thing with the primary key values keys, in the order the pk
+// columns are declared. It returns nil if there is no such row.
+//
+//	v, err := M.Get(Membership{}, groupID, userID)
+//	if v != nil {
+//		membership := v.(*Membership)
+//	}
+func (m *Mapping) Get(thing interface{}, keys ...interface{}) (interface{}, error) {
+	return m.GetContext(context.Background(), thing, keys...)
+}
+
+// GetContext is like Get but runs the query with ctx.
+func (m *Mapping) GetContext(ctx context.Context, thing interface{}, keys ...interface{}) (_ interface{}, err error) {
+	defer m.recover(&err)
+	return m.get(ctx, m.lookupTable(thing), keys)
+}
+
+// get selects the row of t with the primary key values keys.
+func (m *Mapping) get(ctx context.Context, t *tableMap, keys []interface{}) (interface{}, error) {
+	columns := t.primaryKey()
+	if len(columns) == 0 {
+		return nil, ErrNoPrimaryKey
+	}
+	if len(keys) != len(columns) {
+		return nil, fmt.Errorf("m: %d primary key values given for %s, expected %d", len(keys), t.Name, len(columns))
+	}
+	b := getBuffer()
+	b.WriteString("SELECT * FROM ")
+	writeIdent(b, t.Name, m.Type)
+	b.WriteString(" WHERE ")
+	writeColumnPlaceholders(b, columns, 0, " AND ", m.Type)
+	if c := t.softDeleteColumn(); c != nil {
+		b.WriteString(" AND ")
+		writeIdent(b, c.Name, m.Type)
+		b.WriteString(" IS NULL")
+	}
+	// the limit satisfies the guards against unbounded queries
+	b.WriteString(" LIMIT 1")
+	query := putBuffer(b)
+
+	var res interface{}
+	err := m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: keys}, func(instance interface{}) error {
+		res = instance
+		return nil
+	})
+	return res, err
+}
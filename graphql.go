@@ -0,0 +1,251 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphQLQuery returns a query for the table of thing that selects the columns of
+// fields, the names of the fields in the selection set of a GraphQL field, filtered and
+// ordered by args, the arguments of the field:
+//
+//	q, err := M.GraphQLQuery(Post{}, []string{"id", "title", "createdAt"}, map[string]interface{}{
+//		"authorId": 3, "createdAt_gt": since, "orderBy": "createdAt_DESC", "first": 20,
+//	})
+//	posts, err := q.DoContext(ctx)
+//
+// Names match column names, or field names ignoring case and underscores, so authorId
+// matches author_id. Selected fields that aren't columns, like relations, are skipped,
+// and the primary key and discriminator are always selected. Arguments are equality
+// filters on a column, filters on a column suffixed with _ne, _gt, _gte, _lt, _lte or
+// _in, which takes a slice, and first, skip and orderBy, a column suffixed with _ASC or
+// _DESC.
+func (m *Mapping) GraphQLQuery(thing interface{}, fields []string, args map[string]interface{}) (*Query, error) {
+	t, err := m.table(thing)
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, c := range t.Columns {
+		if c.PrimaryKey || c.Discriminator {
+			columns = append(columns, c.Name)
+		}
+	}
+	for _, field := range fields {
+		if c := graphQLColumn(t, field); c != nil && !contains(columns, c.Name) {
+			columns = append(columns, c.Name)
+		}
+	}
+	q := m.Query(thing, strings.Join(columns, ", "))
+
+	// sorted for the same statement every time
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := args[name]
+		switch name {
+		case "first":
+			n, ok := graphQLInt(value)
+			if !ok || n < 1 {
+				return nil, fmt.Errorf("m: invalid GraphQL argument first %v", value)
+			}
+			q.Limit(n)
+			continue
+		case "skip":
+			n, ok := graphQLInt(value)
+			if !ok || n < 0 || n > 0 && m.Type == Cassandra {
+				return nil, fmt.Errorf("m: invalid GraphQL argument skip %v", value)
+			}
+			if n > 0 {
+				q.Offset(n)
+			}
+			continue
+		case "orderBy":
+			order, _ := value.(string)
+			i := strings.LastIndexByte(order, '_')
+			var c *columnMap
+			if i > 0 {
+				c = graphQLColumn(t, order[:i])
+			}
+			if c == nil || order[i+1:] != "ASC" && order[i+1:] != "DESC" {
+				return nil, fmt.Errorf("m: invalid GraphQL argument orderBy %v", value)
+			}
+			q.Order(c.Name + " " + order[i+1:])
+			continue
+		}
+
+		field, op := name, ""
+		if i := strings.LastIndexByte(name, '_'); i > 0 {
+			switch name[i+1:] {
+			case "ne", "gt", "gte", "lt", "lte", "in":
+				field, op = name[:i], name[i+1:]
+			}
+		}
+		c := graphQLColumn(t, field)
+		if c == nil && op != "" {
+			// a column with an underscore, like created_at
+			field, op = name, ""
+			c = graphQLColumn(t, field)
+		}
+		if c == nil {
+			return nil, fmt.Errorf("m: unknown GraphQL argument %s of %v", name, t.Type)
+		}
+		switch op {
+		case "":
+			q.Where(c.Name, value)
+		case "in":
+			v := reflect.ValueOf(value)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return nil, fmt.Errorf("m: GraphQL argument %s is not a list", name)
+			}
+			values := make([]interface{}, v.Len())
+			for i := range values {
+				values[i] = v.Index(i).Interface()
+			}
+			q.In(c.Name, values...)
+		default:
+			q.Where(c.Name+" "+map[string]string{"ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[op], value)
+		}
+	}
+	return q, nil
+}
+
+// graphQLColumn returns the column of t named name, or whose field is named name
+// ignoring case and underscores, or nil.
+func graphQLColumn(t *tableMap, name string) *columnMap {
+	if c := t.column(name); c != nil {
+		return c
+	}
+	normal := graphQLName(name)
+	for _, c := range t.Columns {
+		if graphQLName(c.Name) == normal || graphQLName(t.Type.FieldByIndex(c.Field).Name) == normal {
+			return c
+		}
+	}
+	return nil
+}
+
+// graphQLInt returns value, an integer argument, as an int. GraphQL libraries decode
+// integers as int, int32 or float64.
+func graphQLInt(value interface{}) (int, bool) {
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return int(v.Float()), v.Float() == float64(int(v.Float()))
+	}
+	return 0, false
+}
+
+func graphQLName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// Loader batches the loads of rows by the value of a column, so that resolving a field
+// of many parents, like the author of each post, selects the rows of a batch with a
+// single query instead of one query per parent, which is what dataloaders do for
+// GraphQL servers:
+//
+//	authors := M.NewLoader(User{}, "id")
+//	// in the resolver of Post.author
+//	users, err := authors.Load(ctx, post.AuthorID)
+//
+// The loads made within Wait of the first load of a batch make up the batch, which is
+// selected with the context of that first load. Loaders don't cache rows, a Loader per
+// request is the usual choice.
+type Loader struct {
+	Wait     time.Duration // a millisecond if zero
+	MaxBatch int           // the batch is selected when it has MaxBatch keys, if not zero
+
+	m      *Mapping
+	t      *tableMap
+	column *columnMap
+	mu     sync.Mutex
+	batch  *loaderBatch
+}
+
+type loaderBatch struct {
+	keys []interface{}
+	seen map[string]bool
+	rows map[string][]interface{} // by key
+	err  error
+	done chan struct{}
+}
+
+// NewLoader returns a Loader of the rows of the table for thing by column. It panics if
+// the table has no such column.
+func (m *Mapping) NewLoader(thing interface{}, column string) *Loader {
+	t := m.lookupTable(thing)
+	c := t.column(column)
+	if c == nil {
+		panic(fmt.Sprintf("No column %s in table %s", column, t.Name))
+	}
+	return &Loader{m: m, t: t, column: c}
+}
+
+// Load returns the rows whose column is key, once the batch of key has been selected.
+func (l *Loader) Load(ctx context.Context, key interface{}) ([]interface{}, error) {
+	l.mu.Lock()
+	b := l.batch
+	if b == nil {
+		b = &loaderBatch{seen: make(map[string]bool), done: make(chan struct{})}
+		l.batch = b
+		wait := l.Wait
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+		time.AfterFunc(wait, func() { l.run(ctx, b) })
+	}
+	k := loaderKey(key)
+	if !b.seen[k] {
+		b.seen[k] = true
+		b.keys = append(b.keys, key)
+	}
+	if l.MaxBatch > 0 && len(b.keys) >= l.MaxBatch {
+		go l.run(ctx, b)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.rows[k], b.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run selects the rows of b, unless it ran already.
+func (l *Loader) run(ctx context.Context, b *loaderBatch) {
+	l.mu.Lock()
+	if l.batch != b {
+		l.mu.Unlock()
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+
+	rows, err := l.m.Query(reflect.New(l.t.Type).Interface(), "*").In(l.column.Name, b.keys...).DoContext(ctx)
+	b.rows, b.err = make(map[string][]interface{}, len(b.keys)), err
+	for _, row := range rows {
+		k := loaderKey(reflect.Indirect(reflect.ValueOf(row)).FieldByIndex(l.column.Field).Interface())
+		b.rows[k] = append(b.rows[k], row)
+	}
+	close(b.done)
+}
+
+// loaderKey returns a key of value that is the same for values of different integer
+// types, as keys often aren't of the type of the field.
+func loaderKey(value interface{}) string {
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && !v.IsNil() {
+		value = v.Elem().Interface()
+	}
+	return fmt.Sprint(value)
+}
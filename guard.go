@@ -0,0 +1,162 @@
+package m
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LimitPolicy decides what happens to a SELECT from a guarded table that has no LIMIT.
+type LimitPolicy int
+
+const (
+	// RejectUnbounded makes unbounded SELECTs fail with UnboundedError.
+	RejectUnbounded LimitPolicy = iota
+	// CapUnbounded adds a LIMIT of the maximum number of rows to unbounded SELECTs.
+	CapUnbounded
+)
+
+type limitGuard struct {
+	Max    int
+	Policy LimitPolicy
+}
+
+// GuardUnbounded protects the table for thing from SELECTs that could return the whole
+// table: statements without a LIMIT, and Queries with a Limit over max, are rejected or
+// capped to max rows depending on policy. SelectCursor is not guarded.
+//
+//	M.GuardUnbounded(Event{}, 10000, m.CapUnbounded)
+func (m *Mapping) GuardUnbounded(thing interface{}, max int, policy LimitPolicy) {
+	m.lookupTable(thing).Limit = &limitGuard{Max: max, Policy: policy}
+}
+
+// UnboundedError is returned for a SELECT without a LIMIT from a table protected with
+// GuardUnbounded and RejectUnbounded.
+type UnboundedError struct {
+	Table string
+	Max   int
+}
+
+func (e *UnboundedError) Error() string {
+	return fmt.Sprintf("m: SELECT from %s must have a LIMIT of at most %d", e.Table, e.Max)
+}
+
+// guardQuery applies the LIMIT guard of t to query, written for dbt.
+func (t *tableMap) guardQuery(query string, dbt DBType) (string, error) {
+	if t.Limit == nil {
+		return query, nil
+	}
+	words := topLevelWords(query, dbt)
+	end := len(strings.TrimRight(query, "; \t\n"))
+	comment := -1
+	for i, w := range words {
+		if w.word != "--" {
+			comment = -1
+		}
+		switch {
+		case w.word == "--":
+			if comment < 0 {
+				comment = w.pos
+			}
+		case w.word == "LIMIT":
+			return query, nil
+		case i+1 < len(words) && afterLimit(w.word, words[i+1].word):
+			if w.pos < end {
+				end = w.pos
+			}
+		}
+	}
+	if t.Limit.Policy == RejectUnbounded {
+		return "", &UnboundedError{t.Name, t.Limit.Max}
+	}
+	if comment >= 0 && comment < end {
+		// A trailing comment would comment out the LIMIT.
+		end = comment
+	}
+	rest := query[end:]
+	if strings.TrimRight(rest, "; \t\n") != "" {
+		rest = " " + rest
+	}
+	return strings.TrimRight(query[:end], " \t\n") + " LIMIT " + strconv.Itoa(t.Limit.Max) + rest, nil
+}
+
+// afterLimit reports whether the words start a clause that comes after the LIMIT: a
+// locking clause such as FOR UPDATE or LOCK IN SHARE MODE, or ALLOW FILTERING.
+func afterLimit(word, next string) bool {
+	switch word {
+	case "ALLOW":
+		return next == "FILTERING"
+	case "FOR":
+		return next == "UPDATE" || next == "SHARE" || next == "NO" || next == "KEY"
+	case "LOCK":
+		return next == "IN"
+	}
+	return false
+}
+
+type sqlWord struct {
+	word string
+	pos  int
+}
+
+// topLevelWords returns the upper cased words of query outside of string literals,
+// quoted identifiers, comments and parentheses, so that the LIMIT of a subquery or a
+// string isn't taken for the LIMIT of the statement. Line comments are returned as "--".
+// Backslashes only escape quotes on MySQL, see skipQuoted.
+func topLevelWords(query string, dbt DBType) []sqlWord {
+	var words []sqlWord
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, c, dbt) + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			words = append(words, sqlWord{"--", i})
+			if n := strings.IndexByte(query[i:], '\n'); n >= 0 {
+				i += n
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if n := strings.Index(query[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(query)
+			}
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			if depth == 0 {
+				words = append(words, sqlWord{strings.ToUpper(query[i:j]), i})
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// guardLimit returns the limit that should be used for a Query from t with limit.
+func (t *tableMap) guardLimit(limit int) (int, error) {
+	if t.Limit == nil || (limit > 0 && limit <= t.Limit.Max) {
+		return limit, nil
+	}
+	if t.Limit.Policy == RejectUnbounded {
+		return 0, &UnboundedError{t.Name, t.Limit.Max}
+	}
+	return t.Limit.Max, nil
+}
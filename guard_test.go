@@ -0,0 +1,32 @@
+package m
+
+import "testing"
+
+func TestGuardQuery(t *testing.T) {
+	table := &tableMap{Name: "events", Limit: &limitGuard{Max: 10, Policy: CapUnbounded}}
+	for _, tt := range []struct {
+		dbt         DBType
+		query, want string
+	}{
+		{PostgreSQL, "SELECT * FROM events", "SELECT * FROM events LIMIT 10"},
+		{PostgreSQL, "SELECT * FROM events;", "SELECT * FROM events LIMIT 10;"},
+		{PostgreSQL, "SELECT * FROM events LIMIT 3", "SELECT * FROM events LIMIT 3"},
+		{PostgreSQL, "SELECT * FROM events WHERE name = 'limit'", "SELECT * FROM events WHERE name = 'limit' LIMIT 10"},
+		{PostgreSQL, "SELECT * FROM events WHERE id IN (SELECT id FROM seen LIMIT 2)", "SELECT * FROM events WHERE id IN (SELECT id FROM seen LIMIT 2) LIMIT 10"},
+		{PostgreSQL, "SELECT * FROM events WHERE name = 'it''s (' FOR UPDATE", "SELECT * FROM events WHERE name = 'it''s (' LIMIT 10 FOR UPDATE"},
+		{MySQL, "SELECT * FROM events LOCK IN SHARE MODE", "SELECT * FROM events LIMIT 10 LOCK IN SHARE MODE"},
+		{Cassandra, "SELECT * FROM events WHERE kind = ? ALLOW FILTERING", "SELECT * FROM events WHERE kind = ? LIMIT 10 ALLOW FILTERING"},
+		{PostgreSQL, "SELECT * FROM events -- limit\n", "SELECT * FROM events LIMIT 10 -- limit\n"},
+		{SQLite, `SELECT * FROM events WHERE name LIKE ? ESCAPE '\'`, `SELECT * FROM events WHERE name LIKE ? ESCAPE '\' LIMIT 10`},
+		{SQLite, `SELECT * FROM events WHERE name LIKE ? ESCAPE '\' LIMIT 3`, `SELECT * FROM events WHERE name LIKE ? ESCAPE '\' LIMIT 3`},
+		{MySQL, `SELECT * FROM events WHERE name = 'it\'s limit'`, `SELECT * FROM events WHERE name = 'it\'s limit' LIMIT 10`},
+	} {
+		got, err := table.guardQuery(tt.query, tt.dbt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("guardQuery(%q, %v) = %q, want %q", tt.query, tt.dbt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SetHedging hedges idempotent SELECT statements outside of transactions: if a query
+// hasn't returned its first rows after delay, it is sent a second time, to the next
+// replica if there are replicas or on another connection otherwise, and the rows of the
+// first query to return are used. The other query is cancelled. delay is usually around
+// the 95th percentile latency of the queries, zero disables hedging.
+func (m *Mapping) SetHedging(delay time.Duration) {
+	m.hedgeDelay = delay
+}
+
+// queryRows runs the query s with q, hedged if hedging is enabled. done must be called
+// after the rows have been closed.
+func (m *Mapping) queryRows(ctx context.Context, s *Statement, q querier) (rows *sql.Rows, done func(), err error) {
+	if m.hedgeDelay <= 0 || s.Op != OpSelect || !s.Idempotent || m.tx != nil {
+		rows, err = q.QueryContext(ctx, s.SQL, s.Args...)
+		return rows, func() {}, err
+	}
+
+	type result struct {
+		i    int
+		rows *sql.Rows
+		err  error
+	}
+	results := make(chan result, 2)
+	var cancels []context.CancelFunc
+	start := func(q querier) {
+		ctx, cancel := context.WithCancel(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+			results <- result{i, rows, err}
+		}()
+	}
+
+	start(q)
+	timer := time.NewTimer(m.hedgeDelay)
+	select {
+	case r := <-results:
+		timer.Stop()
+		return r.rows, cancels[0], r.err
+	case <-timer.C:
+	}
+	start(m.prepared(m.route(ctx, s)))
+
+	r := <-results
+	if r.err != nil {
+		// the other query may still succeed
+		cancels[r.i]()
+		r = <-results
+		return r.rows, cancels[r.i], r.err
+	}
+	loser := 1 - r.i
+	cancels[loser]()
+	go func() {
+		if l := <-results; l.rows != nil {
+			l.rows.Close()
+		}
+	}()
+	return r.rows, cancels[r.i], nil
+}
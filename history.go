@@ -0,0 +1,138 @@
+package m
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// History column names. The mapped struct of a table with history needs a time.Time
+// valid_from field, its history table has the same columns plus valid_to.
+const (
+	ValidFrom = "valid_from"
+	ValidTo   = "valid_to"
+)
+
+type historyMap struct {
+	Table string
+	AsOf  string // derived table of current and past rows for AsOf queries
+}
+
+// SetHistory keeps the old versions of the rows of the table for thing in the history
+// table. Insert sets valid_from to now if it is zero and Update sets it to now, while
+// Update, UpdateKey, Replace, Delete and DeleteWhere first copy the rows they change
+// into the history table with valid_to set to now, in the same transaction. Query.AsOf
+// queries the rows as they were at a point in time.
+//
+//	M.SetHistory(Price{}, "prices_history")
+func (m *Mapping) SetHistory(thing interface{}, table string) {
+	t := m.lookupTable(thing)
+	if m.Type == Cassandra {
+		panic(fmt.Sprintf("History tables are not supported on Cassandra, for type: %v", t.Type))
+	}
+	if t.column(ValidFrom) == nil {
+		panic(fmt.Sprintf("No %s column for type: %v", ValidFrom, t.Type))
+	}
+
+	b := getBuffer()
+	b.WriteString("(SELECT ")
+	t.writeColumns(b, m.Type)
+	b.WriteString(", NULL AS ")
+	writeIdent(b, ValidTo, m.Type)
+	b.WriteString(" FROM ")
+	writeIdent(b, t.Name, m.Type)
+	b.WriteString(" UNION ALL SELECT ")
+	t.writeColumns(b, m.Type)
+	b.WriteString(", ")
+	writeIdent(b, ValidTo, m.Type)
+	b.WriteString(" FROM ")
+	writeIdent(b, table, m.Type)
+	b.WriteString(") AS ")
+	writeIdent(b, t.Name, m.Type)
+	t.History = &historyMap{Table: table, AsOf: putBuffer(b)}
+}
+
+// AsOf makes the query return the rows as they were at the given time, from the table
+// and its history table, see SetHistory.
+func (q *Query) AsOf(at time.Time) *Query {
+	if q.t.History == nil {
+		q.fail(fmt.Errorf("m: no history table for %v", q.t.Type))
+		return q
+	}
+	q.from = q.t.History.AsOf
+	q.conditions = append(q.conditions, ValidFrom+" <= ?", "("+ValidTo+" IS NULL OR "+ValidTo+" > ?)")
+	q.bindings = append(q.bindings, at, at)
+	return q
+}
+
+// versioned calls fn to change the rows of t matching condition, after copying them
+// into the history table of t. The placeholders of condition are for bindings.
+func (m *Mapping) versioned(ctx context.Context, t *tableMap, condition string, bindings []interface{}, now time.Time, fn func(*Mapping) error) error {
+	if t.History == nil {
+		return fn(m)
+	}
+	return m.inTx(ctx, func(m *Mapping) error {
+		b := getBuffer()
+		b.WriteString("INSERT INTO ")
+		writeIdent(b, t.History.Table, m.Type)
+		b.WriteString(" (")
+		t.writeColumns(b, m.Type)
+		b.WriteString(", ")
+		writeIdent(b, ValidTo, m.Type)
+		b.WriteString(") SELECT ")
+		t.writeColumns(b, m.Type)
+		b.WriteString(", ")
+		// numbered placeholders can go after the bindings of condition, ? can't
+		args := append([]interface{}{now}, bindings...)
+		if m.Type == PostgreSQL {
+			writePlaceholder(b, len(bindings), m.Type)
+			args = append(bindings[:len(bindings):len(bindings)], now)
+		} else {
+			writePlaceholder(b, 0, m.Type)
+		}
+		b.WriteString(" FROM ")
+		writeIdent(b, t.Name, m.Type)
+		if condition != "" {
+			b.WriteString(" WHERE ")
+			b.WriteString(condition)
+		}
+		if _, err := m.exec(ctx, t, &Statement{Op: OpInsert, Table: t.History.Table, SQL: putBuffer(b), Args: args}); err != nil {
+			return err
+		}
+		return fn(m)
+	})
+}
+
+// keyCondition returns the condition matching keys, with placeholders from zero.
+func keyCondition(keys []string, dbt DBType) string {
+	b := getBuffer()
+	writeColumnPlaceholders(b, keys, 0, " AND ", dbt)
+	return putBuffer(b)
+}
+
+// inTx calls fn with m if it is a transaction, and otherwise with a new transaction that
+// is committed if fn succeeds.
+func (m *Mapping) inTx(ctx context.Context, fn func(*Mapping) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+	tx, err := m.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = fn(tx.Mapping); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *tableMap) writeColumns(b *bytes.Buffer, dbt DBType) {
+	for i, c := range t.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeIdent(b, c.Name, dbt)
+	}
+}
@@ -0,0 +1,63 @@
+package m
+
+// BeforeInserter is implemented by structs that need to be prepared before they are
+// inserted, for example to set timestamps or validate fields. It is called by Insert,
+// InsertMany, Replace and Upsert, and an error aborts the insert.
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+// AfterInserter is implemented by structs that are notified after they were inserted.
+// An error is returned by the insert, although the row has been written.
+type AfterInserter interface {
+	AfterInsert() error
+}
+
+// BeforeUpdater is implemented by structs that need to be prepared before they are
+// updated by Update or UpdateKey. data holds the columns about to be set and can be
+// modified, for example to set an updated_at column. An error aborts the update.
+type BeforeUpdater interface {
+	BeforeUpdate(data map[string]interface{}) error
+}
+
+// AfterSelecter is implemented by structs that need to be fixed up after they are
+// scanned from a row, for example to normalize or derive fields. An error stops the
+// query and is returned.
+type AfterSelecter interface {
+	AfterSelect() error
+}
+
+func beforeInsert(thing interface{}) error {
+	if h, ok := thing.(BeforeInserter); ok {
+		return h.BeforeInsert()
+	}
+	return nil
+}
+
+func afterInsert(thing interface{}) error {
+	if h, ok := thing.(AfterInserter); ok {
+		return h.AfterInsert()
+	}
+	return nil
+}
+
+// beforeUpdate calls the BeforeUpdate hook of thing with a copy of data, so that the
+// map of the caller isn't modified, and returns the copy.
+func beforeUpdate(thing interface{}, data map[string]interface{}) (map[string]interface{}, error) {
+	h, ok := thing.(BeforeUpdater)
+	if !ok {
+		return data, nil
+	}
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c, h.BeforeUpdate(c)
+}
+
+func afterSelect(thing interface{}) error {
+	if h, ok := thing.(AfterSelecter); ok {
+		return h.AfterSelect()
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// inList is an IN condition added by In.
+type inList struct {
+	column    string
+	condition int // index in conditions
+	binding   int // index of the first value in bindings
+	n         int
+}
+
+// split returns copies of q that each have a part of the longest IN list of q, or nil
+// if q has few enough bindings to run as is.
+func (q *Query) split() ([]*Query, error) {
+	args := len(q.bindings) + len(q.havingArgs)
+	if args <= maxBindings || len(q.ins) == 0 {
+		return nil, nil
+	}
+	in := q.ins[0]
+	for _, x := range q.ins[1:] {
+		if x.n > in.n {
+			in = x
+		}
+	}
+	room := maxBindings - (args - in.n)
+	switch {
+	case room < 1:
+		return nil, fmt.Errorf("m: query on %s has %d bindings, more than a statement can have", q.t.Name, args)
+	case len(q.joins) > 0 || q.as != nil || q.groupBy != "" || q.order != "" || q.offset > 0 || q.sample > 0:
+		// the rows of the parts can't be merged in order or grouped
+		return nil, fmt.Errorf("m: IN list of %d values on %s can't be split with joins, groups, orders or offsets", in.n, q.t.Name)
+	}
+
+	// the condition may have been extended with OR conditions after the list
+	condition := q.conditions[in.condition]
+	rest := condition[len(in.column+" IN ("+questionMarks(in.n)+")"):]
+	values := q.bindings[in.binding : in.binding+in.n]
+	var parts []*Query
+	for start := 0; start < len(values); start += room {
+		end := start + room
+		if end > len(values) {
+			end = len(values)
+		}
+		p := *q
+		p.ins = nil
+		p.conditions = append([]string(nil), q.conditions...)
+		p.conditions[in.condition] = in.column + " IN (" + questionMarks(end-start) + ")" + rest
+		p.bindings = make([]interface{}, 0, len(q.bindings)-in.n+end-start)
+		p.bindings = append(p.bindings, q.bindings[:in.binding]...)
+		p.bindings = append(p.bindings, values[start:end]...)
+		p.bindings = append(p.bindings, q.bindings[in.binding+in.n:]...)
+		parts = append(parts, &p)
+	}
+	return parts, nil
+}
+
+// doParts runs parts, returned by split, and merges their rows. Rows matched by more
+// than one part, through duplicate values or OR conditions, are returned once if q
+// selects the primary key, as rows without it can't be told apart.
+func (q *Query) doParts(ctx context.Context, parts []*Query) ([]interface{}, error) {
+	key := q.t.primaryKey()
+	keyed := len(key) > 0 && q.selects(key)
+	seen := make(map[string]bool)
+	var rows []interface{}
+	for _, p := range parts {
+		found, err := q.m.doSelect(ctx, p.t, p.String(), p.args()...)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range found {
+			if keyed {
+				key := diffKey(q.m.lookupTable(row), row)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			rows = append(rows, row)
+			if q.limit > 0 && len(rows) == q.limit {
+				return rows, nil
+			}
+		}
+	}
+	return rows, nil
+}
+
+// selects reports whether q selects all of columns, either with * or by name.
+func (q *Query) selects(columns []string) bool {
+	if q.columns == "*" {
+		return true
+	}
+	selected := make(map[string]bool)
+	for _, c := range strings.Split(q.columns, ",") {
+		c = strings.TrimSpace(c)
+		if i := strings.LastIndexByte(c, '.'); i >= 0 {
+			if c[i+1:] == "*" {
+				return true
+			}
+			c = c[i+1:]
+		}
+		selected[strings.Trim(c, "\"`")] = true
+	}
+	for _, c := range columns {
+		if !selected[c] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+)
+
+// autoIncrementKey returns the column and field of thing whose value the database
+// should generate on insert, if the field is zero. It is the column tagged
+// autoincrement:
+//
+//	ID int64 `db:"id,pk,autoincrement"`
+//
+// The id is read back with RETURNING on PostgreSQL and with LastInsertId on MySQL and
+// SQLite. Untagged keys are inserted as they are, so that zero and ids assigned by the
+// application are kept. The column is left out of the insert so that the database
+// doesn't store an explicit zero.
+func (m *Mapping) autoIncrementKey(t *tableMap, thing interface{}) (*columnMap, reflect.Value) {
+	if m.Type == Cassandra {
+		return nil, reflect.Value{}
+	}
+	var c *columnMap
+	for _, col := range t.Columns {
+		if col.AutoIncrement {
+			c = col
+			break
+		}
+	}
+	if c == nil {
+		return nil, reflect.Value{}
+	}
+	field := reflect.Indirect(reflect.ValueOf(thing)).FieldByIndex(c.Field)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+	default:
+		return nil, reflect.Value{}
+	}
+	if !field.CanSet() || !field.IsZero() {
+		return nil, reflect.Value{}
+	}
+	return c, field
+}
+
+// setInsertID sets field to the id generated by the database for the insert res.
+func setInsertID(field reflect.Value, res sql.Result) error {
+	id, err := res.LastInsertId()
+	if err != nil || id == 0 {
+		return err
+	}
+	assign(field, id)
+	return nil
+}
+
+// insertReturning runs the PostgreSQL insert s with a RETURNING clause for key and sets
+// field to the generated value.
+func (m *Mapping) insertReturning(ctx context.Context, t *tableMap, s *Statement, key *columnMap, field reflect.Value) error {
+	s.SQL += " RETURNING " + key.Name
+	var id int64
+	err := m.run(ctx, s, t.WriteTimeout, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if rows.Next() {
+			err = rows.Scan(&id)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		return err
+	})
+	if err == nil && id != 0 {
+		assign(field, id)
+	}
+	return err
+}
+
+// withoutColumn removes column and its value from an insert.
+func withoutColumn(columns []string, values []interface{}, column string) ([]string, []interface{}) {
+	for i, c := range columns {
+		if c == column {
+			return append(columns[:i:i], columns[i+1:]...), append(values[:i:i], values[i+1:]...)
+		}
+	}
+	return columns, values
+}
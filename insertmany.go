@@ -0,0 +1,181 @@
+package m
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// DefaultInsertChunk is the number of rows InsertMany inserts per statement unless
+// SetInsertChunk is called.
+const DefaultInsertChunk = 500
+
+// maxBindings is the most bindings a statement can have on any of the SQL databases.
+const maxBindings = 32766
+
+// SetInsertChunk sets the number of rows InsertMany inserts per statement.
+func (m *Mapping) SetInsertChunk(rows int) {
+	m.insertChunk = rows
+}
+
+// InsertMany inserts things with multi-row INSERT statements, or batches on Cassandra.
+// Consecutive things of the same type that set the same columns share a statement, up
+// to the chunk size. Keys generated by the database are not set on the structs, and
+// rows of append-only tables are inserted one at a time so that their sequence is, as
+// are rows of tables with an insert statement set with SetStatements. The statements
+// are not atomic together unless InsertMany is called on a Tx.
+func (m *Mapping) InsertMany(things []interface{}) error {
+	return m.InsertManyContext(context.Background(), things)
+}
+
+// InsertManyContext is like InsertMany but runs the statements with ctx.
+func (m *Mapping) InsertManyContext(ctx context.Context, things []interface{}) (err error) {
+	defer m.recover(&err)
+	return m.insertMany(ctx, things, false)
+}
+
+// insertMany runs InsertMany. If upsert is true rows are upserted as they are instead,
+// see Upsert.
+func (m *Mapping) insertMany(ctx context.Context, things []interface{}, upsert bool) error {
+	chunk := m.insertChunk
+	if chunk <= 0 {
+		chunk = DefaultInsertChunk
+	}
+
+	var t *tableMap
+	var columns []string
+	var rows []interface{}
+	var values []interface{}
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		err := m.insertRows(ctx, t, columns, len(rows), values, upsert)
+		if err == nil {
+			for _, thing := range rows {
+				thing := thing
+				m.cached(t, func(c *TableCache) { c.putRow(thing) })
+			}
+			for _, thing := range rows {
+				if err = afterInsert(thing); err != nil {
+					break
+				}
+			}
+		}
+		rows, values = rows[:0], values[:0]
+		return err
+	}
+
+	now := time.Now()
+	for _, thing := range things {
+		rowTable := m.lookupTable(thing)
+		if rowTable.View {
+			return ErrReadOnly
+		}
+		thing = addressable(rowTable, thing)
+		if (rowTable.Sequence != "" || rowTable.statement(OpInsert) != nil) && !upsert {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := m.insert(ctx, rowTable, thing); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := beforeInsert(thing); err != nil {
+			return err
+		}
+		thing = m.setExpiry(rowTable, thing)
+		thing = setTimestamps(rowTable, thing, now)
+		thing = setDefaults(rowTable, thing)
+		if rowTable.History != nil {
+			thing = setTimeIfZero(rowTable, thing, rowTable.column(ValidFrom), now)
+		}
+		if err := m.validate(thing); err != nil {
+			return err
+		}
+		rowColumns, rowValues, err := prepareInsertSqlColumnsValues(thing, rowTable)
+		if err != nil {
+			return err
+		}
+		if key, _ := m.autoIncrementKey(rowTable, thing); key != nil && !upsert {
+			rowColumns, rowValues = withoutColumn(rowColumns, rowValues, key.Name)
+		}
+
+		if rowTable != t || !sameColumns(rowColumns, columns) || len(rows) >= chunk || len(values)+len(rowValues) > maxBindings {
+			if err := flush(); err != nil {
+				return err
+			}
+			t, columns = rowTable, rowColumns
+		}
+		rows = append(rows, thing)
+		values = append(values, rowValues...)
+	}
+	return flush()
+}
+
+// insertRows inserts or upserts n rows of values for columns into t.
+func (m *Mapping) insertRows(ctx context.Context, t *tableMap, columns []string, n int, values []interface{}, upsert bool) error {
+	build := sqlInsertString
+	if upsert {
+		build = func(table string, columns []string, dbt DBType) (string, error) {
+			return sqlUpsertKeepString(table, columns, t.primaryKey(), t.createdColumns(), dbt)
+		}
+	}
+	query, err := build(t.Name, columns, m.Type)
+	if err != nil {
+		return err
+	}
+	if using := m.using(ctx, t); using != "" {
+		query += " " + using
+	}
+
+	if len(columns) == 0 {
+		for i := 0; i < n; i++ {
+			if _, err = m.exec(ctx, t, &Statement{Op: OpInsert, Table: t.Name, SQL: query}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	b := getBuffer()
+	if m.Type == Cassandra {
+		b.WriteString("BEGIN UNLOGGED BATCH ")
+		for i := 0; i < n; i++ {
+			b.WriteString(query)
+			b.WriteString("; ")
+		}
+		b.WriteString("APPLY BATCH")
+	} else {
+		// repeat the row of placeholders, keeping what follows it, like ON CONFLICT
+		start := strings.LastIndex(query, " VALUES (")
+		end := start + strings.IndexByte(query[start:], ')') + 1
+		b.WriteString(query[:start])
+		b.WriteString(" VALUES ")
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteByte('(')
+			writePlaceholders(b, i*len(columns), len(columns), m.Type)
+			b.WriteByte(')')
+		}
+		b.WriteString(query[end:])
+	}
+	_, err = m.exec(ctx, t, &Statement{Op: OpInsert, Table: t.Name, SQL: putBuffer(b), Args: values, Idempotent: upsert || m.Type == Cassandra})
+	return err
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
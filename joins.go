@@ -0,0 +1,150 @@
+package m
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type joinClause struct {
+	sql string // the JOIN clause
+	t   *tableMap
+}
+
+// Join joins the table for thing to the query with an INNER JOIN on the condition on.
+// The returned rows are structs of the queried table unless As is called, and the
+// columns of the joined tables can be used in conditions, qualified by their table:
+//
+//	M.Query(Post{}, "*").Join(User{}, "users.id = posts.author_id").Where("users.name =", name)
+//
+// A table can only be joined once, as it isn't aliased. Joins are not supported on
+// Cassandra.
+func (q *Query) Join(thing interface{}, on string) *Query {
+	return q.join("JOIN", thing, on)
+}
+
+// InnerJoin is the same as Join.
+func (q *Query) InnerJoin(thing interface{}, on string) *Query {
+	return q.join("INNER JOIN", thing, on)
+}
+
+// LeftJoin is like Join but uses a LEFT JOIN, the columns of the joined table are null
+// for rows without a match.
+func (q *Query) LeftJoin(thing interface{}, on string) *Query {
+	return q.join("LEFT JOIN", thing, on)
+}
+
+func (q *Query) join(kind string, thing interface{}, on string) *Query {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: joins are not supported on Cassandra"))
+		return q
+	}
+	t, err := q.m.table(thing)
+	if err != nil {
+		q.fail(err)
+		return q
+	}
+	b := getBuffer()
+	b.WriteString(kind)
+	b.WriteByte(' ')
+	writeIdent(b, t.Name, q.m.Type)
+	b.WriteString(" ON ")
+	b.WriteString(on)
+	q.joins = append(q.joins, joinClause{sql: putBuffer(b), t: t})
+	return q
+}
+
+// As makes a query with joins return rows of result, a struct whose fields tagged
+// inline hold the rows of the queried and the joined tables, matched by their types. The
+// prefix in the tag of each field keeps the columns of the tables apart:
+//
+//	type PostWithAuthor struct {
+//		Post   Post `db:"post_,inline"`
+//		Author User `db:"author_,inline"`
+//	}
+//	rows, err := M.Query(Post{}, "*").Join(User{}, "users.id = posts.author_id").As(PostWithAuthor{}).Do()
+//
+// The columns of a LEFT JOINed table need pointer or sql.Null fields to scan rows
+// without a match. A result without inline fields is scanned from the selected columns,
+// matched by the db tags of its fields, which suits grouped queries, see Into.
+func (q *Query) As(result interface{}) *Query {
+	typ, err := typeOf(result)
+	if err != nil {
+		q.fail(err)
+		return q
+	}
+	q.as = typ
+	return q
+}
+
+// joined returns a copy of q that selects the columns of the queried table, or of every
+// table if As was called, to be scanned into its table.
+func (q *Query) joined() (*Query, error) {
+	j := *q
+	if q.as == nil {
+		if q.columns == "*" {
+			b := getBuffer()
+			writeIdent(b, q.t.Name, q.m.Type)
+			b.WriteString(".*")
+			j.columns = putBuffer(b)
+		}
+		return &j, nil
+	}
+
+	tables := []*tableMap{q.t}
+	for _, join := range q.joins {
+		tables = append(tables, join.t)
+	}
+	b := getBuffer()
+	for i := 0; i < q.as.NumField(); i++ {
+		field := q.as.Field(i)
+		tag := strings.Split(field.Tag.Get("db"), ",")
+		if !contains(tag[1:], "inline") {
+			continue
+		}
+		var t *tableMap
+		for _, x := range tables {
+			if x.Type == field.Type {
+				t = x
+			}
+		}
+		if t == nil {
+			putBuffer(b)
+			return nil, fmt.Errorf("m: field %s of %v isn't a queried or joined table", field.Name, q.as)
+		}
+		for _, c := range t.Columns {
+			if b.Len() > 0 {
+				b.WriteString(", ")
+			}
+			writeIdent(b, t.Name, q.m.Type)
+			b.WriteByte('.')
+			writeIdent(b, c.Name, q.m.Type)
+			b.WriteString(" AS ")
+			writeIdent(b, tag[0]+c.Name, q.m.Type)
+		}
+	}
+	// a result without tables, such as the groups of GroupBy, is scanned from the
+	// selected columns
+	if columns := putBuffer(b); columns != "" {
+		j.columns = columns
+	}
+	j.t = q.t.result(q.as, q.m.registry)
+	return &j, nil
+}
+
+// result returns a copy of t that scans rows into typ, with the limits and timeouts of
+// t and the converters of r.
+func (t *tableMap) result(typ reflect.Type, r *Registry) *tableMap {
+	rt := *t
+	rt.Type = typ
+	rt.Columns = getTableColumns(nil, typ)
+	setCodecs(rt.Columns, r.codec)
+	setConverters(typ, rt.Columns, r.converters)
+	for _, c := range rt.Columns {
+		// the discriminator of a joined table doesn't pick the type of the result
+		c.Discriminator = false
+	}
+	rt.Relations = nil
+	rt.Views = nil
+	return &rt
+}
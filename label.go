@@ -0,0 +1,41 @@
+package m
+
+import (
+	"context"
+	"strings"
+)
+
+type labelKey struct{}
+
+// WithLabel returns a copy of ctx that labels the statements executed with it, like
+// "batch:reindex" or the name of an endpoint, so that the load on the database can be
+// attributed to workloads. The label is set as the Label of each Statement, for
+// policies, loggers and stats collectors, and prepended to its SQL as a comment, which
+// shows up in pg_stat_activity, the slow query log and similar tools:
+//
+//	ctx := m.WithLabel(ctx, "batch:reindex")
+//	err := M.SelectCursorContext(ctx, Post{}, 1000, reindex, "SELECT * FROM posts")
+//
+// Labels should come from a small set, as each label makes different statements for
+// the statement cache and the database. To label whole connections, which doesn't
+// change the statements, see ConnSettings.ApplicationName.
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+// Label returns the label of ctx, or "" if it has none, see WithLabel.
+func Label(ctx context.Context) string {
+	label, _ := ctx.Value(labelKey{}).(string)
+	return label
+}
+
+// label sets the label of ctx on s.
+func label(ctx context.Context, s *Statement) {
+	l := Label(ctx)
+	if l == "" || s.Label != "" {
+		return
+	}
+	s.Label = l
+	// the label can't end the comment early
+	s.SQL = "/* " + strings.ReplaceAll(l, "*/", "* /") + " */ " + s.SQL
+}
@@ -0,0 +1,240 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// LeaderElector elects a single leader among the instances of an application, for work
+// like scheduling that must only run in one place at a time. On PostgreSQL and MySQL the
+// leader holds a session advisory lock on a dedicated connection, on Cassandra it holds
+// a row in a lease table that it keeps alive with lightweight transaction heartbeats.
+//
+//	e := M.NewLeaderElector("scheduler", hostname)
+//	e.OnElected = scheduler.Start
+//	e.OnLost = scheduler.Stop
+//	e.Start()
+//	defer e.Stop()
+type LeaderElector struct {
+	Name  string        // name of the election, instances with the same name compete
+	ID    string        // identifies this instance in the Cassandra lease table
+	Lease time.Duration // how long a Cassandra lease lives without being renewed
+	Renew time.Duration // time between renewals, and between attempts to become leader
+
+	// Table is the Cassandra lease table, it needs a name text primary key and a holder
+	// text column.
+	Table string
+
+	// OnElected, OnRenewed and OnLost are called, if not nil, when this instance becomes
+	// leader, renews its lease and stops being leader, including when Stop is called.
+	OnElected func()
+	OnRenewed func()
+	OnLost    func()
+
+	// Errors receives errors that happen while electing in the background, if it is not
+	// nil. Errors are dropped if nobody is receiving.
+	Errors chan<- error
+
+	m      *Mapping
+	mu     sync.Mutex
+	leader bool
+	conn   *sql.Conn
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewLeaderElector returns a LeaderElector for the election name, with id identifying
+// this instance. An election outlives transactions, so the elector of a Tx holds its lock
+// outside of the transaction.
+func (m *Mapping) NewLeaderElector(name, id string) *LeaderElector {
+	return &LeaderElector{
+		Name:  name,
+		ID:    id,
+		Lease: 15 * time.Second,
+		Renew: 5 * time.Second,
+		Table: "leases",
+		m:     m,
+	}
+}
+
+// IsLeader reports whether this instance is currently the leader.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Start starts campaigning in the background.
+func (e *LeaderElector) Start() {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.Renew)
+		defer ticker.Stop()
+		for {
+			e.tick()
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				e.resign()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops campaigning, and gives up leadership if this instance is the leader.
+func (e *LeaderElector) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *LeaderElector) tick() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Renew)
+	defer cancel()
+
+	if e.IsLeader() {
+		if err := e.renew(ctx); err != nil {
+			e.report(err)
+			e.lost()
+		} else if e.OnRenewed != nil {
+			e.OnRenewed()
+		}
+		return
+	}
+
+	elected, err := e.acquire(ctx)
+	if err != nil {
+		e.report(err)
+		return
+	}
+	if elected {
+		e.mu.Lock()
+		e.leader = true
+		e.mu.Unlock()
+		if e.OnElected != nil {
+			e.OnElected()
+		}
+	}
+}
+
+func (e *LeaderElector) resign() {
+	if !e.IsLeader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.Renew)
+	defer cancel()
+	if err := e.release(ctx); err != nil {
+		e.report(err)
+	}
+	e.lost()
+}
+
+func (e *LeaderElector) lost() {
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	if e.OnLost != nil {
+		e.OnLost()
+	}
+}
+
+func (e *LeaderElector) report(err error) {
+	if e.Errors != nil {
+		select {
+		case e.Errors <- err:
+		default:
+		}
+	}
+}
+
+func (e *LeaderElector) acquire(ctx context.Context) (bool, error) {
+	if e.m.Type == Cassandra {
+		return e.m.applied(ctx, nil, &Statement{
+			Op:   OpInsert,
+			SQL:  fmt.Sprintf("INSERT INTO %s (name, holder) VALUES (?, ?) IF NOT EXISTS USING TTL %d", e.Table, e.ttl()),
+			Args: []interface{}{e.Name, e.ID},
+		})
+	}
+
+	if e.m.Type == SQLite {
+		return false, fmt.Errorf("m: leader election is not supported on SQLite")
+	}
+
+	// advisory locks belong to the session, so the leader keeps its connection
+	conn, err := e.m.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	if e.m.Type == MySQL {
+		err = conn.QueryRowContext(ctx, "SELECT COALESCE(GET_LOCK(?, 0), 0) = 1", e.Name).Scan(&locked)
+	} else {
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.lockKey()).Scan(&locked)
+	}
+	if err != nil || !locked {
+		conn.Close()
+		return false, err
+	}
+	e.conn = conn
+	return true, nil
+}
+
+func (e *LeaderElector) renew(ctx context.Context) error {
+	if e.m.Type == Cassandra {
+		applied, err := e.m.applied(ctx, nil, &Statement{
+			Op:   OpUpdate,
+			SQL:  fmt.Sprintf("UPDATE %s USING TTL %d SET holder = ? WHERE name = ? IF holder = ?", e.Table, e.ttl()),
+			Args: []interface{}{e.ID, e.Name, e.ID},
+		})
+		if err == nil && !applied {
+			err = fmt.Errorf("m: lease %s was taken over", e.Name)
+		}
+		return err
+	}
+	// the lock is held as long as the session is alive
+	return e.conn.PingContext(ctx)
+}
+
+func (e *LeaderElector) release(ctx context.Context) error {
+	if e.m.Type == Cassandra {
+		_, err := e.m.applied(ctx, nil, &Statement{
+			Op:   OpDelete,
+			SQL:  fmt.Sprintf("DELETE FROM %s WHERE name = ? IF holder = ?", e.Table),
+			Args: []interface{}{e.Name, e.ID},
+		})
+		return err
+	}
+	var err error
+	if e.m.Type == MySQL {
+		_, err = e.conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", e.Name)
+	} else {
+		_, err = e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.lockKey())
+	}
+	return err
+}
+
+// ttl returns the Cassandra lease duration in whole seconds.
+func (e *LeaderElector) ttl() int64 {
+	ttl := int64(e.Lease / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// lockKey returns the PostgreSQL advisory lock key for the election.
+func (e *LeaderElector) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	return int64(h.Sum64())
+}
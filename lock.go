@@ -0,0 +1,172 @@
+package m
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DefaultLockTable is the table used by Lock unless SetLockTable is called.
+const DefaultLockTable = "locks"
+
+// Lock is a named lock held in the lock table. It is released by Unlock, or expires on
+// its own when its ttl runs out, so that a crashed holder doesn't keep it forever.
+//
+// The lock table needs a name text primary key and a holder text column, plus an
+// expires_at timestamp column on SQL databases, where expired locks are taken over with
+// an upsert. On Cassandra locks are inserted with a lightweight transaction and a TTL.
+type Lock struct {
+	Name string
+
+	holder string
+	m      *Mapping
+}
+
+// SetLockTable sets the table used by Lock.
+func (m *Mapping) SetLockTable(name string) {
+	m.lockTable = name
+}
+
+// Lock takes the lock name for ttl. It doesn't wait, ErrLocked is returned if the lock
+// is held by someone else.
+func (m *Mapping) Lock(name string, ttl time.Duration) (*Lock, error) {
+	return m.LockContext(context.Background(), name, ttl)
+}
+
+// LockContext is like Lock but runs the statement with ctx.
+func (m *Mapping) LockContext(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	l := &Lock{Name: name, holder: hex.EncodeToString(token[:]), m: m}
+	ok, err := l.set(ctx, ttl, false)
+	if err == nil && !ok {
+		err = ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Refresh extends the lock to expire ttl from now. ErrLocked is returned if the lock
+// has expired and was taken by someone else in the meantime.
+func (l *Lock) Refresh(ttl time.Duration) error {
+	ok, err := l.set(context.Background(), ttl, true)
+	if err == nil && !ok {
+		err = ErrLocked
+	}
+	return err
+}
+
+// Unlock releases the lock. It does nothing if the lock has expired and was taken by
+// someone else.
+func (l *Lock) Unlock() error {
+	s := &Statement{Op: OpDelete, Table: l.m.locks(), Args: []interface{}{l.Name, l.holder}, Idempotent: true}
+	if l.m.Type == Cassandra {
+		s.SQL = fmt.Sprintf("DELETE FROM %s WHERE name = ? IF holder = ?", s.Table)
+		_, err := l.m.applied(context.Background(), nil, s)
+		return err
+	}
+	s.SQL, _ = sqlDeleteString(s.Table, []string{"name", "holder"}, l.m.Type)
+	_, err := l.m.exec(context.Background(), nil, s)
+	return err
+}
+
+// set takes the lock, or extends it if refresh is true, and reports whether it succeeded.
+func (l *Lock) set(ctx context.Context, ttl time.Duration, refresh bool) (bool, error) {
+	table := l.m.locks()
+	if l.m.Type == Cassandra {
+		seconds := int64(ttl / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		s := &Statement{Op: OpInsert, Table: table, Args: []interface{}{l.Name, l.holder}}
+		if refresh {
+			s.Op = OpUpdate
+			s.SQL = fmt.Sprintf("UPDATE %s USING TTL %d SET holder = ? WHERE name = ? IF holder = ?", table, seconds)
+			s.Args = []interface{}{l.holder, l.Name, l.holder}
+		} else {
+			s.SQL = fmt.Sprintf("INSERT INTO %s (name, holder) VALUES (?, ?) IF NOT EXISTS USING TTL %d", table, seconds)
+		}
+		return l.m.applied(ctx, nil, s)
+	}
+
+	now := time.Now()
+	s := &Statement{Op: OpUpdate, Table: table, Args: []interface{}{now.Add(ttl), l.Name, l.holder, now}}
+	if refresh {
+		b := getBuffer()
+		fmt.Fprintf(b, "UPDATE %s SET expires_at = ", quoteIdent(table, l.m.Type))
+		writePlaceholder(b, 0, l.m.Type)
+		b.WriteString(" WHERE ")
+		writeColumnPlaceholders(b, []string{"name", "holder"}, 1, " AND ", l.m.Type)
+		b.WriteString(" AND expires_at >= ")
+		writePlaceholder(b, 3, l.m.Type)
+		s.SQL = putBuffer(b)
+	} else {
+		// the row of an expired lock is taken over in place
+		s.Op = OpInsert
+		s.Args = []interface{}{l.Name, l.holder, now.Add(ttl), now}
+		b := getBuffer()
+		fmt.Fprintf(b, "INSERT INTO %s (name, holder, expires_at) VALUES (", quoteIdent(table, l.m.Type))
+		writePlaceholders(b, 0, 3, l.m.Type)
+		if l.m.Type == MySQL {
+			b.WriteString(") ON DUPLICATE KEY UPDATE holder = IF(expires_at < ")
+			writePlaceholder(b, 3, l.m.Type)
+			b.WriteString(", VALUES(holder), holder), expires_at = IF(expires_at < ")
+			writePlaceholder(b, 4, l.m.Type)
+			b.WriteString(", VALUES(expires_at), expires_at)")
+			s.Args = append(s.Args, now)
+		} else {
+			fmt.Fprintf(b, ") ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at WHERE %s.expires_at < ", table)
+			writePlaceholder(b, 3, l.m.Type)
+		}
+		s.SQL = putBuffer(b)
+	}
+	res, err := l.m.exec(ctx, nil, s)
+	if err != nil {
+		return false, err
+	}
+	if l.m.Type == MySQL {
+		// the rows affected on MySQL depend on the clientFoundRows flag of the DSN, so
+		// whether the lock is held is read back instead
+		return l.held(ctx, now)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// held reports whether the lock is held and doesn't expire before now.
+func (l *Lock) held(ctx context.Context, now time.Time) (bool, error) {
+	b := getBuffer()
+	fmt.Fprintf(b, "SELECT COUNT(*) FROM %s WHERE ", quoteIdent(l.m.locks(), l.m.Type))
+	writeColumnPlaceholders(b, []string{"name", "holder"}, 0, " AND ", l.m.Type)
+	b.WriteString(" AND expires_at >= ")
+	writePlaceholder(b, 2, l.m.Type)
+	s := &Statement{Op: OpSelect, Table: l.m.locks(), SQL: putBuffer(b), Args: []interface{}{l.Name, l.holder, now}, Idempotent: true}
+	var n int
+	err := l.m.run(WithPrimary(ctx), s, 0, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err = rows.Scan(&n); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+	return n > 0, err
+}
+
+func (m *Mapping) locks() string {
+	if m.lockTable == "" {
+		return DefaultLockTable
+	}
+	return m.lockTable
+}
@@ -0,0 +1,27 @@
+package m
+
+import "time"
+
+// A Logger is called after every attempt to execute a statement with its SQL, its
+// arguments, how long it took and the error it returned, if any. Statements that are
+// retried are logged once per attempt.
+type Logger func(query string, args []interface{}, dur time.Duration, err error)
+
+// SetLogger sets the Logger of the statements executed by m, nil turns logging off. The
+// logger is called from the goroutine executing the statement, so it should not block.
+// Statements can be rewritten before they are executed by a Policy, which can change
+// the Statement it is passed.
+//
+//	M.SetLogger(func(query string, args []interface{}, dur time.Duration, err error) {
+//		log.Printf("%s %v (%v): %v", query, args, dur, err)
+//	})
+func (m *Mapping) SetLogger(l Logger) {
+	m.logger = l
+}
+
+// log calls the logger of m, if any, with s.
+func (m *Mapping) log(s *Statement, start time.Time, err error) {
+	if m.logger != nil {
+		m.logger(s.SQL, s.Args, time.Since(start), err)
+	}
+}
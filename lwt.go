@@ -0,0 +1,236 @@
+package m
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// errNotApplied rolls back the history of a conditional update that wasn't applied.
+var errNotApplied = errors.New("m: not applied")
+
+// InsertIfNotExists is like Insert but only inserts thing if there is no row with its
+// primary key, and reports whether it did. On Cassandra it is a lightweight
+// transaction with IF NOT EXISTS, other databases ignore the conflicting insert. On
+// MySQL the insert of an existing row only isn't applied if the DSN doesn't set
+// clientFoundRows, which counts it as a written row.
+func (m *Mapping) InsertIfNotExists(thing interface{}) (bool, error) {
+	return m.InsertIfNotExistsContext(context.Background(), thing)
+}
+
+// InsertIfNotExistsContext is like InsertIfNotExists but runs the statement with ctx.
+func (m *Mapping) InsertIfNotExistsContext(ctx context.Context, thing interface{}) (applied bool, err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	if t.View {
+		return false, ErrReadOnly
+	}
+	thing = addressable(t, thing)
+	if err = beforeInsert(thing); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	thing = m.setExpiry(t, thing)
+	thing = setTimestamps(t, thing, now)
+	thing = setDefaults(t, thing)
+	if t.History != nil {
+		thing = setTimeIfZero(t, thing, t.column(ValidFrom), now)
+	}
+	if err = m.validate(thing); err != nil {
+		return false, err
+	}
+	columns, values, err := prepareInsertSqlColumnsValues(thing, t)
+	if err != nil {
+		return false, err
+	}
+	query, err := sqlInsertString(t.Name, columns, m.Type)
+	if err != nil {
+		return false, err
+	}
+	switch m.Type {
+	case Cassandra:
+		query += " IF NOT EXISTS"
+		if using := m.using(ctx, t); using != "" {
+			query += " " + using
+		}
+	case MySQL:
+		// INSERT IGNORE would turn every error into a row that isn't inserted
+		if key := t.primaryKey(); len(key) > 0 {
+			k := quoteIdent(key[0], m.Type)
+			query += " ON DUPLICATE KEY UPDATE " + k + " = " + k
+		}
+	case SQLite:
+		query = "INSERT OR IGNORE" + query[len("INSERT"):]
+	default:
+		query += " ON CONFLICT DO NOTHING"
+	}
+	applied, err = m.conditional(ctx, t, &Statement{Op: OpInsert, Table: t.Name, SQL: query, Args: values})
+	if err != nil || !applied {
+		return false, err
+	}
+	m.cached(t, func(c *TableCache) { c.putRow(thing) })
+	return true, afterInsert(thing)
+}
+
+// UpdateIf is like Update but only updates the row for thing if its columns have the
+// values in conditions, and reports whether it did. thing isn't changed if the row
+// isn't updated. On Cassandra it is a lightweight transaction with an IF clause, which
+// makes compare and set possible:
+//
+//	ok, err := M.UpdateIf(&post, map[string]interface{}{"version": 4}, map[string]interface{}{"version": 3})
+func (m *Mapping) UpdateIf(thing interface{}, data, conditions map[string]interface{}) (bool, error) {
+	return m.UpdateIfContext(context.Background(), thing, data, conditions)
+}
+
+// UpdateIfContext is like UpdateIf but runs the statement with ctx.
+func (m *Mapping) UpdateIfContext(ctx context.Context, thing interface{}, data, conditions map[string]interface{}) (applied bool, err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	if err = t.modifiable(); err != nil {
+		return false, err
+	}
+	conditionColumns := make([]string, 0, len(conditions))
+	for column := range conditions {
+		if t.column(column) == nil {
+			return false, fmt.Errorf("m: condition on unknown column %s of %v", column, t.Type)
+		}
+		conditionColumns = append(conditionColumns, column)
+	}
+	sort.Strings(conditionColumns)
+
+	if data, err = beforeUpdate(thing, data); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if t.History != nil {
+		data = withValue(data, ValidFrom, now)
+	}
+	data = t.withUpdated(data, now)
+	if err = validateUpdate(thing, t, data, false); err != nil {
+		return false, err
+	}
+	// the struct is only changed if the row is
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	old := reflect.New(v.Type()).Elem()
+	old.Set(v)
+	columns, values, err := m.updateValid(thing, t, data)
+	if err != nil {
+		return false, err
+	}
+	keyColumns, keyValues := keysForUpdate(old.Interface(), t)
+	values = append(values, keyValues...)
+	query, err := sqlUpdateString(t.Name, m.using(ctx, t), columns, keyColumns, m.Type)
+	if err != nil {
+		return false, err
+	}
+	if len(conditionColumns) > 0 {
+		b := getBuffer()
+		b.WriteString(query)
+		if m.Type == Cassandra {
+			b.WriteString(" IF ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		writeColumnPlaceholders(b, conditionColumns, len(values), " AND ", m.Type)
+		query = putBuffer(b)
+		for _, column := range conditionColumns {
+			values = append(values, conditions[column])
+		}
+	}
+
+	err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, now, func(m *Mapping) error {
+		ok, err := m.conditional(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: values})
+		if err == nil && !ok && m.Type == MySQL {
+			// MySQL doesn't count the rows set to the values they have unless the DSN sets
+			// clientFoundRows, so whether the row matched is read back with the values of
+			// the key and the conditions, which end values
+			columns := append(append([]string(nil), keyColumns...), conditionColumns...)
+			ok, err = m.matches(ctx, t, columns, values[len(values)-len(columns):])
+		}
+		if err == nil && !ok {
+			// the history written for the update is rolled back
+			return errNotApplied
+		}
+		return err
+	})
+	applied = err == nil
+	if err == errNotApplied {
+		err = nil
+	}
+	if !applied {
+		v.Set(old)
+	}
+	m.cached(t, func(c *TableCache) { c.removeRow(thing) })
+	return applied, err
+}
+
+// conditional executes the conditional write s and reports whether it was applied. On
+// Cassandra it reads the [applied] column of the result, elsewhere it checks that a row
+// was written.
+func (m *Mapping) conditional(ctx context.Context, t *tableMap, s *Statement) (applied bool, err error) {
+	if m.Type != Cassandra {
+		res, err := m.exec(ctx, t, s)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	}
+	err = m.run(ctx, s, t.WriteTimeout, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			if err = rows.Err(); err == nil {
+				err = fmt.Errorf("m: no result for conditional write to %s", t.Name)
+			}
+			return err
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		// the current values of the row are returned as well when it isn't applied
+		dest := make([]interface{}, len(columns))
+		for i, c := range columns {
+			if c == "[applied]" {
+				dest[i] = &applied
+			} else {
+				dest[i] = new(interface{})
+			}
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		return rows.Close()
+	})
+	return applied, err
+}
+
+// matches reports whether the table has a row whose columns have values.
+func (m *Mapping) matches(ctx context.Context, t *tableMap, columns []string, values []interface{}) (bool, error) {
+	b := getBuffer()
+	fmt.Fprintf(b, "SELECT COUNT(*) FROM %s WHERE ", quoteIdent(t.Name, m.Type))
+	writeColumnPlaceholders(b, columns, 0, " AND ", m.Type)
+	s := &Statement{Op: OpSelect, Table: t.Name, SQL: putBuffer(b), Args: values, Idempotent: true}
+	var n int
+	err := m.run(WithPrimary(ctx), s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err = rows.Scan(&n); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+	return n > 0, err
+}
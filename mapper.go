@@ -2,71 +2,184 @@
 package m
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/titanous/go-backports/database/sql"
+	"time"
 )
 
 const (
 	Cassandra DBType = iota
 	PostgreSQL
+	MySQL
+
+	// SQLite binds serialized fields as JSON text. Their columns should be declared
+	// TEXT, with NUMERIC or no affinity SQLite converts JSON numbers into INTEGER or
+	// REAL values, which read back as JSON but can lose precision.
+	SQLite
 )
 
 type DBType int
 
 func (t DBType) NewMapping() *Mapping {
-	return &Mapping{Type: t, tables: make(map[reflect.Type]*tableMap)}
+	return NewRegistry().NewMapping(t)
 }
 
 type Mapping struct {
+	// DB is the primary database that statements are run on. It can be set directly
+	// or with Open.
 	DB   *sql.DB
 	Type DBType
 
-	tables map[reflect.Type]*tableMap
+	registry *Registry
+	policies []Policy
+	limiter  Limiter
+
+	connObserver ConnObserver
+	logger       Logger
+	stats        StatsCollector
+	caches       *tableCaches
+	retry        RetryPolicy
+	tx           *Tx
+	lockTable    string
+	stmts        *stmtCache
+	insertChunk  int
+	replicas     *replicaSet
+	hedgeDelay   time.Duration
+	recovering   bool
+	validator    ValidatorFunc
 }
 
 type tableMap struct {
-	Name    string
-	Type    reflect.Type
-	Columns []*columnMap
-	m       *Mapping
+	Name          string
+	Type          reflect.Type
+	Columns       []*columnMap
+	Discriminator string
+	Partition     *partitionMap
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	Limit         *limitGuard
+	View          bool
+	TTL           time.Duration
+	PartitionKey  []string
+	Views         []*cassandraView
+	Indexes       []string
+	Sequence      string // sequence column of an append-only table
+	History       *historyMap
+	Relations     map[string]*relationMap // by field name
+	Statements    map[Op]*namedStatement  // set with SetStatements
 }
 
 type columnMap struct {
-	Name       string
-	Serialize  bool
-	PrimaryKey bool
-	Field      int
+	Name          string
+	Serialize     bool
+	PrimaryKey    bool
+	Discriminator bool
+	Counter       bool
+	Expires       bool
+	AutoIncrement bool
+	NullZero      bool
+	SoftDelete    bool
+	AutoCreate    bool
+	AutoUpdate    bool
+	ReadOnly      bool        // never written, for columns computed by the database
+	CreateOnly    bool        // only written by inserts
+	Collate       string      // collation of a text column, set with collate=
+	Charset       string      // character set of a text column on MySQL, set with charset=
+	CodecName     string      // codec of a serialized column, set with serialize=
+	Default       string      // value of zero fields on insert, set with default=
+	DefaultValue  interface{} // Default parsed as a value of the field
+	Codec         Codec       // codec of a serialized column, see Codec
+	Converter     *converter  // conversion of the field type, see RegisterConverter
+	Field         []int       // index of the field, see reflect.Value.FieldByIndex
 }
 
 // AddTable adds a table to struct mapping to a Mapping.
 //	M.AddTable("posts", Post{})
 func (m *Mapping) AddTable(name string, thing interface{}) {
-	typ := reflect.TypeOf(thing)
-	m.tables[typ] = &tableMap{name, typ, getTableColumns(thing, typ), m}
+	m.registry.AddTable(name, thing)
+}
+
+// AddSubtype adds one of several structs that share a table to a Mapping, see
+// Registry.AddSubtype.
+func (m *Mapping) AddSubtype(name string, thing interface{}, value string) {
+	m.registry.AddSubtype(name, thing, value)
+}
+
+// Registry returns the Registry holding the table mappings of m.
+func (m *Mapping) Registry() *Registry {
+	return m.registry
 }
 
 func getTableColumns(thing interface{}, typ reflect.Type) []*columnMap {
-	columns := make([]*columnMap, 0, typ.NumField())
+	return appendTableColumns(make([]*columnMap, 0, typ.NumField()), typ, nil, "")
+}
 
+// appendTableColumns appends the columns of the fields of typ, which is embedded at
+// index in the mapped struct, prefixing their names with prefix. The fields of
+// anonymous struct fields without a db tag, and of struct fields tagged inline, are
+// mapped as if they were fields of the mapped struct, the name in the tag of an inline
+// field is the prefix of the names of its columns:
+//
+//	type Post struct {
+//		Timestamps                 // created_at, updated_at
+//		Author     Ref `db:"author_,inline"` // author_id, author_name
+//	}
+//
+// Embedded struct pointers are not supported, as a nil pointer has no fields to scan
+// into.
+func appendTableColumns(columns []*columnMap, typ reflect.Type, index []int, prefix string) []*columnMap {
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
+		fieldIndex := append(index[:len(index):len(index)], i)
 		tag := strings.Split(field.Tag.Get("db"), ",")
+		if field.Type.Kind() == reflect.Struct && (field.Anonymous && tag[0] == "" || contains(tag[1:], "inline")) {
+			columns = appendTableColumns(columns, field.Type, fieldIndex, prefix+tag[0])
+			continue
+		}
 		if len(tag) > 0 && tag[0] != "" {
-			col := &columnMap{Field: i}
+			col := &columnMap{Field: fieldIndex}
 			for _, flag := range tag {
 				switch flag {
 				case "pk":
 					col.PrimaryKey = true
 				case "serialize":
 					col.Serialize = true
+				case "discriminator":
+					col.Discriminator = true
+				case "counter":
+					col.Counter = true
+				case "expires":
+					col.Expires = true
+				case "autoincrement":
+					col.AutoIncrement = true
+				case "nullzero":
+					col.NullZero = true
+				case "autocreate":
+					checkTimeField(field, flag)
+					col.AutoCreate = true
+				case "autoupdate":
+					checkTimeField(field, flag)
+					col.AutoUpdate = true
+				case "readonly":
+					col.ReadOnly = true
+				case "createonly":
+					col.CreateOnly = true
+				case "softdelete":
+					checkTimeField(field, flag)
+					// the column is NULL for rows that aren't deleted
+					col.SoftDelete, col.NullZero = true, true
 				default:
+					if i := strings.IndexByte(flag, '='); i > 0 && col.Name != "" {
+						col.setOption(flag[:i], flag[i+1:])
+						continue
+					}
 					if col.Name == "" {
-						col.Name = flag
+						col.Name = intern(prefix + flag)
 					}
 				}
 			}
@@ -79,29 +192,131 @@ func getTableColumns(thing interface{}, typ reflect.Type) []*columnMap {
 
 // Insert takes a struct and inserts it into the appropriate table.
 // If a field is nil it will not be part of the INSERT statement.
+// Generated keys and the changes of hooks are only set on the struct if it is passed by
+// pointer.
 func (m *Mapping) Insert(thing interface{}) error {
-	return m.lookupTable(thing).insert(thing)
+	return m.InsertContext(context.Background(), thing)
+}
+
+// InsertContext is like Insert but runs the statement with ctx.
+func (m *Mapping) InsertContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	return m.insert(ctx, m.lookupTable(thing), thing)
+}
+
+// Replace is like Insert but replaces the row with the same primary key if there is
+// one. It uses INSERT OR REPLACE on SQLite, REPLACE on MySQL and an upsert of the
+// inserted columns on PostgreSQL, Cassandra inserts always replace.
+func (m *Mapping) Replace(thing interface{}) error {
+	return m.ReplaceContext(context.Background(), thing)
+}
+
+// ReplaceContext is like Replace but runs the statement with ctx.
+func (m *Mapping) ReplaceContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	return m.replace(ctx, m.lookupTable(thing), thing, false)
+}
+
+// Upsert inserts thing, or updates the columns it sets if a row with the same primary
+// key exists, leaving the other columns alone. The conflict target is the columns
+// tagged pk. It uses ON CONFLICT DO UPDATE on PostgreSQL and SQLite and ON DUPLICATE KEY
+// UPDATE on MySQL. A Cassandra INSERT already updates existing rows, IF NOT EXISTS
+// would skip them instead, so Upsert is a plain INSERT there.
+func (m *Mapping) Upsert(thing interface{}) error {
+	return m.UpsertContext(context.Background(), thing)
+}
+
+// UpsertContext is like Upsert but runs the statement with ctx.
+func (m *Mapping) UpsertContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	return m.replace(ctx, m.lookupTable(thing), thing, true)
 }
 
 func (m *Mapping) InsertValues(table string, columns []string, values ...interface{}) error {
-	_, err := m.DB.Exec(sqlInsertString(table, columns, m.Type), values...)
+	return m.InsertValuesContext(context.Background(), table, columns, values...)
+}
+
+// InsertValuesContext is like InsertValues but runs the statement with ctx.
+func (m *Mapping) InsertValuesContext(ctx context.Context, table string, columns []string, values ...interface{}) (err error) {
+	defer m.recover(&err)
+	query, err := sqlInsertString(table, columns, m.Type)
+	if err != nil {
+		return err
+	}
+	_, err = m.exec(ctx, nil, &Statement{Op: OpInsert, Table: table, SQL: query, Args: values, Idempotent: m.Type == Cassandra})
 	return err
 }
 
 // Update takes a struct and a map of column names to data and updates the struct and the database row.
+// Primary key columns can't be changed by Update, use UpdateKey.
 func (m *Mapping) Update(thing interface{}, data map[string]interface{}) error {
-	return m.lookupTable(thing).update(thing, data)
+	return m.UpdateContext(context.Background(), thing, data)
+}
+
+// UpdateContext is like Update but runs the statement with ctx.
+func (m *Mapping) UpdateContext(ctx context.Context, thing interface{}, data map[string]interface{}) (err error) {
+	defer m.recover(&err)
+	return m.update(ctx, m.lookupTable(thing), thing, data, false)
+}
+
+// UpdateKey changes the primary key of the row for thing to the values in keys, which may only
+// contain primary key columns, and updates the struct.
+func (m *Mapping) UpdateKey(thing interface{}, keys map[string]interface{}) error {
+	return m.UpdateKeyContext(context.Background(), thing, keys)
+}
+
+// UpdateKeyContext is like UpdateKey but runs the statement with ctx.
+func (m *Mapping) UpdateKeyContext(ctx context.Context, thing interface{}, keys map[string]interface{}) (err error) {
+	defer m.recover(&err)
+	return m.updateKey(ctx, m.lookupTable(thing), thing, keys)
+}
+
+// Delete takes a struct and deletes the row with the same primary key from the appropriate table.
+func (m *Mapping) Delete(thing interface{}) error {
+	return m.DeleteContext(context.Background(), thing)
+}
+
+// DeleteContext is like Delete but runs the statement with ctx.
+func (m *Mapping) DeleteContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	return m.delete(ctx, m.lookupTable(thing), thing)
+}
+
+// DeleteWhere deletes the rows matching condition from the table for thing.
+//
+//	M.DeleteWhere(Post{}, "author_id = $1", id)
+func (m *Mapping) DeleteWhere(thing interface{}, condition string, bindings ...interface{}) error {
+	return m.DeleteWhereContext(context.Background(), thing, condition, bindings...)
+}
+
+// DeleteWhereContext is like DeleteWhere but runs the statement with ctx.
+func (m *Mapping) DeleteWhereContext(ctx context.Context, thing interface{}, condition string, bindings ...interface{}) (err error) {
+	defer m.recover(&err)
+	return m.deleteWhere(ctx, m.lookupTable(thing), condition, bindings...)
 }
 
 // Select queries the database and returns a slice containing the returned rows scanned into structs with 
 // the same type as thing.
 func (m *Mapping) Select(thing interface{}, query string, bindings ...interface{}) ([]interface{}, error) {
-	return m.lookupTable(thing).doSelect(query, bindings...)
+	return m.SelectContext(context.Background(), thing, query, bindings...)
+}
+
+// SelectContext is like Select but runs the query with ctx, cancelling ctx stops the
+// scan.
+func (m *Mapping) SelectContext(ctx context.Context, thing interface{}, query string, bindings ...interface{}) (_ []interface{}, err error) {
+	defer m.recover(&err)
+	return m.doSelect(ctx, m.lookupTable(thing), query, bindings...)
 }
 
 // SelectOne is a convenience function that returns a single record or nil if no record is found.
 func (m *Mapping) SelectOne(thing interface{}, query string, bindings ...interface{}) (interface{}, error) {
-	res, err := m.lookupTable(thing).doSelect(query, bindings...)
+	return m.SelectOneContext(context.Background(), thing, query, bindings...)
+}
+
+// SelectOneContext is like SelectOne but runs the query with ctx.
+func (m *Mapping) SelectOneContext(ctx context.Context, thing interface{}, query string, bindings ...interface{}) (_ interface{}, err error) {
+	defer m.recover(&err)
+	res, err := m.doSelect(ctx, m.lookupTable(thing), query, bindings...)
 	if err == nil && len(res) < 1 {
 		return nil, nil
 	}
@@ -111,187 +326,971 @@ func (m *Mapping) SelectOne(thing interface{}, query string, bindings ...interfa
 	return res[0], nil
 }
 
+// Query starts a query selecting columns from the table for thing. If thing isn't the
+// row of a registered table, the methods running the query return a *TypeError, see
+// MustQuery.
 func (m *Mapping) Query(thing interface{}, columns string) *Query {
-	return &Query{columns: columns, t: m.lookupTable(thing), conditions: make([]string, 0, 5), bindings: make([]interface{}, 0, 5)}
+	t, err := m.table(thing)
+	if err != nil {
+		t = &tableMap{}
+	}
+	return &Query{columns: columns, t: t, m: m, conditions: make([]string, 0, 5), bindings: make([]interface{}, 0, 5), err: err}
 }
 
+// MustQuery is like Query but panics if thing isn't the row of a registered table.
+func (m *Mapping) MustQuery(thing interface{}, columns string) *Query {
+	return mustQuery(m.Query(thing, columns))
+}
+
+// QueryTable is like Query but selects from table, which has the columns of the table
+// for thing, like a partition or a copy of it.
 func (m *Mapping) QueryTable(table string, thing interface{}, columns string) *Query {
-	t := m.lookupTable(thing)
-	return &Query{columns: columns, t: &tableMap{table, t.Type, t.Columns, t.m}, conditions: make([]string, 0, 5), bindings: make([]interface{}, 0, 5)}
+	q := m.Query(thing, columns)
+	t := *q.t
+	t.Name = table
+	q.t = &t
+	return q
+}
+
+// MustQueryTable is like QueryTable but panics if thing isn't the row of a registered
+// table.
+func (m *Mapping) MustQueryTable(table string, thing interface{}, columns string) *Query {
+	return mustQuery(m.QueryTable(table, thing, columns))
+}
+
+// Lookup returns the name of the table for thing, or a *TypeError if thing isn't the
+// row of a registered table, which is how the other methods taking rows report it.
+func (m *Mapping) Lookup(thing interface{}) (string, error) {
+	t, err := m.table(thing)
+	if err != nil {
+		return "", err
+	}
+	return t.Name, nil
+}
+
+// MustLookup is like Lookup but panics with the *TypeError, for code that registers its
+// tables at startup and treats a missing one as a programming error.
+func (m *Mapping) MustLookup(thing interface{}) string {
+	return m.lookupTable(thing).Name
+}
+
+// fail records err to be returned by the methods running q, unless q already failed.
+func (q *Query) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+func mustQuery(q *Query) *Query {
+	if q.err != nil {
+		panic(q.err)
+	}
+	return q
+}
+
+func (m *Mapping) insert(ctx context.Context, t *tableMap, thing interface{}) error {
+	if t.View {
+		return ErrReadOnly
+	}
+	thing = addressable(t, thing)
+	if err := beforeInsert(thing); err != nil {
+		return err
+	}
+	now := time.Now()
+	thing = m.setExpiry(t, thing)
+	thing = setTimestamps(t, thing, now)
+	thing = setDefaults(t, thing)
+	if t.History != nil {
+		thing = setTimeIfZero(t, thing, t.column(ValidFrom), now)
+	}
+	if err := m.validate(thing); err != nil {
+		return err
+	}
+	if ns := t.statement(OpInsert); ns != nil {
+		if err := m.execStatement(ctx, t, OpInsert, ns, thing); err != nil {
+			return err
+		}
+		m.cached(t, func(c *TableCache) { c.putRow(thing) })
+		return afterInsert(thing)
+	}
+	columns, values, err := prepareInsertSqlColumnsValues(thing, t)
+	if err != nil {
+		return err
+	}
+	if t.Sequence != "" {
+		if err := m.insertEvent(ctx, t, thing, columns, values); err != nil {
+			return err
+		}
+		return afterInsert(thing)
+	}
+	key, keyField := m.autoIncrementKey(t, thing)
+	if key != nil {
+		columns, values = withoutColumn(columns, values, key.Name)
+	}
+	query, err := sqlInsertString(t.Name, columns, m.Type)
+	if err != nil {
+		return err
+	}
+	if using := m.using(ctx, t); using != "" {
+		query += " " + using
+	}
+	s := &Statement{Op: OpInsert, Table: t.Name, SQL: query, Args: values, Idempotent: m.Type == Cassandra}
+	if key != nil && m.Type == PostgreSQL {
+		err = m.insertReturning(ctx, t, s, key, keyField)
+	} else {
+		var res sql.Result
+		res, err = m.exec(ctx, t, s)
+		if err == nil && key != nil {
+			err = setInsertID(keyField, res)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	m.cached(t, func(c *TableCache) { c.putRow(thing) })
+	return afterInsert(thing)
+}
+
+// replace runs Replace, or Upsert if upsert is true.
+func (m *Mapping) replace(ctx context.Context, t *tableMap, thing interface{}, upsert bool) error {
+	if err := t.modifiable(); err != nil {
+		return err
+	}
+	thing = addressable(t, thing)
+	if err := beforeInsert(thing); err != nil {
+		return err
+	}
+	now := time.Now()
+	thing = m.setExpiry(t, thing)
+	thing = setTimestamps(t, thing, now)
+	thing = setDefaults(t, thing)
+	if t.History != nil {
+		thing = setTimeIfZero(t, thing, t.column(ValidFrom), now)
+	}
+	if err := m.validate(thing); err != nil {
+		return err
+	}
+	columns, values, err := prepareInsertSqlColumnsValues(thing, t)
+	if err != nil {
+		return err
+	}
+	var query string
+	if upsert {
+		query, err = sqlUpsertKeepString(t.Name, columns, t.primaryKey(), t.createdColumns(), m.Type)
+	} else {
+		query, err = sqlReplaceString(t.Name, columns, t.primaryKey(), m.Type)
+	}
+	if err != nil {
+		return err
+	}
+	if using := m.using(ctx, t); using != "" {
+		query += " " + using
+	}
+	keyColumns, keyValues := keysForUpdate(thing, t)
+	err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, now, func(m *Mapping) error {
+		_, err := m.exec(ctx, t, &Statement{Op: OpInsert, Table: t.Name, SQL: query, Args: values, Idempotent: true})
+		return err
+	})
+	m.cached(t, func(c *TableCache) { c.removeRow(thing) })
+	if err != nil {
+		return err
+	}
+	return afterInsert(thing)
 }
 
-func (t *tableMap) insert(thing interface{}) error {
-	columns, values := prepareInsertSqlColumnsValues(thing, t)
-	_, err := t.m.DB.Exec(sqlInsertString(t.Name, columns, t.m.Type), values...)
+// update runs Update, refreshing thing from the updated row if returning is true, see
+// UpdateReturning.
+func (m *Mapping) update(ctx context.Context, t *tableMap, thing interface{}, data map[string]interface{}, returning bool) error {
+	if err := t.modifiable(); err != nil {
+		return err
+	}
+	data, err := beforeUpdate(thing, data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if t.History != nil {
+		data = withValue(data, ValidFrom, now)
+	}
+	data = t.withUpdated(data, now)
+	if err := validateUpdate(thing, t, data, false); err != nil {
+		return err
+	}
+	columns, values, err := m.updateValid(thing, t, data)
+	if err != nil {
+		return err
+	}
+	keyColumns, keyValues := keysForUpdate(thing, t)
+	values = append(values, keyValues...)
+	query, err := sqlUpdateString(t.Name, m.using(ctx, t), columns, keyColumns, m.Type)
+	if err != nil {
+		return err
+	}
+	err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, now, func(m *Mapping) error {
+		s := &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: values, Idempotent: !t.hasCounter(columns)}
+		var err error
+		if ns := t.statement(OpUpdate); ns != nil {
+			err = m.execStatement(ctx, t, OpUpdate, ns, thing)
+		} else if returning && (m.Type == PostgreSQL || m.Type == SQLite) {
+			s.SQL += " RETURNING *"
+			return m.queryInto(ctx, t, s, thing)
+		} else {
+			_, err = m.exec(ctx, t, s)
+		}
+		if err != nil || !returning {
+			return err
+		}
+		return m.refresh(ctx, t, thing, keyColumns, keyValues)
+	})
+	m.cached(t, func(c *TableCache) { c.removeRow(thing) })
 	return err
 }
 
-func (t *tableMap) update(thing interface{}, data map[string]interface{}) error {
-	columns, values := updateAndGetSqlColumnsValues(thing, t, data)
+func (m *Mapping) updateKey(ctx context.Context, t *tableMap, thing interface{}, data map[string]interface{}) error {
+	if err := t.modifiable(); err != nil {
+		return err
+	}
+	if m.Type == Cassandra {
+		return fmt.Errorf("m: primary keys can't be updated in Cassandra")
+	}
+	data, err := beforeUpdate(thing, data)
+	if err != nil {
+		return err
+	}
+	if err := validateUpdate(thing, t, data, true); err != nil {
+		return err
+	}
+
+	// the WHERE clause needs the old key, which is restored if the update fails
 	keyColumns, keyValues := keysForUpdate(thing, t)
+	thingValue := reflect.Indirect(reflect.ValueOf(thing))
+	old := reflect.New(thingValue.Type()).Elem()
+	old.Set(thingValue)
+
+	now := time.Now()
+	if t.History != nil {
+		data = withValue(data, ValidFrom, now)
+	}
+	columns, values, err := m.updateValid(thing, t, data)
+	if err != nil {
+		return err
+	}
 	values = append(values, keyValues...)
-	_, err := t.m.DB.Exec(sqlUpdateString(t.Name, columns, keyColumns, t.m.Type), values...)
+	query, err := sqlUpdateString(t.Name, "", columns, keyColumns, m.Type)
+	if err == nil {
+		err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, now, func(m *Mapping) error {
+			_, err := m.exec(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: values, Idempotent: true})
+			return err
+		})
+	}
+	if err != nil {
+		thingValue.Set(old)
+		return err
+	}
+	m.cached(t, func(c *TableCache) {
+		c.removeRow(old.Interface())
+		c.removeRow(thing)
+	})
+	return nil
+}
+
+func (m *Mapping) delete(ctx context.Context, t *tableMap, thing interface{}) error {
+	if err := t.modifiable(); err != nil {
+		return err
+	}
+	if c := t.softDeleteColumn(); c != nil {
+		return m.softDelete(ctx, t, thing, c)
+	}
+	keyColumns, keyValues := keysForUpdate(thing, t)
+	query, err := sqlDeleteString(t.Name, keyColumns, m.Type)
+	if err != nil {
+		return err
+	}
+	err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, time.Now(), func(m *Mapping) error {
+		if ns := t.statement(OpDelete); ns != nil {
+			return m.execStatement(ctx, t, OpDelete, ns, thing)
+		}
+		_, err := m.exec(ctx, t, &Statement{Op: OpDelete, Table: t.Name, SQL: query, Args: keyValues, Idempotent: true})
+		return err
+	})
+	m.cached(t, func(c *TableCache) { c.removeRow(thing) })
+	return err
+}
+
+func (m *Mapping) deleteWhere(ctx context.Context, t *tableMap, condition string, bindings ...interface{}) error {
+	if err := t.modifiable(); err != nil {
+		return err
+	}
+	if c := t.softDeleteColumn(); c != nil {
+		return m.softDeleteWhere(ctx, t, c, condition, bindings)
+	}
+	b := getBuffer()
+	b.WriteString("DELETE FROM ")
+	writeIdent(b, t.Name, m.Type)
+	if condition != "" {
+		b.WriteString(" WHERE ")
+		b.WriteString(condition)
+	}
+	query := putBuffer(b)
+	err := m.versioned(ctx, t, condition, bindings, time.Now(), func(m *Mapping) error {
+		_, err := m.exec(ctx, t, &Statement{Op: OpDelete, Table: t.Name, SQL: query, Args: bindings, Idempotent: true})
+		return err
+	})
+	m.cached(t, func(c *TableCache) { c.Purge() })
 	return err
 }
 
+// withValue returns a copy of data with column set to v.
+func withValue(data map[string]interface{}, column string, v interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		c[k] = v
+	}
+	c[column] = v
+	return c
+}
+
 // Mostly taken from https://github.com/coopernurse/gorp by James Cooper
-func (t *tableMap) doSelect(query string, bindings ...interface{}) ([]interface{}, error) {
-	rows, err := t.m.DB.Query(query, bindings...)
+func (m *Mapping) doSelect(ctx context.Context, t *tableMap, query string, bindings ...interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0)
+	err := m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: bindings}, func(instance interface{}) error {
+		results = append(results, instance)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	return results, nil
+}
+
+// scanRows scans each of rows into a new struct and passes it to fn.
+func (m *Mapping) scanRows(t *tableMap, rows *sql.Rows, fn func(interface{}) error) error {
+	sc, err := m.newRowScanner(t, rows)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	results := make([]interface{}, 0)
+	defer sc.close()
 
 	for rows.Next() {
-		instance := reflect.New(t.Type)
-		values := make([]interface{}, len(columns))
-		deserializeValues := make(map[int]interface{})
-
-		for x := range columns {
-			var column *columnMap
-			columnName := columns[x]
-
-			for _, c := range t.Columns {
-				if c.Name == columnName {
-					column = c
-					break
+		instance, err := sc.scan()
+		if err != nil {
+			return err
+		}
+		if err = fn(instance); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// rowScanner scans rows into new structs of t, or of its subtypes.
+type rowScanner struct {
+	t       *tableMap
+	rows    *sql.Rows
+	columns []string
+	buf     *scanBuffer
+
+	family              map[string]*tableMap
+	discriminator       int
+	discriminatorValues []interface{}
+}
+
+func (m *Mapping) newRowScanner(t *tableMap, rows *sql.Rows) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	sc := &rowScanner{t: t, rows: rows, columns: columns, discriminator: -1}
+
+	// if the table is shared by several types, the discriminator column is scanned
+	// first to find out which type each row should be scanned into
+	sc.family = m.registry.subtypes[t.Name]
+	if len(sc.family) > 0 {
+		if c := t.discriminatorColumn(); c != nil {
+			for x, name := range columns {
+				if name == c.Name {
+					sc.discriminator = x
 				}
 			}
+		}
+		sc.discriminatorValues = make([]interface{}, len(columns))
+		for x := range sc.discriminatorValues {
+			sc.discriminatorValues[x] = new(interface{})
+		}
+	}
 
-			if column == nil { // column not defined in type struct, so eat the value
-				values[x] = make([]byte, 0)
-				continue
-			}
+	sc.buf = getScanBuffer()
+	return sc, nil
+}
 
-			field := instance.Elem().Field(column.Field)
+// scan scans the current row, after a call to rows.Next.
+func (sc *rowScanner) scan() (interface{}, error) {
+	rowTable := sc.t
+	if sc.discriminator >= 0 {
+		var value sql.NullString
+		sc.discriminatorValues[sc.discriminator] = &value
+		if err := sc.rows.Scan(sc.discriminatorValues...); err != nil {
+			return nil, err
+		}
+		if subtype, ok := sc.family[value.String]; ok {
+			rowTable = subtype
+		}
+	}
 
-			if column.Serialize {
-				values[x] = new([]byte)
-				deserializeValues[x] = field.Addr().Interface()
+	instance, err := rowTable.scanRow(sc.rows, sc.columns, sc.buf)
+	if err != nil {
+		return nil, err
+	}
+	if err = afterSelect(instance); err != nil {
+		return nil, err
+	}
+	rowTable.snapshot(instance)
+	return instance, nil
+}
+
+func (sc *rowScanner) close() {
+	putScanBuffer(sc.buf)
+}
+
+func (t *tableMap) scanRow(rows *sql.Rows, columns []string, buf *scanBuffer) (interface{}, error) {
+	instance := reflect.New(t.Type)
+	values := buf.reset(len(columns))
+
+	for x := range columns {
+		column := t.column(columns[x])
+
+		if column == nil { // column not defined in type struct, so eat the value
+			if dest, ok := buf.extra[columns[x]]; ok {
+				values[x] = dest
 			} else {
-				values[x] = field.Addr().Interface()
+				values[x] = &buf.discard
 			}
+			continue
 		}
 
-		err = rows.Scan(values...)
-		if err != nil {
+		field := instance.Elem().FieldByIndex(column.Field)
+
+		if column.Serialize {
+			values[x] = &buf.data[x]
+			buf.deserialize = append(buf.deserialize, deserializeTarget{x, field.Addr().Interface(), column.Codec})
+		} else if column.Converter != nil {
+			d := convertTarget{new(interface{}), field, column}
+			values[x] = d.src
+			buf.converted = append(buf.converted, d)
+		} else if column.NullZero && field.Kind() != reflect.Ptr {
+			d := nullZeroDest(field)
+			values[x] = d.ptr.Interface()
+			buf.nullZero = append(buf.nullZero, d)
+		} else {
+			values[x] = field.Addr().Interface()
+		}
+	}
+
+	err := rows.Scan(values...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range buf.nullZero {
+		d.set()
+	}
+	for _, d := range buf.converted {
+		if err = d.set(t); err != nil {
 			return nil, err
 		}
+	}
 
-		for i, v := range deserializeValues {
-			data := *values[i].(*[]byte)
-			if len(data) > 0 {
-				err = json.Unmarshal(data, v)
-				if err != nil {
-					return nil, err
-				}
+	for _, d := range buf.deserialize {
+		data := buf.data[d.index]
+		if len(data) > 0 {
+			err = d.codec.Unmarshal(data, d.target)
+			if err != nil {
+				return nil, err
 			}
 		}
+	}
+
+	return instance.Interface(), nil
+}
+
+func (t *tableMap) column(name string) *columnMap {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// modifiable returns an error if existing rows of t can't be updated or deleted.
+func (t *tableMap) modifiable() error {
+	if t.View {
+		return ErrReadOnly
+	}
+	if t.Sequence != "" {
+		return ErrAppendOnly
+	}
+	return nil
+}
 
-		results = append(results, instance.Interface())
+// primaryKey returns the names of the primary key columns.
+func (t *tableMap) primaryKey() []string {
+	var key []string
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			key = append(key, c.Name)
+		}
 	}
+	return key
+}
 
-	return results, nil
+// hasCounter reports whether any of columns is a Cassandra counter.
+func (t *tableMap) hasCounter(columns []string) bool {
+	for _, name := range columns {
+		if c := t.column(name); c != nil && c.Counter {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tableMap) discriminatorColumn() *columnMap {
+	for _, c := range t.Columns {
+		if c.Discriminator {
+			return c
+		}
+	}
+	return nil
 }
 
+// lookupTable returns the table of thing. It panics with a *TypeError if there is none,
+// which the public methods return, see recover.
 func (m *Mapping) lookupTable(thing interface{}) *tableMap {
-	typ := tableType(thing)
-	if table, ok := m.tables[typ]; ok {
-		return table
+	t, err := m.table(thing)
+	if err != nil {
+		panic(err)
 	}
+	return t
+}
 
-	panic(fmt.Sprintf("Unknown table for type: %v (%v)", tableType, typ.Kind()))
+// table returns the table of thing, or a *TypeError if there is none.
+func (m *Mapping) table(thing interface{}) (*tableMap, error) {
+	typ, err := typeOf(thing)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := m.registry.tables[typ]; ok {
+		return t, nil
+	}
+	return nil, &TypeError{Type: typ, Err: ErrUnknownTable}
 }
 
+// tableType returns the struct type of thing, which is a struct or a struct pointer.
+// Only the type of thing is used, so a nil struct pointer works as well. It panics with
+// a *TypeError for other values.
 func tableType(thing interface{}) reflect.Type {
-	thingVal := reflect.Indirect(reflect.ValueOf(thing))
-	if thingVal.Kind() != reflect.Struct {
-		panic(fmt.Sprintf("Expecting struct or struct pointer, got %v (%v)", thing, thingVal.Kind()))
+	typ, err := typeOf(thing)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
+
+// typeOf is like tableType but returns a *TypeError instead of panicking.
+func typeOf(thing interface{}) (reflect.Type, error) {
+	typ := reflect.TypeOf(thing)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, &TypeError{Type: reflect.TypeOf(thing), Err: ErrInvalidType}
 	}
-	return thingVal.Type()
+	return typ, nil
 }
 
-func prepareInsertSqlColumnsValues(thing interface{}, table *tableMap) ([]string, []interface{}) {
+// addressable returns thing if it is a struct pointer, or a pointer to a copy of thing
+// otherwise. Methods that set fields, like Insert setting generated keys and calling
+// hooks with pointer receivers, work on the copy if they are passed a struct, leaving
+// the struct of the caller unchanged.
+func addressable(t *tableMap, thing interface{}) interface{} {
+	v := reflect.ValueOf(thing)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return thing
+	}
+	p := reflect.New(t.Type)
+	p.Elem().Set(reflect.Indirect(v))
+	return p.Interface()
+}
+
+func prepareInsertSqlColumnsValues(thing interface{}, table *tableMap) ([]string, []interface{}, error) {
 	thingValue := reflect.Indirect(reflect.ValueOf(thing))
 	columns := make([]string, 0, len(table.Columns))
 	values := make([]interface{}, 0, len(table.Columns))
 
 	for i := 0; i < len(table.Columns); i++ {
 		column := table.Columns[i]
-		value := thingValue.Field(column.Field)
+		if column.ReadOnly {
+			continue
+		}
+		value := thingValue.FieldByIndex(column.Field)
 		kind := value.Kind()
 
+		if column.Discriminator && table.Discriminator != "" {
+			if value.Kind() == reflect.String && value.CanSet() {
+				value.SetString(table.Discriminator)
+			}
+			values = append(values, table.Discriminator)
+			columns = append(columns, column.Name)
+			continue
+		}
+
 		// skip fields that are nil pointers or empty slices/maps/arrays
 		if (kind == reflect.Ptr && value.IsNil()) ||
 			((kind == reflect.Slice || kind == reflect.Map || kind == reflect.Array) && value.Len() < 1) {
 			continue
 		}
 
-		if column.Serialize {
-			// TODO(jr): don't eat this marshal error value
-			marshaled, _ := json.Marshal(value.Interface())
-			values = append(values, string(marshaled))
-		} else {
-			values = append(values, reflect.Indirect(value).Interface())
+		v, err := columnValue(table, column, value)
+		if err != nil {
+			return nil, nil, err
 		}
+		values = append(values, v)
 		columns = append(columns, column.Name)
 	}
 
-	return columns, values
+	return columns, values, nil
 }
 
-func sqlPlaceholders(n int, dbt DBType) (p string) {
-	if dbt == PostgreSQL {
-		for i := 0; i < n; i++ {
-			p += fmt.Sprintf("$%d", i+1)
-			if i < n-1 {
-				p += ", "
-			}
+// columnValue returns the value written to column c of t for field.
+func columnValue(t *tableMap, c *columnMap, field reflect.Value) (interface{}, error) {
+	switch {
+	case c.Serialize:
+		data, err := c.Codec.Marshal(field.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("m: serializing column %s of %v: %v", c.Name, t.Type, err)
+		}
+		if c.binary() {
+			return data, nil
 		}
-		return p
+		return string(data), nil
+	case c.writesNull(field):
+		return nil, nil
+	case c.Converter != nil:
+		return c.Converter.value(t, c, field)
+	default:
+		return reflect.Indirect(field).Interface(), nil
 	}
+}
+
+func sqlPlaceholders(n int, dbt DBType) string {
+	b := getBuffer()
+	writePlaceholders(b, 0, n, dbt)
+	return putBuffer(b)
+}
 
-	return strings.Repeat("?, ", n)[:(n*3)-2]
+// sqlInsertString returns an INSERT statement for columns. With no columns the SQL
+// databases get an INSERT of the column defaults, Cassandra requires at least the
+// primary key.
+func sqlInsertString(tableName string, columns []string, dbt DBType) (string, error) {
+	if len(columns) == 0 && dbt == Cassandra {
+		return "", ErrNoColumns
+	}
+
+	b := getBuffer()
+	b.WriteString("INSERT INTO ")
+	writeIdent(b, tableName, dbt)
+	if len(columns) == 0 {
+		if dbt == MySQL {
+			b.WriteString(" () VALUES ()")
+		} else {
+			b.WriteString(" DEFAULT VALUES")
+		}
+		return putBuffer(b), nil
+	}
+	b.WriteString(" (")
+	writeIdents(b, columns, ", ", dbt)
+	b.WriteString(") VALUES (")
+	writePlaceholders(b, 0, len(columns), dbt)
+	b.WriteByte(')')
+	return putBuffer(b), nil
 }
 
-func sqlInsertString(tableName string, columns []string, dbt DBType) string {
-	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, strings.Join(columns, ", "), sqlPlaceholders(len(columns), dbt))
+// sqlReplaceString returns a statement that inserts columns or replaces the row with
+// the same keys.
+func sqlReplaceString(tableName string, columns, keys []string, dbt DBType) (string, error) {
+	query, err := sqlInsertString(tableName, columns, dbt)
+	if err != nil {
+		return "", err
+	}
+	switch dbt {
+	case SQLite:
+		return "INSERT OR REPLACE" + query[len("INSERT"):], nil
+	case MySQL:
+		return "REPLACE" + query[len("INSERT"):], nil
+	case PostgreSQL:
+		return sqlUpsertString(tableName, columns, keys, dbt)
+	}
+	return query, nil
 }
 
-func updateAndGetSqlColumnsValues(thing interface{}, table *tableMap, data map[string]interface{}) ([]string, []interface{}) {
+// sqlUpsertString returns a statement that inserts columns or updates them in the row
+// with the same keys.
+func sqlUpsertString(tableName string, columns, keys []string, dbt DBType) (string, error) {
+	return sqlUpsertKeepString(tableName, columns, keys, nil, dbt)
+}
+
+// sqlUpsertKeepString is like sqlUpsertString but leaves the keep columns of an
+// existing row alone.
+func sqlUpsertKeepString(tableName string, columns, keys, keep []string, dbt DBType) (string, error) {
+	query, err := sqlInsertString(tableName, columns, dbt)
+	if err != nil || dbt == Cassandra {
+		return query, err
+	}
+	if len(keys) == 0 {
+		return "", ErrNoPrimaryKey
+	}
+
+	b := getBuffer()
+	b.WriteString(query)
+	if dbt == MySQL {
+		b.WriteString(" ON DUPLICATE KEY UPDATE ")
+	} else {
+		b.WriteString(" ON CONFLICT (")
+		writeIdents(b, keys, ", ", dbt)
+		b.WriteString(") DO UPDATE SET ")
+	}
+	set := 0
+	for _, c := range columns {
+		if contains(keys, c) || contains(keep, c) {
+			continue
+		}
+		if set > 0 {
+			b.WriteString(", ")
+		}
+		writeIdent(b, c, dbt)
+		if dbt == MySQL {
+			b.WriteString(" = VALUES(")
+			writeIdent(b, c, dbt)
+			b.WriteByte(')')
+		} else {
+			b.WriteString(" = excluded.")
+			writeIdent(b, c, dbt)
+		}
+		set++
+	}
+	if set == 0 {
+		// only keys were given, there is nothing to update
+		putBuffer(b)
+		switch dbt {
+		case MySQL:
+			return "INSERT IGNORE" + query[len("INSERT"):], nil
+		case SQLite:
+			return "INSERT OR IGNORE" + query[len("INSERT"):], nil
+		}
+		return query + " ON CONFLICT DO NOTHING", nil
+	}
+	return putBuffer(b), nil
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func updateAndGetSqlColumnsValues(thing interface{}, table *tableMap, data map[string]interface{}) ([]string, []interface{}, error) {
 	thingValue := reflect.Indirect(reflect.ValueOf(thing))
 	columns := make([]string, 0, len(table.Columns))
 	values := make([]interface{}, 0, len(table.Columns))
+	var dest, fields []reflect.Value
 
 	for i := 0; i < len(table.Columns); i++ {
 		column := table.Columns[i]
 
 		if val, ok := data[column.Name]; ok {
-			destField := thingValue.Field(column.Field)
-			value := reflect.ValueOf(val)
+			destField := thingValue.FieldByIndex(column.Field)
 
-			// assign the value from the data map to the destination struct field
-			destField.Set(value)
+			// assign the value from the data map to a copy of the destination struct
+			// field, so that the struct is unchanged if a value can't be written
+			field := reflect.New(destField.Type()).Elem()
+			assign(field, val)
 
-			if column.Serialize {
-				// TODO(jr): don't eat this marshal error value
-				marshaled, _ := json.Marshal(val)
-				values = append(values, string(marshaled))
-			} else {
-				values = append(values, reflect.Indirect(value).Interface())
+			v, err := columnValue(table, column, field)
+			if err != nil {
+				return nil, nil, err
 			}
+			values = append(values, v)
 			columns = append(columns, column.Name)
+			dest, fields = append(dest, destField), append(fields, field)
 		}
 	}
 
-	return columns, values
+	for i, field := range fields {
+		dest[i].Set(field)
+	}
+	return columns, values, nil
+}
+
+// validateUpdate checks that thing can be updated with data before anything is modified.
+// If keys is true data may only contain primary key columns, otherwise it may not contain any.
+func validateUpdate(thing interface{}, table *tableMap, data map[string]interface{}, keys bool) error {
+	thingValue := reflect.Indirect(reflect.ValueOf(thing))
+	if !thingValue.CanSet() {
+		return fmt.Errorf("m: Update requires a pointer to a struct, got %v", reflect.TypeOf(thing))
+	}
+
+	var unknown, incompatible, keyColumns, nonKeyColumns, readOnly []string
+	for name, val := range data {
+		column := table.column(name)
+		if column == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		if column.ReadOnly || column.CreateOnly {
+			readOnly = append(readOnly, name)
+		}
+		if column.PrimaryKey && !keys {
+			keyColumns = append(keyColumns, name)
+		} else if !column.PrimaryKey && keys {
+			nonKeyColumns = append(nonKeyColumns, name)
+		}
+		fieldType := thingValue.FieldByIndex(column.Field).Type()
+		if !assignable(val, fieldType) {
+			incompatible = append(incompatible, fmt.Sprintf("%s (%T into %v)", name, val, fieldType))
+		}
+	}
+
+	if len(unknown) > 0 || len(incompatible) > 0 || len(keyColumns) > 0 || len(nonKeyColumns) > 0 || len(readOnly) > 0 {
+		sort.Strings(unknown)
+		sort.Strings(incompatible)
+		sort.Strings(keyColumns)
+		sort.Strings(nonKeyColumns)
+		sort.Strings(readOnly)
+		return &UpdateError{
+			Type:            table.Type,
+			UnknownColumns:  unknown,
+			Incompatible:    incompatible,
+			KeyColumns:      keyColumns,
+			NonKeyColumns:   nonKeyColumns,
+			ReadOnlyColumns: readOnly,
+		}
+	}
+	return nil
+}
+
+// assignable reports whether assign can store val in a field of type typ.
+func assignable(val interface{}, typ reflect.Type) bool {
+	if val == nil {
+		return nillable(typ)
+	}
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(typ) {
+		return true
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nillable(typ)
+		}
+		v = v.Elem()
+	}
+	if convertible(v, typ) {
+		return true
+	}
+	return typ.Kind() == reflect.Ptr && convertible(v, typ.Elem())
+}
+
+// assign stores val in dest, converting between types and wrapping or unwrapping
+// pointers as needed. val must be assignable to dest's type.
+func assign(dest reflect.Value, val interface{}) {
+	typ := dest.Type()
+	if val == nil {
+		dest.Set(reflect.Zero(typ))
+		return
+	}
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(typ) {
+		dest.Set(v)
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			dest.Set(reflect.Zero(typ))
+			return
+		}
+		v = v.Elem()
+	}
+	if convertible(v, typ) {
+		dest.Set(v.Convert(typ))
+		return
+	}
+	p := reflect.New(typ.Elem())
+	p.Elem().Set(v.Convert(typ.Elem()))
+	dest.Set(p)
+}
+
+func nillable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// convertible reports whether v can be converted to typ without changing its meaning,
+// numbers must fit in the destination type and are never converted to strings.
+func convertible(v reflect.Value, typ reflect.Type) bool {
+	if v.Type().AssignableTo(typ) {
+		return true
+	}
+	if !v.Type().ConvertibleTo(typ) {
+		return false
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return v.Kind() == reflect.String || v.Kind() == reflect.Slice
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return !reflect.Zero(typ).OverflowInt(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return v.Uint() <= 1<<63-1 && !reflect.Zero(typ).OverflowInt(int64(v.Uint()))
+		}
+		return false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int() >= 0 && !reflect.Zero(typ).OverflowUint(uint64(v.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return !reflect.Zero(typ).OverflowUint(v.Uint())
+		}
+		return false
+	case reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return !reflect.Zero(typ).OverflowFloat(v.Float())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return true
+		}
+		return false
+	case reflect.Array:
+		// Converting a slice to an array panics unless the lengths match.
+		return v.Kind() == reflect.Array || v.Kind() == reflect.Slice && v.Len() == typ.Len()
+	case reflect.Ptr:
+		if v.Kind() == reflect.Slice {
+			return typ.Elem().Kind() == reflect.Array && v.Len() == typ.Elem().Len()
+		}
+		return v.Kind() == reflect.Ptr
+	case reflect.Interface:
+		return true
+	}
+	return v.Kind() == typ.Kind()
 }
 
 func keysForUpdate(thing interface{}, table *tableMap) ([]string, []interface{}) {
@@ -306,7 +1305,7 @@ func keysForUpdate(thing interface{}, table *tableMap) ([]string, []interface{})
 			continue
 		}
 
-		value := thingValue.Field(column.Field)
+		value := thingValue.FieldByIndex(column.Field)
 
 		columns = append(columns, column.Name)
 		values = append(values, reflect.Indirect(value).Interface())
@@ -315,50 +1314,92 @@ func keysForUpdate(thing interface{}, table *tableMap) ([]string, []interface{})
 	return columns, values
 }
 
-func columnPlaceholders(columns []string, sep string, dbt DBType) (res string) {
-	count := len(columns)
-	for i, column := range columns {
-		var placeholder string
-		if dbt == PostgreSQL {
-			placeholder = fmt.Sprintf("$%d", i+1)
-		} else {
-			placeholder = "?"
-		}
+// sqlUpdateString returns an UPDATE statement, using is an optional Cassandra USING clause.
+func sqlUpdateString(tableName, using string, columns []string, keys []string, dbt DBType) (string, error) {
+	if len(columns) == 0 {
+		return "", ErrNoColumns
+	}
+	if len(keys) == 0 {
+		return "", ErrNoPrimaryKey
+	}
 
-		res += column + " = " + placeholder
-		if i+1 < count {
-			res += sep
-		}
+	b := getBuffer()
+	b.WriteString("UPDATE ")
+	writeIdent(b, tableName, dbt)
+	if using != "" {
+		b.WriteByte(' ')
+		b.WriteString(using)
 	}
-	return
+	b.WriteString(" SET ")
+	writeColumnPlaceholders(b, columns, 0, ", ", dbt)
+	b.WriteString(" WHERE ")
+	writeColumnPlaceholders(b, keys, len(columns), " AND ", dbt)
+	return putBuffer(b), nil
 }
 
-func sqlUpdateString(tableName string, columns []string, keys []string, dbt DBType) string {
-	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", tableName, columnPlaceholders(columns, ", ", dbt), columnPlaceholders(keys, " AND ", dbt))
+func sqlDeleteString(tableName string, keys []string, dbt DBType) (string, error) {
+	if len(keys) == 0 {
+		return "", ErrNoPrimaryKey
+	}
+
+	b := getBuffer()
+	b.WriteString("DELETE FROM ")
+	writeIdent(b, tableName, dbt)
+	b.WriteString(" WHERE ")
+	writeColumnPlaceholders(b, keys, 0, " AND ", dbt)
+	return putBuffer(b), nil
 }
 
 type Query struct {
-	columns    string
-	conditions []string
-	bindings   []interface{}
-	limit      int
-	order      string
-	t          *tableMap
+	columns     string
+	conditions  []string
+	bindings    []interface{}
+	limit       int
+	offset      int
+	order       string
+	keyed       []string // columns with equality conditions
+	from        string   // replaces the table name in the FROM clause if set
+	preload     []string // relation fields to load
+	withDeleted bool
+	groupBy     string
+	having      []string
+	havingArgs  []interface{} // bindings of having, which follow the other bindings
+	joins       []joinClause
+	as          reflect.Type // result type of a query with joins, see As
+	sample      int          // number of random rows, see Sample
+	countOver   bool         // Page counts with a window function, see CountOver
+	ins         []inList     // IN lists that can be split, see split
+	err         error        // returned by the methods running the query
+	t           *tableMap
+	m           *Mapping
 }
 
 func (q *Query) Where(condition string, binding interface{}) *Query {
-	if condition[len(condition)-1] != '=' && condition[len(condition)-1] != '>' && condition[len(condition)-1] != '<' {
-		condition += " ="
+	condition = comparison(condition)
+	if column, ok := keyColumn(condition); ok {
+		q.keyed = append(q.keyed, column)
 	}
-	condition += " ?"
-	q.conditions = append(q.conditions, condition)
+	q.conditions = append(q.conditions, condition+" ?")
 	q.bindings = append(q.bindings, binding)
 
 	return q
 }
 
+// comparison adds an equals sign to condition if it doesn't end with an operator.
+func comparison(condition string) string {
+	if condition[len(condition)-1] != '=' && condition[len(condition)-1] != '>' && condition[len(condition)-1] != '<' {
+		condition += " ="
+	}
+	return condition
+}
+
+// In adds a condition that column is one of bindings. Do splits queries with more
+// bindings than a statement can have into several queries on parts of the longest IN
+// list and merges their rows.
 func (q *Query) In(column string, bindings ...interface{}) *Query {
-	q.conditions = append(q.conditions, column+" IN ("+sqlPlaceholders(len(bindings), q.t.m.Type)+")")
+	q.ins = append(q.ins, inList{column: column, condition: len(q.conditions), binding: len(q.bindings), n: len(bindings)})
+	q.conditions = append(q.conditions, column+" IN ("+questionMarks(len(bindings))+")")
+	q.keyed = append(q.keyed, column)
 	q.bindings = append(q.bindings, bindings...)
 
 	return q
@@ -374,24 +1415,164 @@ func (q *Query) Order(o string) *Query {
 	return q
 }
 
+// Offset skips the first n rows of the query, which should have an order. The skipped
+// rows are still read by the database, so for deep pages After is faster. OFFSET is not
+// supported on Cassandra.
+func (q *Query) Offset(n int) *Query {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: OFFSET is not supported on Cassandra"))
+		return q
+	}
+	q.offset = n
+	return q
+}
+
+// After selects the rows whose column is greater than value, ordered by column unless
+// the query already has an order. Passing the column of the last row of a page to After
+// returns the next page, without the cost of skipping the previous pages with Offset.
+// column should be unique, or the rows sharing its value across pages are skipped.
+//
+//	page, err := M.Query(Post{}, "*").After("id", lastID).Limit(20).Do()
+func (q *Query) After(column string, value interface{}) *Query {
+	q.Where(column+" >", value)
+	if q.order == "" && q.m.Type != Cassandra {
+		// Cassandra returns the rows of a partition in clustering order
+		q.order = column
+	}
+	return q
+}
+
 func (q *Query) Do() ([]interface{}, error) {
-	return q.t.doSelect(q.String(), q.bindings...)
+	return q.DoContext(context.Background())
+}
+
+// DoContext is like Do but runs the query with ctx.
+func (q *Query) DoContext(ctx context.Context) (_ []interface{}, err error) {
+	defer q.m.recover(&err)
+	if q.err != nil {
+		return nil, q.err
+	}
+	if q, err = q.prepared(); err != nil {
+		return nil, err
+	}
+	parts, err := q.split()
+	if err != nil {
+		return nil, err
+	}
+	var rows []interface{}
+	if parts != nil {
+		rows, err = q.doParts(ctx, parts)
+	} else {
+		rows, err = q.m.doSelect(ctx, q.t, q.String(), q.args()...)
+	}
+	if err == nil && len(q.preload) > 0 && q.as == nil {
+		err = q.m.preloadRelations(ctx, q.t, rows, q.preload)
+	}
+	return rows, err
+}
+
+// prepared returns q, or a copy of q with the limit, table and columns it is run with.
+func (q *Query) prepared() (*Query, error) {
+	if q.sample > 0 && (q.order != "" || q.offset > 0) {
+		return nil, fmt.Errorf("m: Sample can't be combined with Order or Offset")
+	}
+	limit, err := q.t.guardLimit(q.limit)
+	if err != nil {
+		return nil, err
+	}
+	if t := q.route(); limit != q.limit || t != q.t {
+		routed := *q
+		routed.limit = limit
+		routed.t = t
+		q = &routed
+	}
+	if len(q.joins) > 0 || q.as != nil {
+		return q.joined()
+	}
+	return q, nil
 }
 
 func (q *Query) String() string {
-	s := "SELECT " + q.columns + " FROM " + q.t.Name
+	cache := q.m.registry.queries
+	if cache == nil {
+		return q.build()
+	}
 
-	if len(q.conditions) > 0 {
-		s += " WHERE " + strings.Join(q.conditions, " AND ")
+	key := q.shapeKey()
+	if s, ok := cache.get(key, q); ok {
+		return s
 	}
+	s := q.build()
+	cache.put(key, q, s)
+	return s
+}
 
-	if q.order != "" {
-		s += " ORDER BY " + q.order
+func (q *Query) build() string {
+	b := getBuffer()
+	b.WriteString("SELECT ")
+	b.WriteString(q.columns)
+	b.WriteString(" FROM ")
+	if q.from != "" {
+		b.WriteString(q.from)
+	} else {
+		writeIdent(b, q.t.Name, q.m.Type)
+	}
+	if q.tableSample() {
+		b.WriteString(" TABLESAMPLE SYSTEM_ROWS (")
+		b.WriteString(strconv.Itoa(q.sample))
+		b.WriteByte(')')
+	}
+	for _, join := range q.joins {
+		b.WriteByte(' ')
+		b.WriteString(join.sql)
 	}
 
-	if q.limit > 0 {
-		s += " LIMIT " + strconv.Itoa(q.limit)
+	if notDeleted := q.excludesDeleted(); len(q.conditions) > 0 && notDeleted {
+		b.WriteString(" WHERE (")
+		writeJoined(b, q.conditions, " AND ")
+		b.WriteString(") AND ")
+		q.writeNotDeleted(b)
+	} else if notDeleted {
+		b.WriteString(" WHERE ")
+		q.writeNotDeleted(b)
+	} else if len(q.conditions) > 0 {
+		b.WriteString(" WHERE ")
+		writeJoined(b, q.conditions, " AND ")
 	}
 
-	return s
+	if q.groupBy != "" {
+		b.WriteString(" GROUP BY ")
+		b.WriteString(q.groupBy)
+	}
+	if len(q.having) > 0 {
+		b.WriteString(" HAVING ")
+		writeJoined(b, q.having, " AND ")
+	}
+
+	if q.sample > 0 {
+		q.writeSampleOrder(b)
+	} else if q.order != "" {
+		b.WriteString(" ORDER BY ")
+		b.WriteString(q.order)
+	}
+
+	if q.limit > 0 && q.sample == 0 {
+		b.WriteString(" LIMIT ")
+		b.WriteString(strconv.Itoa(q.limit))
+	} else if q.offset > 0 && q.m.Type == MySQL {
+		// MySQL and SQLite don't have OFFSET without LIMIT
+		b.WriteString(" LIMIT 18446744073709551615")
+	} else if q.offset > 0 && q.m.Type == SQLite {
+		b.WriteString(" LIMIT -1")
+	}
+	if q.offset > 0 {
+		b.WriteString(" OFFSET ")
+		b.WriteString(strconv.Itoa(q.offset))
+	}
+
+	// conditions are written with ? placeholders so that they can be combined
+	if q.m.Type == PostgreSQL {
+		return rebind(putBuffer(b))
+	}
+	return putBuffer(b)
 }
@@ -0,0 +1,160 @@
+package m
+
+import (
+	"context"
+	"fmt"
+)
+
+// Migration copies a table from one database to another, which can be of another type,
+// for example to move a table from Cassandra to PostgreSQL. Rows are streamed from the
+// source and upserted into the destination BatchSize at a time, so a migration that is
+// interrupted can be resumed from its last checkpoint and rows copied twice are
+// overwritten.
+//
+//	mg := &m.Migration{Progress: func(c m.Checkpoint) error {
+//		return saveCheckpoint(c)
+//	}}
+//	mg.Resume = loadCheckpoint()
+//	err := mg.Migrate(User{}, cassandra, postgres)
+type Migration struct {
+	BatchSize int
+
+	// Convert, if not nil, is called with each row of the source and returns the row to
+	// write to the destination, which can be a struct of another type mapped by the
+	// destination, or nil to skip the row.
+	Convert func(row interface{}) (interface{}, error)
+
+	// Progress, if not nil, is called after each batch has been written with a
+	// checkpoint the migration can be resumed from. Returning an error stops the
+	// migration and is returned.
+	Progress func(Checkpoint) error
+
+	// Resume, if not nil, resumes the migration after the given checkpoint.
+	Resume *Checkpoint
+}
+
+// Checkpoint is the progress of a Migration or Backfill.
+type Checkpoint struct {
+	Table string
+	Rows  int64         // rows read so far
+	Key   []interface{} // primary key of the last row read, nil on Cassandra
+}
+
+// DefaultMigrationBatch is the number of rows written at a time by a Migration when
+// BatchSize is not positive.
+const DefaultMigrationBatch = 500
+
+// Migrate copies the table for thing from source to dest with the default options.
+func Migrate(thing interface{}, source, dest *Mapping) error {
+	return (&Migration{}).Migrate(thing, source, dest)
+}
+
+// Migrate copies the table for thing from source to dest.
+func (mg *Migration) Migrate(thing interface{}, source, dest *Mapping) error {
+	return mg.MigrateContext(context.Background(), thing, source, dest)
+}
+
+// MigrateContext is like Migrate but runs the statements with ctx.
+func (mg *Migration) MigrateContext(ctx context.Context, thing interface{}, source, dest *Mapping) error {
+	t, err := source.table(thing)
+	if err != nil {
+		return err
+	}
+	batch := mg.BatchSize
+	if batch <= 0 {
+		batch = DefaultMigrationBatch
+	}
+	checkpoint := Checkpoint{Table: t.Name}
+	if mg.Resume != nil {
+		if mg.Resume.Table != t.Name {
+			return fmt.Errorf("m: checkpoint of table %s can't resume a migration of %s", mg.Resume.Table, t.Name)
+		}
+		checkpoint = *mg.Resume
+	}
+
+	rows := make([]interface{}, 0, batch)
+	write := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := dest.insertMany(ctx, rows, true); err != nil {
+			return err
+		}
+		rows = rows[:0]
+		if mg.Progress != nil {
+			return mg.Progress(checkpoint)
+		}
+		return nil
+	}
+	add := func(row interface{}) error {
+		checkpoint.Rows++
+		out := row
+		if mg.Convert != nil {
+			var err error
+			if out, err = mg.Convert(row); err != nil {
+				return err
+			}
+		}
+		if out != nil {
+			rows = append(rows, out)
+		}
+		return nil
+	}
+
+	if source.Type == Cassandra {
+		// Cassandra tables can't be paged by primary key, they are scanned in token order
+		// and resuming skips the rows that were already read
+		skip := checkpoint.Rows
+		checkpoint.Rows = 0
+		err := source.scanTable(ctx, t, func(row interface{}) error {
+			if checkpoint.Rows < skip {
+				checkpoint.Rows++
+				return nil
+			}
+			if err := add(row); err != nil {
+				return err
+			}
+			if len(rows) < batch {
+				return nil
+			}
+			return write()
+		})
+		if err != nil {
+			return err
+		}
+		return write()
+	}
+
+	keys := t.primaryKey()
+	if len(keys) == 0 {
+		return ErrNoPrimaryKey
+	}
+	for {
+		b := getBuffer()
+		fmt.Fprintf(b, "SELECT * FROM %s", quoteIdent(t.Name, source.Type))
+		if checkpoint.Key != nil {
+			fmt.Fprintf(b, " WHERE (%s) > (", quoteIdents(keys, source.Type))
+			writePlaceholders(b, 0, len(keys), source.Type)
+			b.WriteByte(')')
+		}
+		fmt.Fprintf(b, " ORDER BY %s LIMIT %d", quoteIdents(keys, source.Type), batch)
+		page, err := source.doSelect(ctx, t, putBuffer(b), checkpoint.Key...)
+		if err != nil {
+			return err
+		}
+		for _, row := range page {
+			if err = add(row); err != nil {
+				return err
+			}
+		}
+		if len(page) > 0 {
+			_, checkpoint.Key = keysForUpdate(page[len(page)-1], t)
+		}
+		if err = write(); err != nil {
+			return err
+		}
+		if len(page) < batch {
+			return nil
+		}
+	}
+}
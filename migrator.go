@@ -0,0 +1,231 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultMigrationsTable is the table Migrator records applied versions in unless its
+// Table is set.
+const DefaultMigrationsTable = "schema_migrations"
+
+// Migrator applies versioned schema changes, so that the schema can evolve next to the
+// mappings. Each version is applied in a transaction together with its row in the
+// migrations table, except on Cassandra, which has no transactions, and on MySQL, which
+// commits the transaction of every DDL statement.
+//
+//	mg := M.NewMigrator()
+//	mg.AddSQL(1, "CREATE TABLE posts (id bigint PRIMARY KEY, title text)", "DROP TABLE posts")
+//	mg.Add(2, func(m *m.Mapping) error { return m.CreateTablesIfNotExists() }, nil)
+//	err := mg.Migrate()
+//
+// Migrators of several processes should not run at the same time, for example take a
+// Lock around Migrate.
+type Migrator struct {
+	Table string
+
+	m          *Mapping
+	migrations []*migration
+	err        error // the first error of Add or AddSQL
+}
+
+type migration struct {
+	version  int64
+	up, down func(context.Context, *Mapping) error
+}
+
+// NewMigrator returns a Migrator without migrations.
+func (m *Mapping) NewMigrator() *Migrator {
+	return &Migrator{m: m}
+}
+
+// Add adds version, which is applied by calling up and rolled back by calling down.
+// down can be nil if the version can't be rolled back. Versions are applied in
+// increasing order, and each version can only be added once, adding it again makes
+// Migrate and Rollback fail.
+func (mg *Migrator) Add(version int64, up, down func(*Mapping) error) {
+	x := &migration{version: version, up: func(_ context.Context, m *Mapping) error { return up(m) }}
+	if down != nil {
+		x.down = func(_ context.Context, m *Mapping) error { return down(m) }
+	}
+	mg.add(x)
+}
+
+// AddSQL adds version, which is applied by executing the up statement and rolled back by
+// executing the down statement. down can be empty if the version can't be rolled back.
+func (mg *Migrator) AddSQL(version int64, up, down string) {
+	x := &migration{version: version, up: func(ctx context.Context, m *Mapping) error { return m.execDDL(ctx, up) }}
+	if down != "" {
+		x.down = func(ctx context.Context, m *Mapping) error { return m.execDDL(ctx, down) }
+	}
+	mg.add(x)
+}
+
+func (mg *Migrator) add(x *migration) {
+	for _, y := range mg.migrations {
+		if y.version == x.version {
+			if mg.err == nil {
+				mg.err = fmt.Errorf("m: migration %d added twice", x.version)
+			}
+			return
+		}
+	}
+	mg.migrations = append(mg.migrations, x)
+	sort.Slice(mg.migrations, func(i, j int) bool { return mg.migrations[i].version < mg.migrations[j].version })
+}
+
+// Migrate applies every added version that hasn't been applied yet, in order. Versions
+// lower than the current version are applied as well if they were added later, for
+// example after merging branches.
+func (mg *Migrator) Migrate() error {
+	return mg.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate but runs the statements with ctx.
+func (mg *Migrator) MigrateContext(ctx context.Context) error {
+	if mg.err != nil {
+		return mg.err
+	}
+	if err := mg.createTable(ctx); err != nil {
+		return err
+	}
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, x := range mg.migrations {
+		if applied[x.version] {
+			continue
+		}
+		if err = mg.run(ctx, x, x.up, true); err != nil {
+			return fmt.Errorf("m: migration %d: %v", x.version, err)
+		}
+	}
+	return nil
+}
+
+// Rollback rolls back the n most recent applied versions, in reverse order.
+func (mg *Migrator) Rollback(n int) error {
+	return mg.RollbackContext(context.Background(), n)
+}
+
+// RollbackContext is like Rollback but runs the statements with ctx.
+func (mg *Migrator) RollbackContext(ctx context.Context, n int) error {
+	if mg.err != nil {
+		return mg.err
+	}
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(mg.migrations) - 1; i >= 0 && n > 0; i-- {
+		x := mg.migrations[i]
+		if !applied[x.version] {
+			continue
+		}
+		if x.down == nil {
+			return fmt.Errorf("m: migration %d can't be rolled back", x.version)
+		}
+		if err = mg.run(ctx, x, x.down, false); err != nil {
+			return fmt.Errorf("m: rollback of migration %d: %v", x.version, err)
+		}
+		n--
+	}
+	if n > 0 {
+		return fmt.Errorf("m: %d more migrations to roll back have not been added", n)
+	}
+	return nil
+}
+
+// Version returns the highest applied version, or zero if none has been applied.
+func (mg *Migrator) Version() (int64, error) {
+	return mg.VersionContext(context.Background())
+}
+
+// VersionContext is like Version but runs the statement with ctx.
+func (mg *Migrator) VersionContext(ctx context.Context) (int64, error) {
+	applied, err := mg.applied(ctx)
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, err
+}
+
+func (mg *Migrator) table() string {
+	if mg.Table == "" {
+		return DefaultMigrationsTable
+	}
+	return mg.Table
+}
+
+func (mg *Migrator) createTable(ctx context.Context) error {
+	var timestamp string
+	switch mg.m.Type {
+	case PostgreSQL:
+		timestamp = "timestamptz"
+	case MySQL:
+		timestamp = "datetime(6)"
+	default:
+		timestamp = "timestamp"
+	}
+	return mg.m.execDDL(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version bigint PRIMARY KEY, applied_at %s)", quoteIdent(mg.table(), mg.m.Type), timestamp))
+}
+
+// applied returns the applied versions.
+func (mg *Migrator) applied(ctx context.Context) (map[int64]bool, error) {
+	ctx = WithPrimary(ctx)
+	s := &Statement{Op: OpSelect, Table: mg.table(), SQL: "SELECT version FROM " + quoteIdent(mg.table(), mg.m.Type), Idempotent: true}
+	applied := make(map[int64]bool)
+	err := mg.m.run(ctx, s, 0, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int64
+			if err = rows.Scan(&version); err != nil {
+				return err
+			}
+			applied[version] = true
+		}
+		return rows.Err()
+	})
+	return applied, err
+}
+
+// run applies or rolls back x by calling fn and records it in the migrations table.
+func (mg *Migrator) run(ctx context.Context, x *migration, fn func(context.Context, *Mapping) error, up bool) error {
+	record := func(m *Mapping) error {
+		if err := fn(ctx, m); err != nil {
+			return err
+		}
+		s := &Statement{Table: mg.table(), Idempotent: true}
+		if up {
+			s.Op = OpInsert
+			s.SQL, _ = sqlInsertString(s.Table, []string{"version", "applied_at"}, m.Type)
+			s.Args = []interface{}{x.version, time.Now()}
+		} else {
+			s.Op = OpDelete
+			s.SQL, _ = sqlDeleteString(s.Table, []string{"version"}, m.Type)
+			s.Args = []interface{}{x.version}
+		}
+		_, err := m.exec(ctx, nil, s)
+		return err
+	}
+	if mg.m.Type == Cassandra || mg.m.Type == MySQL {
+		return record(mg.m)
+	}
+	return mg.m.inTx(ctx, record)
+}
+
+// execDDL executes query, which isn't for a registered table.
+func (m *Mapping) execDDL(ctx context.Context, query string) error {
+	_, err := m.exec(ctx, nil, &Statement{Op: OpUpdate, SQL: query})
+	return err
+}
@@ -0,0 +1,35 @@
+package m
+
+import "fmt"
+
+// Args are the values of the :name parameters of a statement or a raw condition, passed
+// as its only binding. The names are replaced with placeholders for the database, and a
+// name can be used more than once:
+//
+//	posts, err := M.Select(Post{}, "SELECT * FROM posts WHERE author = :author", m.Args{"author": id})
+//	q.WhereRaw("created_at > :since OR updated_at > :since", m.Args{"since": t})
+//
+// Quoted strings and PostgreSQL :: casts are left alone.
+type Args map[string]interface{}
+
+// bindNamed replaces the :name parameters of query with placeholders for dbt if its only
+// binding is Args, and returns the query and its bindings.
+func bindNamed(query string, bindings []interface{}, dbt DBType) (string, []interface{}, error) {
+	if len(bindings) != 1 {
+		return query, bindings, nil
+	}
+	args, ok := bindings[0].(Args)
+	if !ok {
+		return query, bindings, nil
+	}
+	query, names := compileNamed(query, dbt)
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		v, ok := args[name]
+		if !ok {
+			return "", nil, fmt.Errorf("m: no value for parameter :%s", name)
+		}
+		values[i] = v
+	}
+	return query, values, nil
+}
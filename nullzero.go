@@ -0,0 +1,34 @@
+package m
+
+import "reflect"
+
+// NULL columns are scanned as nil into pointer fields and as invalid into fields of the
+// sql.Null types, and both are written as NULL. Other fields can't hold NULL, so they
+// can be tagged nullzero to scan NULL as the zero value of the field and to write the
+// zero value as NULL:
+//
+//	Bio string `db:"bio,nullzero"`
+
+// nullZeroTarget is the destination of a column tagged nullzero, which is scanned into a
+// pointer to the type of the field first.
+type nullZeroTarget struct {
+	ptr   reflect.Value // **T
+	field reflect.Value // T
+}
+
+// nullZeroDest returns the scan destination of field for a column tagged nullzero.
+func nullZeroDest(field reflect.Value) nullZeroTarget {
+	return nullZeroTarget{reflect.New(reflect.PtrTo(field.Type())), field}
+}
+
+// set sets the field to the scanned value, leaving it zero for NULL.
+func (d nullZeroTarget) set() {
+	if p := d.ptr.Elem(); !p.IsNil() {
+		d.field.Set(p.Elem())
+	}
+}
+
+// writesNull reports whether v, the field of c, is written as NULL.
+func (c *columnMap) writesNull(v reflect.Value) bool {
+	return v.Kind() == reflect.Ptr && v.IsNil() || c.NullZero && v.IsZero()
+}
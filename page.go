@@ -0,0 +1,116 @@
+package m
+
+import (
+	"context"
+	"fmt"
+)
+
+// totalColumn is the column Page selects the total number of rows in with CountOver.
+const totalColumn = "m_page_total"
+
+// Page returns the rows of the query, limited by its Limit and Offset, and the number
+// of rows matching the query without them, as needed by paginated listings:
+//
+//	posts, total, err := M.Query(Post{}, "*").Where("author_id =", id).Order("id").Limit(20).Offset(40).Page()
+//
+// The rows and the total are selected with two statements, see CountOver.
+func (q *Query) Page() ([]interface{}, int64, error) {
+	return q.PageContext(context.Background())
+}
+
+// PageContext is like Page but runs the queries with ctx.
+func (q *Query) PageContext(ctx context.Context) (rows []interface{}, total int64, err error) {
+	defer q.m.recover(&err)
+	if q.err != nil {
+		return nil, 0, q.err
+	}
+	if q.countOver {
+		rows, total, err = q.pageOver(ctx)
+	} else if rows, err = q.DoContext(ctx); err == nil {
+		total, err = q.CountContext(ctx)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, total, nil
+}
+
+// CountOver makes Page select the total number of rows together with the rows, with
+// COUNT(*) OVER (), which halves the round trips of a page. The window function needs
+// MySQL 8 or SQLite 3.25, and it isn't supported on Cassandra. Pages past the last row
+// have no row to carry the total, so it is counted with a second statement.
+func (q *Query) CountOver() *Query {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: window functions are not supported on Cassandra"))
+		return q
+	}
+	q.countOver = true
+	return q
+}
+
+// pageOver selects the rows of q and the total with a single statement.
+func (q *Query) pageOver(ctx context.Context) (rows []interface{}, total int64, err error) {
+	p, err := q.prepared()
+	if err != nil {
+		return nil, 0, err
+	}
+	if p == q {
+		c := *q
+		p = &c
+	}
+	b := getBuffer()
+	if p.columns == "*" {
+		// MySQL only takes an unqualified * on its own
+		writeIdent(b, p.t.Name, p.m.Type)
+		b.WriteString(".*")
+	} else {
+		b.WriteString(p.columns)
+	}
+	b.WriteString(", count(*) OVER () AS ")
+	b.WriteString(totalColumn)
+	p.columns = putBuffer(b)
+	t := p.t
+	s := &Statement{Op: OpSelect, Table: t.Name, SQL: p.String(), Args: p.args(), Idempotent: true}
+	if s.SQL, err = t.guardQuery(s.SQL, q.m.Type); err != nil {
+		return nil, 0, err
+	}
+	err = q.m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, qr querier) error {
+		sqlRows, done, err := q.m.queryRows(ctx, s, qr)
+		if err != nil {
+			done()
+			return err
+		}
+		defer done()
+		defer sqlRows.Close()
+		sc, err := q.m.newRowScanner(t, sqlRows)
+		if err != nil {
+			return err
+		}
+		defer sc.close()
+		sc.buf.extra = map[string]interface{}{totalColumn: &total}
+
+		rows = rows[:0]
+		for sqlRows.Next() {
+			instance, err := sc.scan()
+			if err != nil {
+				return err
+			}
+			// once rows have been handed out, retrying would hand them out again
+			s.Idempotent = false
+			rows = append(rows, instance)
+		}
+		return sqlRows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(p.preload) > 0 && p.as == nil {
+		if err = q.m.preloadRelations(ctx, t, rows, p.preload); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(rows) == 0 && p.offset > 0 {
+		total, err = q.CountContext(ctx)
+	}
+	return rows, total, err
+}
@@ -0,0 +1,208 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PartitionInterval is the range of time covered by each partition of a table
+// partitioned with SetPartitioning.
+type PartitionInterval int
+
+const (
+	Daily PartitionInterval = iota
+	Weekly
+	Monthly
+)
+
+type partitionMap struct {
+	Column   string
+	Interval PartitionInterval
+}
+
+// SetPartitioning marks the table for thing as a PostgreSQL table partitioned by range
+// on a time column. Inserts still go to the parent table and are routed by the
+// database, CreatePartition and PrunePartitions manage the child tables.
+//
+//	M.SetPartitioning(Event{}, "created_at", m.Monthly)
+func (m *Mapping) SetPartitioning(thing interface{}, column string, interval PartitionInterval) {
+	t := m.lookupTable(thing)
+	if t.column(column) == nil {
+		panic(fmt.Sprintf("Unknown partition column %s for type: %v", column, t.Type))
+	}
+	t.Partition = &partitionMap{Column: column, Interval: interval}
+}
+
+// CreatePartition creates the partition of the table for thing that contains at, if it
+// doesn't exist yet.
+func (m *Mapping) CreatePartition(thing interface{}, at time.Time) error {
+	t, err := m.partitionedTable(thing)
+	if err != nil {
+		return err
+	}
+	start := t.Partition.Interval.start(at)
+	end := t.Partition.Interval.next(start)
+	return m.execDDL(context.Background(), fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		t.partitionName(start), t.Name, start.Format(time.RFC3339), end.Format(time.RFC3339)))
+}
+
+// PrunePartitions drops every partition of the table for thing that only contains
+// rows older than before, and returns the names of the dropped partitions.
+func (m *Mapping) PrunePartitions(thing interface{}, before time.Time) ([]string, error) {
+	t, err := m.partitionedTable(thing)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := WithPrimary(context.Background())
+	s := &Statement{Op: OpSelect, SQL: "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass", Args: []interface{}{t.Name}, Idempotent: true}
+	var expired []string
+	err = m.run(ctx, s, 0, func(ctx context.Context, q querier) error {
+		expired = expired[:0]
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				return err
+			}
+			if start, ok := t.partitionStart(name); ok && !t.Partition.Interval.next(start).After(before) {
+				expired = append(expired, name)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := make([]string, 0, len(expired))
+	for _, name := range expired {
+		if err = m.execDDL(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// MaintainPartitions creates the current partition of the table for thing plus ahead
+// future ones, and drops partitions more than retain intervals old.
+func (m *Mapping) MaintainPartitions(thing interface{}, ahead, retain int) error {
+	t, err := m.partitionedTable(thing)
+	if err != nil {
+		return err
+	}
+	interval := t.Partition.Interval
+	current := interval.start(time.Now())
+
+	at := current
+	for i := 0; i <= ahead; i++ {
+		if err = m.CreatePartition(thing, at); err != nil {
+			return err
+		}
+		at = interval.next(at)
+	}
+
+	cutoff := current
+	for i := 0; i < retain; i++ {
+		cutoff = interval.prev(cutoff)
+	}
+	_, err = m.PrunePartitions(thing, cutoff)
+	return err
+}
+
+// SchedulePartitions runs MaintainPartitions immediately and then every interval until
+// the returned stop function is called. Errors are sent to errs if it is not nil.
+func (m *Mapping) SchedulePartitions(thing interface{}, ahead, retain int, every time.Duration, errs chan<- error) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			if err := m.MaintainPartitions(thing, ahead, retain); err != nil && errs != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (m *Mapping) partitionedTable(thing interface{}) (*tableMap, error) {
+	t, err := m.table(thing)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != PostgreSQL {
+		return nil, fmt.Errorf("m: partitioned tables are only supported on PostgreSQL")
+	}
+	if t.Partition == nil {
+		return nil, fmt.Errorf("m: table %s is not partitioned", t.Name)
+	}
+	return t, nil
+}
+
+func (t *tableMap) partitionName(start time.Time) string {
+	return t.Name + "_p" + start.Format(t.Partition.Interval.layout())
+}
+
+func (t *tableMap) partitionStart(name string) (time.Time, bool) {
+	prefix := t.Name + "_p"
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(t.Partition.Interval.layout(), name[len(prefix):])
+	return start, err == nil
+}
+
+func (i PartitionInterval) layout() string {
+	if i == Monthly {
+		return "200601"
+	}
+	return "20060102"
+}
+
+func (i PartitionInterval) start(at time.Time) time.Time {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	switch i {
+	case Weekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)) // weeks start on Monday
+	case Monthly:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func (i PartitionInterval) next(start time.Time) time.Time {
+	switch i {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func (i PartitionInterval) prev(start time.Time) time.Time {
+	switch i {
+	case Weekly:
+		return start.AddDate(0, 0, -7)
+	case Monthly:
+		return start.AddDate(0, -1, 0)
+	}
+	return start.AddDate(0, 0, -1)
+}
@@ -0,0 +1,46 @@
+package m
+
+import (
+	"errors"
+	"regexp"
+)
+
+// A Policy is called with every statement before it is executed, returning an error
+// vetoes the statement. Policies are guardrails for large codebases, like forbidding
+// writes without a WHERE clause or queries that don't filter by tenant.
+type Policy func(*Statement) error
+
+// SetPolicy sets the policies that statements executed by m must pass, in order.
+func (m *Mapping) SetPolicy(policies ...Policy) {
+	m.policies = policies
+}
+
+// PolicyError is returned when a statement is vetoed by a Policy.
+type PolicyError struct {
+	Statement *Statement
+	Err       error
+}
+
+func (e *PolicyError) Error() string {
+	return "m: " + e.Statement.Op.String() + " statement rejected by policy: " + e.Err.Error()
+}
+
+func (m *Mapping) checkPolicy(s *Statement) error {
+	for _, p := range m.policies {
+		if err := p(s); err != nil {
+			return &PolicyError{s, err}
+		}
+	}
+	return nil
+}
+
+var wherePattern = regexp.MustCompile(`(?i)\bwhere\b`)
+
+// RequireWhere is a Policy that forbids UPDATE and DELETE statements without a WHERE
+// clause.
+func RequireWhere(s *Statement) error {
+	if (s.Op == OpUpdate || s.Op == OpDelete) && !wherePattern.MatchString(s.SQL) {
+		return errors.New("missing WHERE clause")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrPreparedUnsupported is returned by Tx.Prepare on databases other than PostgreSQL.
+var ErrPreparedUnsupported = errors.New("m: prepared transactions are only supported on PostgreSQL")
+
+// Prepare prepares the transaction for a two-phase commit with PREPARE TRANSACTION, so
+// that it survives crashes and can be committed or rolled back later, also by another
+// process, under the global id. After Prepare only Commit and Rollback can be called,
+// which run COMMIT PREPARED and ROLLBACK PREPARED, and the connection of tx is back in
+// the pool. Transactions prepared in several databases let a coordinator commit them
+// all or none:
+//
+//	if err := a.Prepare(id); err != nil {
+//		return err
+//	}
+//	if err := b.Prepare(id); err != nil {
+//		a.Rollback()
+//		return err
+//	}
+//	a.Commit()
+//	b.Commit()
+//
+// Prepared transactions hold their locks until they are finished, and PostgreSQL needs
+// max_prepared_transactions to be set. A coordinator should finish the transactions
+// left in doubt by a crash, see PreparedTransactions.
+func (tx *Tx) Prepare(id string) error {
+	return tx.PrepareContext(context.Background(), id)
+}
+
+// PrepareContext is like Prepare but runs the statement with ctx.
+func (tx *Tx) PrepareContext(ctx context.Context, id string) error {
+	if tx.Type != PostgreSQL {
+		return ErrPreparedUnsupported
+	}
+	if tx.parent != nil {
+		return errors.New("m: savepoints can't be prepared, prepare their transaction")
+	}
+	if tx.done || tx.prepared != "" {
+		return sql.ErrTxDone
+	}
+	if err := tx.commitOpen(); err != nil {
+		return err
+	}
+	if err := tx.execDDL(ctx, "PREPARE TRANSACTION "+quoteString(id)); err != nil {
+		return err
+	}
+	tx.prepared = id
+	// the session isn't in a transaction anymore, committing only returns the
+	// connection to the pool
+	return tx.tx.Commit()
+}
+
+// PreparedTransaction is a transaction prepared with Tx.Prepare that is neither
+// committed nor rolled back yet.
+type PreparedTransaction struct {
+	ID       string
+	Prepared time.Time
+	Owner    string
+}
+
+// PreparedTransactions returns the prepared transactions of the database of m, for a
+// coordinator to commit or roll back those left in doubt by a crash with CommitPrepared
+// and RollbackPrepared.
+func (m *Mapping) PreparedTransactions() ([]PreparedTransaction, error) {
+	return m.PreparedTransactionsContext(context.Background())
+}
+
+// PreparedTransactionsContext is like PreparedTransactions but runs the query with ctx.
+func (m *Mapping) PreparedTransactionsContext(ctx context.Context) ([]PreparedTransaction, error) {
+	if m.Type != PostgreSQL {
+		return nil, ErrPreparedUnsupported
+	}
+	ctx = WithPrimary(ctx)
+	s := &Statement{Op: OpSelect, SQL: "SELECT gid, prepared, owner FROM pg_prepared_xacts WHERE database = current_database() ORDER BY prepared", Idempotent: true}
+	var res []PreparedTransaction
+	err := m.run(ctx, s, 0, func(ctx context.Context, q querier) error {
+		res = res[:0]
+		rows, err := q.QueryContext(ctx, s.SQL)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p PreparedTransaction
+			if err = rows.Scan(&p.ID, &p.Prepared, &p.Owner); err != nil {
+				return err
+			}
+			res = append(res, p)
+		}
+		return rows.Err()
+	})
+	return res, err
+}
+
+// CommitPrepared commits the prepared transaction id.
+func (m *Mapping) CommitPrepared(id string) error {
+	return m.CommitPreparedContext(context.Background(), id)
+}
+
+// CommitPreparedContext is like CommitPrepared but runs the statement with ctx.
+func (m *Mapping) CommitPreparedContext(ctx context.Context, id string) error {
+	if m.Type != PostgreSQL {
+		return ErrPreparedUnsupported
+	}
+	return m.outside().execDDL(ctx, "COMMIT PREPARED "+quoteString(id))
+}
+
+// RollbackPrepared rolls back the prepared transaction id.
+func (m *Mapping) RollbackPrepared(id string) error {
+	return m.RollbackPreparedContext(context.Background(), id)
+}
+
+// RollbackPreparedContext is like RollbackPrepared but runs the statement with ctx.
+func (m *Mapping) RollbackPreparedContext(ctx context.Context, id string) error {
+	if m.Type != PostgreSQL {
+		return ErrPreparedUnsupported
+	}
+	return m.outside().execDDL(ctx, "ROLLBACK PREPARED "+quoteString(id))
+}
+
+// outside returns m, or a copy of m that executes statements outside its transaction,
+// as needed to finish prepared transactions.
+func (m *Mapping) outside() *Mapping {
+	if m.tx == nil {
+		return m
+	}
+	c := *m
+	c.tx = nil
+	return &c
+}
+
+// quoteString returns s as a string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
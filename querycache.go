@@ -0,0 +1,152 @@
+package m
+
+import (
+	"strconv"
+	"sync"
+)
+
+// maxCachedQueries bounds the number of query shapes remembered per Registry, the cache
+// is reset when it fills up.
+const maxCachedQueries = 1024
+
+// queryCache maps the structure of a Query (database type, table, columns, conditions,
+// grouping, order, limit and offset, but not bindings) to its generated SQL so that hot
+// endpoints building the same query over and over don't pay for the string
+// concatenation every time. Mappings of several database types can share it through
+// their Registry.
+type queryCache struct {
+	sync.RWMutex
+	entries map[uint64]*cachedQuery
+}
+
+type cachedQuery struct {
+	dbt        DBType
+	table      string
+	from       string
+	columns    string
+	conditions []string
+	joins      []string
+	groupBy    string
+	having     []string
+	order      string
+	limit      int
+	offset     int
+	sample     int
+	deleted    bool
+	sql        string
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{entries: make(map[uint64]*cachedQuery)}
+}
+
+func (c *queryCache) get(key uint64, q *Query) (string, bool) {
+	c.RLock()
+	e, ok := c.entries[key]
+	c.RUnlock()
+	if !ok || !e.matches(q) {
+		return "", false
+	}
+	return e.sql, true
+}
+
+func (c *queryCache) put(key uint64, q *Query, sql string) {
+	e := &cachedQuery{
+		dbt:        q.m.Type,
+		table:      q.t.Name,
+		from:       q.from,
+		columns:    q.columns,
+		conditions: append([]string(nil), q.conditions...),
+		joins:      joinStrings(q.joins),
+		groupBy:    q.groupBy,
+		having:     append([]string(nil), q.having...),
+		order:      q.order,
+		limit:      q.limit,
+		offset:     q.offset,
+		sample:     q.sample,
+		deleted:    q.withDeleted,
+		sql:        sql,
+	}
+	c.Lock()
+	if len(c.entries) >= maxCachedQueries {
+		c.entries = make(map[uint64]*cachedQuery)
+	}
+	c.entries[key] = e
+	c.Unlock()
+}
+
+func (e *cachedQuery) matches(q *Query) bool {
+	if e.dbt != q.m.Type || e.table != q.t.Name || e.from != q.from || e.columns != q.columns || e.order != q.order || e.limit != q.limit || e.offset != q.offset || e.sample != q.sample || e.deleted != q.withDeleted || e.groupBy != q.groupBy ||
+		len(e.conditions) != len(q.conditions) || len(e.joins) != len(q.joins) || len(e.having) != len(q.having) {
+		return false
+	}
+	for i, c := range e.conditions {
+		if c != q.conditions[i] {
+			return false
+		}
+	}
+	for i, j := range e.joins {
+		if j != q.joins[i].sql {
+			return false
+		}
+	}
+	for i, c := range e.having {
+		if c != q.having[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinStrings(joins []joinClause) []string {
+	if len(joins) == 0 {
+		return nil
+	}
+	s := make([]string, len(joins))
+	for i, j := range joins {
+		s[i] = j.sql
+	}
+	return s
+}
+
+// shapeKey returns an FNV-1a hash of the structure of q.
+func (q *Query) shapeKey() uint64 {
+	h := uint64(14695981039346656037)
+	write := func(s string) {
+		for i := 0; i < len(s); i++ {
+			h ^= uint64(s[i])
+			h *= 1099511628211
+		}
+		h ^= 0xff // separator, so that "ab","c" and "a","bc" differ
+		h *= 1099511628211
+	}
+
+	write(strconv.Itoa(int(q.m.Type)))
+	write(q.t.Name)
+	write(q.from)
+	write(q.columns)
+	for _, c := range q.conditions {
+		write(c)
+	}
+	for _, j := range q.joins {
+		write(j.sql)
+	}
+	write(q.groupBy)
+	for _, c := range q.having {
+		write(c)
+	}
+	write(q.order)
+	if q.limit > 0 {
+		write(strconv.Itoa(q.limit))
+	}
+	if q.offset > 0 {
+		write("+" + strconv.Itoa(q.offset))
+	}
+	if q.sample > 0 {
+		write("~" + strconv.Itoa(q.sample))
+	}
+	if q.withDeleted {
+		write("deleted")
+	}
+	return h
+}
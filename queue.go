@@ -0,0 +1,243 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Queue is a job queue stored in a mapped table. Each job is a row, workers claim jobs
+// for a lease, and then either Ack them when done or Retry them later with backoff.
+// On PostgreSQL jobs are claimed with FOR UPDATE SKIP LOCKED, on Cassandra with
+// lightweight transactions, SQLite needs version 3.35 for RETURNING. The job struct needs these columns, by default:
+//
+//	run_at       time.Time  // when the job can next run, NULL once it has failed for good
+//	locked_until *time.Time // end of the lease of the worker running it
+//	attempts     int        // number of times the job has been claimed
+type Queue struct {
+	Lease       time.Duration // how long a claimed job is hidden from other workers
+	MaxAttempts int           // attempts after which Retry gives up on a job, zero means no limit
+
+	// Backoff returns how long to wait before running a job again after its attempt'th
+	// failure. It defaults to exponential backoff starting at a second.
+	Backoff func(attempt int) time.Duration
+
+	RunAt       string
+	LockedUntil string
+	Attempts    string
+
+	m *Mapping
+	t *tableMap
+}
+
+// NewQueue returns a Queue of jobs stored in the table for thing.
+func (m *Mapping) NewQueue(thing interface{}) *Queue {
+	t := m.lookupTable(thing)
+	if c := t.column("attempts"); c != nil && !isInteger(t.Type.FieldByIndex(c.Field).Type) {
+		panic(fmt.Sprintf("Queue attempts column is not an integer for type: %v", t.Type))
+	}
+	return &Queue{
+		Lease:       time.Minute,
+		Backoff:     func(attempt int) time.Duration { return time.Second << uint(attempt-1) },
+		RunAt:       "run_at",
+		LockedUntil: "locked_until",
+		Attempts:    "attempts",
+		m:           m,
+		t:           t,
+	}
+}
+
+// isInteger reports whether typ, or the type it points to, is an integer type.
+func isInteger(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// Enqueue inserts job, which runs immediately unless its run_at column is set.
+func (q *Queue) Enqueue(job interface{}) error {
+	if c := q.t.column(q.RunAt); c != nil {
+		job = setTimeIfZero(q.t, job, c, time.Now())
+	}
+	return q.m.insert(context.Background(), q.t, job)
+}
+
+// Claim claims up to n jobs that are ready to run, for the lease of the queue.
+func (q *Queue) Claim(n int) ([]interface{}, error) {
+	if q.m.Type == Cassandra {
+		return q.claimLWT(n)
+	}
+	if q.m.Type == MySQL {
+		return nil, fmt.Errorf("m: queues are not supported on MySQL")
+	}
+
+	now := time.Now()
+	dbt := q.m.Type
+	table, keys := quoteIdent(q.t.Name, dbt), quoteIdents(q.t.primaryKey(), dbt)
+	lockedUntil, runAt := quoteIdent(q.LockedUntil, dbt), quoteIdent(q.RunAt, dbt)
+	b := getBuffer()
+	fmt.Fprintf(b, "UPDATE %s SET %s = ", table, lockedUntil)
+	writePlaceholder(b, 0, dbt)
+	fmt.Fprintf(b, ", %s = %[1]s + 1 WHERE (%s) IN (SELECT %[2]s FROM %s WHERE %s <= ", quoteIdent(q.Attempts, dbt), keys, table, runAt)
+	writePlaceholder(b, 1, dbt)
+	fmt.Fprintf(b, " AND (%s IS NULL OR %[1]s < ", lockedUntil)
+	writePlaceholder(b, 2, dbt)
+	fmt.Fprintf(b, ") ORDER BY %s LIMIT %d", runAt, n)
+	if dbt != SQLite { // SQLite serializes writes instead
+		b.WriteString(" FOR UPDATE SKIP LOCKED")
+	}
+	b.WriteString(") RETURNING *")
+
+	var jobs []interface{}
+	s := &Statement{Op: OpUpdate, Table: q.t.Name, SQL: putBuffer(b), Args: []interface{}{now.Add(q.Lease), now, now}}
+	err := q.m.query(context.Background(), q.t, s, func(job interface{}) error {
+		jobs = append(jobs, job)
+		return nil
+	})
+	return jobs, err
+}
+
+// claimLWT claims jobs on Cassandra by conditionally updating the lease of candidates.
+func (q *Queue) claimLWT(n int) ([]interface{}, error) {
+	now := time.Now()
+	dbt := q.m.Type
+	candidates, err := q.m.doSelect(context.Background(), q.t, fmt.Sprintf("SELECT * FROM %s WHERE %s <= ? LIMIT %d ALLOW FILTERING", quoteIdent(q.t.Name, dbt), quoteIdent(q.RunAt, dbt), n*4), now)
+	if err != nil {
+		return nil, err
+	}
+
+	keyColumns := q.t.primaryKey()
+	b := getBuffer()
+	fmt.Fprintf(b, "UPDATE %s SET %s = ?, %s = ? WHERE ", quoteIdent(q.t.Name, dbt), quoteIdent(q.LockedUntil, dbt), quoteIdent(q.Attempts, dbt))
+	writeColumnPlaceholders(b, keyColumns, 2, " AND ", dbt)
+	fmt.Fprintf(b, " IF %s = ?", quoteIdent(q.LockedUntil, dbt))
+	query := putBuffer(b)
+
+	var jobs []interface{}
+	for _, job := range candidates {
+		if len(jobs) >= n {
+			break
+		}
+		v := reflect.ValueOf(job).Elem()
+		locked := q.fieldValue(v, q.LockedUntil)
+		if until, ok := locked.(time.Time); ok && until.After(now) {
+			continue
+		}
+		attempts, err := q.attempts(v)
+		if err != nil {
+			return jobs, err
+		}
+		attempts++
+
+		_, keyValues := keysForUpdate(job, q.t)
+		args := append([]interface{}{now.Add(q.Lease), attempts}, keyValues...)
+		applied, err := q.m.applied(context.Background(), q.t, &Statement{Op: OpUpdate, Table: q.t.Name, SQL: query, Args: append(args, locked)})
+		if err != nil {
+			return jobs, err
+		}
+		if applied {
+			assign(v.FieldByIndex(q.t.column(q.LockedUntil).Field), now.Add(q.Lease))
+			assign(v.FieldByIndex(q.t.column(q.Attempts).Field), attempts)
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// Ack removes a finished job from the queue.
+func (q *Queue) Ack(job interface{}) error {
+	return q.m.delete(context.Background(), q.t, job)
+}
+
+// Retry releases a failed job so that it runs again after the backoff for its number of
+// attempts. Once the job has been attempted MaxAttempts times it is kept with a NULL
+// run_at instead, so that it can be inspected but never runs again.
+func (q *Queue) Retry(job interface{}) error {
+	attempts, err := q.attempts(reflect.Indirect(reflect.ValueOf(job)))
+	if err != nil {
+		return err
+	}
+	var runAt interface{}
+	if q.MaxAttempts <= 0 || attempts < int64(q.MaxAttempts) {
+		runAt = time.Now().Add(q.Backoff(int(attempts)))
+	}
+
+	keyColumns, keyValues := keysForUpdate(job, q.t)
+	b := getBuffer()
+	fmt.Fprintf(b, "UPDATE %s SET %s = ", quoteIdent(q.t.Name, q.m.Type), quoteIdent(q.RunAt, q.m.Type))
+	writePlaceholder(b, 0, q.m.Type)
+	fmt.Fprintf(b, ", %s = NULL WHERE ", quoteIdent(q.LockedUntil, q.m.Type))
+	writeColumnPlaceholders(b, keyColumns, 1, " AND ", q.m.Type)
+	_, err = q.m.exec(context.Background(), q.t, &Statement{Op: OpUpdate, Table: q.t.Name, SQL: putBuffer(b), Args: append([]interface{}{runAt}, keyValues...), Idempotent: true})
+	return err
+}
+
+// attempts returns the attempts column of the job struct v, which is 0 if it is NULL.
+func (q *Queue) attempts(v reflect.Value) (int64, error) {
+	a := q.fieldValue(v, q.Attempts)
+	if a == nil {
+		return 0, nil
+	}
+	rv := reflect.ValueOf(a)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("m: queue column %s of %v is a %v, not an integer", q.Attempts, q.t.Type, rv.Type())
+}
+
+// fieldValue returns the value of column of the job struct v, with nil pointers as nil.
+func (q *Queue) fieldValue(v reflect.Value, column string) interface{} {
+	c := q.t.column(column)
+	if c == nil {
+		panic(fmt.Sprintf("Unknown queue column %s for type: %v", column, q.t.Type))
+	}
+	field := v.FieldByIndex(c.Field)
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return nil
+		}
+		field = field.Elem()
+	}
+	return field.Interface()
+}
+
+// applied executes a Cassandra lightweight transaction and reports whether it was
+// applied. t is nil for statements that aren't generated from a registered table.
+func (m *Mapping) applied(ctx context.Context, t *tableMap, s *Statement) (applied bool, err error) {
+	var timeout time.Duration
+	if t != nil {
+		timeout = t.WriteTimeout
+	}
+	err = m.run(ctx, s, timeout, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if !rows.Next() {
+			return rows.Err()
+		}
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		values[0] = &applied // [applied] is always the first column
+		return rows.Scan(values...)
+	})
+	return applied, err
+}
@@ -0,0 +1,138 @@
+package m
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A Limiter is called before every statement is executed and may delay it or refuse it
+// by returning an error, for example to keep batch jobs from starving interactive
+// traffic on a shared database.
+type Limiter interface {
+	Wait(ctx context.Context, s *Statement) error
+}
+
+// SetLimiter sets the Limiter used for statements executed by m.
+func (m *Mapping) SetLimiter(l Limiter) {
+	m.limiter = l
+}
+
+func (m *Mapping) wait(ctx context.Context, s *Statement) error {
+	if m.limiter == nil {
+		return nil
+	}
+	return m.limiter.Wait(ctx, s)
+}
+
+// TokenBucket allows rate events per second on average with bursts of up to burst
+// events.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket. It panics if rate isn't positive.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if !(rate > 0) {
+		panic("TokenBucket rate must be positive")
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take waits until an event is allowed or ctx is done.
+func (b *TokenBucket) Take(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	// take the token now, even if it is only available in the future, so that waiting
+	// callers are served in order
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund()
+		return ctx.Err()
+	}
+}
+
+// refund returns a token that was taken but not used.
+func (b *TokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+}
+
+// TableLimiter is a Limiter with token buckets per table and per table and operation.
+type TableLimiter struct {
+	mu      sync.RWMutex
+	buckets map[tableLimiterKey]*TokenBucket
+}
+
+type tableLimiterKey struct {
+	table string
+	op    Op
+	all   bool
+}
+
+// NewTableLimiter returns a TableLimiter without any limits.
+func NewTableLimiter() *TableLimiter {
+	return &TableLimiter{buckets: make(map[tableLimiterKey]*TokenBucket)}
+}
+
+// Limit limits every statement on table to rate per second with bursts of burst.
+func (l *TableLimiter) Limit(table string, rate float64, burst int) {
+	l.set(tableLimiterKey{table: table, all: true}, NewTokenBucket(rate, burst))
+}
+
+// LimitOp limits statements of type op on table to rate per second with bursts of burst.
+// The limit applies in addition to any limit set with Limit.
+func (l *TableLimiter) LimitOp(table string, op Op, rate float64, burst int) {
+	l.set(tableLimiterKey{table: table, op: op}, NewTokenBucket(rate, burst))
+}
+
+func (l *TableLimiter) set(k tableLimiterKey, b *TokenBucket) {
+	l.mu.Lock()
+	l.buckets[k] = b
+	l.mu.Unlock()
+}
+
+func (l *TableLimiter) Wait(ctx context.Context, s *Statement) error {
+	l.mu.RLock()
+	op := l.buckets[tableLimiterKey{table: s.Table, op: s.Op}]
+	all := l.buckets[tableLimiterKey{table: s.Table, all: true}]
+	l.mu.RUnlock()
+
+	if op != nil {
+		if err := op.Take(ctx); err != nil {
+			return err
+		}
+	}
+	if all != nil {
+		if err := all.Take(ctx); err != nil {
+			if op != nil {
+				op.refund()
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package m
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// SetPanicRecovery makes the methods of m that return an error recover from panics,
+// such as those of the reflection on a malformed struct or value, and return them as a
+// *PanicError instead, so that a bad request can't crash a server. Panics of the
+// functions passed to m, like the callbacks of SelectCursor, are recovered as well.
+func (m *Mapping) SetPanicRecovery(on bool) {
+	m.recovering = on
+}
+
+// PanicError is returned for a recovered panic, see SetPanicRecovery.
+type PanicError struct {
+	Value interface{}
+	Stack []byte // of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("m: panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// recover turns a panic into a *PanicError in *err if panic recovery is on. A
+// *TypeError, of a value that isn't the row of a registered table, is always returned as
+// is. It must be deferred.
+func (m *Mapping) recover(err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if te, ok := v.(*TypeError); ok {
+		*err = te
+		return
+	}
+	if !m.recovering {
+		panic(v)
+	}
+	*err = &PanicError{Value: v, Stack: debug.Stack()}
+}
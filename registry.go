@@ -0,0 +1,69 @@
+package m
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Registry holds table to struct mappings and the metadata derived from them. A Registry
+// can be shared by several Mappings, for example one for the primary database and one
+// for a replica, so that tables are only registered and analyzed once.
+//
+//	r := m.NewRegistry()
+//	r.AddTable("posts", Post{})
+//	primary := r.NewMapping(m.PostgreSQL)
+//	replica := r.NewMapping(m.PostgreSQL)
+type Registry struct {
+	tables     map[reflect.Type]*tableMap
+	subtypes   map[string]map[string]*tableMap // table name -> discriminator value -> subtype
+	queries    *queryCache
+	codec      string // default codec of serialized columns, see SetCodec
+	converters map[reflect.Type]*converter
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		tables:   make(map[reflect.Type]*tableMap),
+		subtypes: make(map[string]map[string]*tableMap),
+		queries:  newQueryCache(),
+	}
+}
+
+// NewMapping returns a Mapping of type t that uses the tables registered in r.
+func (r *Registry) NewMapping(t DBType) *Mapping {
+	return &Mapping{Type: t, registry: r}
+}
+
+// AddTable adds a table to struct mapping to a Registry. thing can be a struct or a
+// pointer to one, either way the table is used for both.
+//
+//	r.AddTable("posts", Post{})
+func (r *Registry) AddTable(name string, thing interface{}) {
+	typ := tableType(thing)
+	columns := getTableColumns(thing, typ)
+	setCodecs(columns, r.codec)
+	setConverters(typ, columns, r.converters)
+	parseDefaults(typ, columns)
+	r.tables[typ] = &tableMap{Name: intern(name), Type: typ, Columns: columns}
+}
+
+// AddSubtype adds one of several structs that share a table. The struct must have a
+// column tagged with discriminator, which is set to value on Insert and used by Select
+// to decide which of the registered types to instantiate for each row.
+//
+//	r.AddSubtype("posts", LinkPost{}, "link")
+//	r.AddSubtype("posts", PhotoPost{}, "photo")
+func (r *Registry) AddSubtype(name string, thing interface{}, value string) {
+	r.AddTable(name, thing)
+	t := r.tables[tableType(thing)]
+	if t.discriminatorColumn() == nil {
+		panic(fmt.Sprintf("No discriminator column for type: %v", t.Type))
+	}
+	t.Discriminator = value
+
+	if r.subtypes[name] == nil {
+		r.subtypes[name] = make(map[string]*tableMap)
+	}
+	r.subtypes[name][value] = t
+}
@@ -0,0 +1,204 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+type relationKind int
+
+const (
+	hasMany relationKind = iota
+	belongsTo
+)
+
+// relationMap is a field of a struct that holds related rows of another table.
+type relationMap struct {
+	Kind   relationKind
+	Field  []int
+	Elem   reflect.Type // struct type of the related rows
+	Column string       // the foreign key column
+}
+
+// AddHasMany declares that the slice field of thing holds the rows of another table
+// whose foreignKey column references the primary key of thing, so that they can be
+// loaded with Query.Preload.
+//
+//	type Post struct {
+//		ID       int64      `db:"id,pk"`
+//		Comments []*Comment
+//	}
+//	M.AddHasMany(Post{}, "Comments", "post_id")
+func (m *Mapping) AddHasMany(thing interface{}, field, foreignKey string) {
+	t := m.lookupTable(thing)
+	f := t.relationField(field)
+	elem := f.Type
+	if elem.Kind() != reflect.Slice {
+		panic(fmt.Sprintf("Relation field %s of type %v is not a slice", field, t.Type))
+	}
+	if elem = elem.Elem(); elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if len(t.primaryKey()) != 1 {
+		panic(fmt.Sprintf("Has many relation %s of type %v needs a single column primary key", field, t.Type))
+	}
+	t.addRelation(field, &relationMap{Kind: hasMany, Field: f.Index, Elem: elem, Column: foreignKey})
+}
+
+// AddBelongsTo declares that the struct or struct pointer field of thing holds the row of
+// another table whose primary key is referenced by the foreignKey column of thing, so
+// that it can be loaded with Query.Preload.
+//
+//	type Comment struct {
+//		PostID int64 `db:"post_id"`
+//		Post   *Post
+//	}
+//	M.AddBelongsTo(Comment{}, "Post", "post_id")
+func (m *Mapping) AddBelongsTo(thing interface{}, field, foreignKey string) {
+	t := m.lookupTable(thing)
+	f := t.relationField(field)
+	elem := f.Type
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Relation field %s of type %v is not a struct", field, t.Type))
+	}
+	t.checkColumns([]string{foreignKey})
+	t.addRelation(field, &relationMap{Kind: belongsTo, Field: f.Index, Elem: elem, Column: foreignKey})
+}
+
+func (t *tableMap) relationField(name string) reflect.StructField {
+	f, ok := t.Type.FieldByName(name)
+	if !ok {
+		panic(fmt.Sprintf("Unknown relation field %s for type: %v", name, t.Type))
+	}
+	return f
+}
+
+func (t *tableMap) addRelation(field string, r *relationMap) {
+	if t.Relations == nil {
+		t.Relations = make(map[string]*relationMap)
+	}
+	t.Relations[field] = r
+}
+
+// Preload makes the query load the related rows of the relation fields, declared with
+// AddHasMany or AddBelongsTo, of the returned rows. Each relation is loaded with one
+// more query for all returned rows, split like In if there are too many of them. Rows of
+// subtypes other than the type of the field are skipped.
+//
+//	posts, err := M.Query(Post{}, "*").Where("author_id", id).Preload("Comments").Do()
+func (q *Query) Preload(fields ...string) *Query {
+	for _, field := range fields {
+		if q.t.Relations[field] == nil {
+			q.fail(fmt.Errorf("m: unknown relation %s of %v", field, q.t.Type))
+			return q
+		}
+	}
+	q.preload = append(q.preload, fields...)
+	return q
+}
+
+// preloadRelations loads the relation fields of rows.
+func (m *Mapping) preloadRelations(ctx context.Context, t *tableMap, rows []interface{}, fields []string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	for _, field := range fields {
+		r := t.Relations[field]
+		target := m.registry.tables[r.Elem]
+		if target == nil {
+			return fmt.Errorf("m: relation %s of %v is to an unregistered type %v", field, t.Type, r.Elem)
+		}
+
+		// the rows are matched by the key on this side and the column on the other
+		var key *columnMap
+		var column string
+		if r.Kind == hasMany {
+			key, column = t.column(t.primaryKey()[0]), r.Column
+		} else {
+			keys := target.primaryKey()
+			if len(keys) != 1 {
+				return fmt.Errorf("m: relation %s of %v is to %v, which doesn't have a single column primary key", field, t.Type, r.Elem)
+			}
+			key, column = t.column(r.Column), keys[0]
+		}
+		otherColumn := target.column(column)
+		if otherColumn == nil {
+			return fmt.Errorf("m: relation %s of %v is to %v, which has no column %s", field, t.Type, r.Elem, column)
+		}
+
+		values := make([]interface{}, 0, len(rows))
+		seen := make(map[string]bool, len(rows))
+		for _, row := range rows {
+			v := reflect.Indirect(reflect.ValueOf(row)).FieldByIndex(key.Field)
+			if k, ok := relationKey(v); ok && !seen[k] {
+				seen[k] = true
+				values = append(values, reflect.Indirect(v).Interface())
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		// a Query splits long IN lists and skips soft-deleted rows
+		rq := &Query{columns: "*", t: target, m: m}
+		if c := target.discriminatorColumn(); c != nil && target.Discriminator != "" {
+			rq.Where(quoteIdent(c.Name, m.Type)+" =", target.Discriminator)
+		}
+		related, err := rq.In(quoteIdent(column, m.Type), values...).DoContext(ctx)
+		if err != nil {
+			return err
+		}
+		byKey := make(map[string][]reflect.Value)
+		for _, other := range related {
+			v := reflect.ValueOf(other)
+			if v.Elem().Type() != r.Elem {
+				// a row of another type sharing the table
+				continue
+			}
+			k, _ := relationKey(v.Elem().FieldByIndex(otherColumn.Field))
+			byKey[k] = append(byKey[k], v)
+		}
+
+		for _, row := range rows {
+			rv := reflect.Indirect(reflect.ValueOf(row))
+			k, ok := relationKey(rv.FieldByIndex(key.Field))
+			if !ok {
+				continue
+			}
+			dest := rv.FieldByIndex(r.Field)
+			matches := byKey[k]
+			if r.Kind == hasMany {
+				s := reflect.MakeSlice(dest.Type(), 0, len(matches))
+				for _, other := range matches {
+					if dest.Type().Elem().Kind() != reflect.Ptr {
+						other = other.Elem()
+					}
+					s = reflect.Append(s, other)
+				}
+				dest.Set(s)
+			} else if len(matches) > 0 {
+				if dest.Kind() == reflect.Ptr {
+					dest.Set(matches[0])
+				} else {
+					dest.Set(matches[0].Elem())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// relationKey returns a key for the value of a key column, and false if it is null.
+func relationKey(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface()), true
+}
@@ -0,0 +1,197 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaMonitor periodically measures the replication lag of the replicas set with
+// SetReplicas. Replicas that lag behind by more than MaxLag, or whose lag can't be
+// measured, stop serving reads until they catch up, and reads go to m.DB if every
+// replica lags.
+//
+// On PostgreSQL the lag is the age of the last replayed transaction. Otherwise, or if
+// Heartbeat is set, the monitor writes the time to the heartbeat table on m.DB and
+// reads it back from the replicas. The heartbeat table needs an id integer primary key
+// and a ts timestamp column.
+//
+//	rm := M.NewReplicaMonitor(5*time.Second, time.Second)
+//	rm.Start()
+//	defer rm.Stop()
+type ReplicaMonitor struct {
+	Interval  time.Duration // time between checks, DefaultReplicaCheckInterval if not positive
+	MaxLag    time.Duration
+	Heartbeat string
+
+	// Errors receives errors that happen while measuring in the background, if it is not
+	// nil. Errors are dropped if nobody is receiving.
+	Errors chan<- error
+
+	m    *Mapping
+	stop chan struct{}
+	done chan struct{}
+}
+
+// ReplicaStats are the metrics of one replica.
+type ReplicaStats struct {
+	Lag       time.Duration // as of the last check
+	Lagging   bool          // whether the replica is skipped
+	Checks    int
+	Failures  int
+	LastCheck time.Time
+	Skipped   int64 // reads sent elsewhere because the replica lagged
+}
+
+type replicaHealth struct {
+	lagging int32 // accessed atomically
+	skipped int64 // accessed atomically
+
+	mu    sync.Mutex
+	stats ReplicaStats
+}
+
+// DefaultReplicaCheckInterval is the time between the checks of a ReplicaMonitor whose
+// Interval is not positive.
+const DefaultReplicaCheckInterval = 5 * time.Second
+
+// NewReplicaMonitor returns a ReplicaMonitor that checks the replicas of m every
+// interval.
+func (m *Mapping) NewReplicaMonitor(maxLag, interval time.Duration) *ReplicaMonitor {
+	return &ReplicaMonitor{Interval: interval, MaxLag: maxLag, m: m}
+}
+
+// Start starts checking in the background.
+func (rm *ReplicaMonitor) Start() {
+	rm.stop = make(chan struct{})
+	rm.done = make(chan struct{})
+	interval := rm.Interval
+	if interval <= 0 {
+		interval = DefaultReplicaCheckInterval
+	}
+	go func() {
+		defer close(rm.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := rm.Check(ctx); err != nil && rm.Errors != nil {
+				select {
+				case rm.Errors <- err:
+				default:
+				}
+			}
+			cancel()
+			select {
+			case <-ticker.C:
+			case <-rm.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking in the background and waits for a running check to finish.
+func (rm *ReplicaMonitor) Stop() {
+	close(rm.stop)
+	<-rm.done
+}
+
+// Check measures the lag of every replica once and updates which replicas serve reads.
+// It returns the first error, but checks every replica.
+func (rm *ReplicaMonitor) Check(ctx context.Context) error {
+	rs := rm.m.replicas
+	if rs == nil || len(rs.dbs) == 0 {
+		return nil
+	}
+	heartbeat := rm.Heartbeat != "" || rm.m.Type != PostgreSQL
+	var now time.Time
+	var firstErr error
+	if heartbeat {
+		var err error
+		if now, err = rm.beat(ctx); err != nil {
+			// without a fresh heartbeat the lag of the replicas can't be told
+			firstErr = err
+		}
+	}
+
+	for i, db := range rs.dbs {
+		var lag time.Duration
+		err := firstErr
+		if err == nil && heartbeat {
+			var ts time.Time
+			query := fmt.Sprintf("SELECT ts FROM %s WHERE id = 1", quoteIdent(rm.heartbeatTable(), rm.m.Type))
+			if err = db.QueryRowContext(ctx, query).Scan(&ts); err == nil {
+				lag = now.Sub(ts)
+			}
+		} else if err == nil {
+			var seconds float64
+			err = db.QueryRowContext(ctx, "SELECT COALESCE(CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END, 0)").Scan(&seconds)
+			lag = time.Duration(seconds * float64(time.Second))
+		}
+		if lag < 0 {
+			lag = 0
+		}
+
+		h := rs.health[i]
+		lagging := err != nil || lag > rm.MaxLag
+		if lagging {
+			atomic.StoreInt32(&h.lagging, 1)
+		} else {
+			atomic.StoreInt32(&h.lagging, 0)
+		}
+		h.mu.Lock()
+		h.stats.Checks++
+		h.stats.LastCheck = time.Now()
+		h.stats.Lag = lag
+		if err != nil {
+			h.stats.Failures++
+		}
+		h.mu.Unlock()
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the metrics of each replica, in the order they were passed to
+// SetReplicas.
+func (rm *ReplicaMonitor) Stats() []ReplicaStats {
+	rs := rm.m.replicas
+	if rs == nil {
+		return nil
+	}
+	stats := make([]ReplicaStats, len(rs.health))
+	for i, h := range rs.health {
+		h.mu.Lock()
+		stats[i] = h.stats
+		h.mu.Unlock()
+		stats[i].Lagging = atomic.LoadInt32(&h.lagging) != 0
+		stats[i].Skipped = atomic.LoadInt64(&h.skipped)
+	}
+	return stats
+}
+
+func (rm *ReplicaMonitor) heartbeatTable() string {
+	if rm.Heartbeat == "" {
+		return "heartbeat"
+	}
+	return rm.Heartbeat
+}
+
+// beat writes the current time to the heartbeat table on the primary and returns it.
+func (rm *ReplicaMonitor) beat(ctx context.Context) (time.Time, error) {
+	// stored at the precision of the column, so that a replica that is up to date
+	// reads back the same time
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	query, err := sqlUpsertString(rm.heartbeatTable(), []string{"id", "ts"}, []string{"id"}, rm.m.Type)
+	if err != nil {
+		return now, err
+	}
+	_, err = rm.m.DB.ExecContext(ctx, query, 1, now)
+	return now, err
+}
@@ -0,0 +1,115 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetReplicas splits reads from writes: SELECT statements are sent to replicas in turn,
+// while writes and every statement of a transaction are sent to m.DB. Replicas can lag
+// behind m.DB, see SetReadYourWrites to read recent writes back.
+func (m *Mapping) SetReplicas(replicas ...*sql.DB) {
+	rs := m.replicaSet()
+	if m.stmts != nil {
+		// close the statements cached for the previous replicas
+		m.stmts.purge(m.DB)
+	}
+	rs.dbs = replicas
+	rs.health = make([]*replicaHealth, len(replicas))
+	for i := range rs.health {
+		rs.health[i] = &replicaHealth{}
+	}
+}
+
+// SetReadYourWrites sends the reads of a Session from a table to m.DB for window after
+// the session wrote to the table, so that its writes are visible to it even if the
+// replicas lag behind. window should be longer than the usual replication lag.
+func (m *Mapping) SetReadYourWrites(window time.Duration) {
+	m.replicaSet().window = window
+}
+
+type replicaSet struct {
+	dbs    []*sql.DB
+	health []*replicaHealth // set by a ReplicaMonitor
+	next   uint32
+	window time.Duration
+}
+
+func (m *Mapping) replicaSet() *replicaSet {
+	if m.replicas == nil {
+		m.replicas = &replicaSet{}
+	}
+	return m.replicas
+}
+
+// Session tracks the writes of a user or request for SetReadYourWrites. A Session is
+// attached to a context with WithSession, and can be kept across requests, for example
+// per user, to stick to the primary after redirecting from a form.
+type Session struct {
+	mu     sync.Mutex
+	writes map[string]time.Time // table -> time of the last write
+}
+
+// NewSession returns a Session without writes.
+func NewSession() *Session {
+	return &Session{writes: make(map[string]time.Time)}
+}
+
+type sessionKey struct{}
+
+type primaryDBKey struct{}
+
+// WithPrimary returns a copy of ctx whose reads are sent to m.DB rather than to the
+// replicas, for reads that have to see every write.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryDBKey{}, true)
+}
+
+// WithSession returns a copy of ctx that carries s.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// wrote records the write s in the session of ctx, if there is one. Statements that
+// aren't for a registered table are recorded for every table.
+func (m *Mapping) wrote(ctx context.Context, s *Statement) {
+	if m.replicas == nil || m.replicas.window <= 0 {
+		return
+	}
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	session.writes[s.Table] = time.Now()
+	session.mu.Unlock()
+}
+
+// route returns the database s should be executed on.
+func (m *Mapping) route(ctx context.Context, s *Statement) *sql.DB {
+	if m.replicas == nil || len(m.replicas.dbs) == 0 || s.Op != OpSelect || ctx.Value(primaryDBKey{}) != nil {
+		return m.DB
+	}
+	if session, _ := ctx.Value(sessionKey{}).(*Session); session != nil {
+		since := time.Now().Add(-m.replicas.window)
+		session.mu.Lock()
+		recent := session.writes[s.Table].After(since) || session.writes[""].After(since)
+		session.mu.Unlock()
+		if recent {
+			return m.DB
+		}
+	}
+	rs := m.replicas
+	n := int(atomic.AddUint32(&rs.next, 1))
+	for i := range rs.dbs {
+		x := (n + i) % len(rs.dbs)
+		if atomic.LoadInt32(&rs.health[x].lagging) == 0 {
+			return rs.dbs[x]
+		}
+		atomic.AddInt64(&rs.health[x].skipped, 1)
+	}
+	return m.DB
+}
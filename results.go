@@ -0,0 +1,152 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// scanBuffer holds the per-row scratch space used while scanning rows into structs.
+// It is reused for every row of a query and pooled between queries.
+type scanBuffer struct {
+	values      []interface{}
+	data        [][]byte
+	deserialize []deserializeTarget
+	nullZero    []nullZeroTarget
+	converted   []convertTarget
+	discard     interface{}
+	extra       map[string]interface{} // destinations of columns without a field, see Page
+}
+
+type deserializeTarget struct {
+	index  int
+	target interface{}
+	codec  Codec
+}
+
+var scanBufferPool = sync.Pool{New: func() interface{} { return new(scanBuffer) }}
+
+func getScanBuffer() *scanBuffer {
+	return scanBufferPool.Get().(*scanBuffer)
+}
+
+func putScanBuffer(b *scanBuffer) {
+	b.reset(0)
+	b.discard = nil
+	b.extra = nil
+	scanBufferPool.Put(b)
+}
+
+// reset prepares b for a row with n columns and returns the slice of scan destinations.
+func (b *scanBuffer) reset(n int) []interface{} {
+	for i := range b.values {
+		b.values[i] = nil
+	}
+	for i := range b.data {
+		b.data[i] = nil
+	}
+	for i := range b.deserialize {
+		b.deserialize[i] = deserializeTarget{}
+	}
+	b.deserialize = b.deserialize[:0]
+	for i := range b.nullZero {
+		b.nullZero[i] = nullZeroTarget{}
+	}
+	b.nullZero = b.nullZero[:0]
+	for i := range b.converted {
+		b.converted[i] = convertTarget{}
+	}
+	b.converted = b.converted[:0]
+
+	if cap(b.values) < n {
+		b.values = make([]interface{}, n)
+		b.data = make([][]byte, n)
+	}
+	b.values = b.values[:n]
+	b.data = b.data[:n]
+	return b.values
+}
+
+// Results is a set of rows returned by SelectResults. Calling Release when done with
+// the rows returns the backing slice to a pool, so services selecting many rows at a
+// high rate allocate less.
+type Results struct {
+	// Rows contains the returned rows scanned into structs, it must not be used after
+	// Release is called.
+	Rows []interface{}
+}
+
+var resultsPool = sync.Pool{New: func() interface{} { return &Results{Rows: make([]interface{}, 0, 16)} }}
+
+// SelectResults is like Select but returns the rows in a pooled Results.
+func (m *Mapping) SelectResults(thing interface{}, query string, bindings ...interface{}) (*Results, error) {
+	return m.SelectResultsContext(context.Background(), thing, query, bindings...)
+}
+
+// SelectResultsContext is like SelectResults but runs the query with ctx.
+func (m *Mapping) SelectResultsContext(ctx context.Context, thing interface{}, query string, bindings ...interface{}) (_ *Results, err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	res := resultsPool.Get().(*Results)
+	err = m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: bindings}, func(instance interface{}) error {
+		res.Rows = append(res.Rows, instance)
+		return nil
+	})
+	if err != nil {
+		res.Release()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Release returns r to the pool. r and its Rows must not be used afterwards.
+func (r *Results) Release() {
+	for i := range r.Rows {
+		r.Rows[i] = nil
+	}
+	r.Rows = r.Rows[:0]
+	resultsPool.Put(r)
+}
+
+// SelectInto is like Select but appends the returned rows to the slice pointed to by
+// dest, which can be a slice of structs or of struct pointers, so that callers don't
+// need type assertions.
+//
+//	var posts []*Post
+//	err := M.SelectInto(&posts, "SELECT * FROM posts WHERE author_id = $1", id)
+func (m *Mapping) SelectInto(dest interface{}, query string, bindings ...interface{}) error {
+	return m.SelectIntoContext(context.Background(), dest, query, bindings...)
+}
+
+// SelectIntoContext is like SelectInto but runs the query with ctx.
+func (m *Mapping) SelectIntoContext(ctx context.Context, dest interface{}, query string, bindings ...interface{}) (err error) {
+	defer m.recover(&err)
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("m: SelectInto expects a pointer to a slice, got %T", dest)
+	}
+	slice := v.Elem()
+	elem := slice.Type().Elem()
+	pointers := elem.Kind() == reflect.Ptr
+	typ := elem
+	if pointers {
+		typ = elem.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("m: SelectInto expects a slice of structs or struct pointers, got %T", dest)
+	}
+
+	t := m.lookupTable(reflect.Zero(typ).Interface())
+	return m.query(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: bindings}, func(instance interface{}) error {
+		row := reflect.ValueOf(instance)
+		if row.Elem().Type() != typ {
+			return fmt.Errorf("m: can't put a %v row into %T", row.Elem().Type(), dest)
+		}
+		if !pointers {
+			row = row.Elem()
+		}
+		slice.Set(reflect.Append(slice, row))
+		return nil
+	})
+}
@@ -0,0 +1,52 @@
+package m
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A RetryPolicy decides whether a statement that failed with err on its attempt'th try
+// is tried again. Retry may wait to back off before returning true, but should return
+// false once ctx, the context of the statement, is done.
+type RetryPolicy interface {
+	Retry(ctx context.Context, s *Statement, attempt int, err error) bool
+}
+
+// SetRetryPolicy sets the RetryPolicy for statements executed by m.
+func (m *Mapping) SetRetryPolicy(p RetryPolicy) {
+	m.retry = p
+}
+
+// IdempotentRetry is a RetryPolicy that retries idempotent statements that timed out,
+// like Cassandra reads and writes hitting a ReadTimeout or WriteTimeout, and never
+// retries statements that aren't idempotent, like counter updates.
+type IdempotentRetry struct {
+	MaxAttempts int
+	Backoff     time.Duration // doubled after every attempt
+
+	// Retryable reports whether err is worth retrying. If nil, errors that mention a
+	// timeout are retried.
+	Retryable func(error) bool
+}
+
+func (p *IdempotentRetry) Retry(ctx context.Context, s *Statement, attempt int, err error) bool {
+	if !s.Idempotent || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		if !p.Retryable(err) {
+			return false
+		}
+	} else if !strings.Contains(strings.ToLower(err.Error()), "timeout") {
+		return false
+	}
+	timer := time.NewTimer(p.Backoff << uint(attempt-1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
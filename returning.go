@@ -0,0 +1,52 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+)
+
+// UpdateReturning is like Update but then refreshes every field of thing from the
+// updated row, so that the values set by triggers and defaults are reflected in it. The
+// row is read with RETURNING * on PostgreSQL and SQLite, and with a SELECT of its
+// primary key after the update otherwise. If no row was updated sql.ErrNoRows is
+// returned.
+func (m *Mapping) UpdateReturning(thing interface{}, data map[string]interface{}) error {
+	return m.UpdateReturningContext(context.Background(), thing, data)
+}
+
+// UpdateReturningContext is like UpdateReturning but runs the statements with ctx.
+func (m *Mapping) UpdateReturningContext(ctx context.Context, thing interface{}, data map[string]interface{}) (err error) {
+	defer m.recover(&err)
+	return m.update(ctx, m.lookupTable(thing), thing, data, true)
+}
+
+// refresh sets the fields of thing from the row of t with the primary key values.
+func (m *Mapping) refresh(ctx context.Context, t *tableMap, thing interface{}, keys []string, values []interface{}) error {
+	b := getBuffer()
+	b.WriteString("SELECT * FROM ")
+	writeIdent(b, t.Name, m.Type)
+	b.WriteString(" WHERE ")
+	b.WriteString(keyCondition(keys, m.Type))
+	// a replica may not have the update yet
+	ctx = WithPrimary(ctx)
+	return m.queryInto(ctx, t, &Statement{Op: OpSelect, Table: t.Name, SQL: putBuffer(b), Args: values}, thing)
+}
+
+// queryInto runs s and copies the row it returns into thing, returning sql.ErrNoRows if
+// there is none.
+func (m *Mapping) queryInto(ctx context.Context, t *tableMap, s *Statement, thing interface{}) error {
+	found := false
+	err := m.query(ctx, t, s, func(instance interface{}) error {
+		found = true
+		dest := reflect.Indirect(reflect.ValueOf(thing))
+		if row := reflect.ValueOf(instance).Elem(); row.Type() == dest.Type() {
+			dest.Set(row)
+		}
+		return nil
+	})
+	if err == nil && !found {
+		err = sql.ErrNoRows
+	}
+	return err
+}
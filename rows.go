@@ -0,0 +1,157 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Rows is a result streamed from the database a row at a time, so that the memory used
+// doesn't grow with the number of rows, see SelectRows. It must be closed, which Next
+// does after the last row.
+//
+//	rows, err := M.SelectRows(Post{}, "SELECT * FROM posts")
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		var p Post
+//		if err := rows.Scan(&p); err != nil {
+//			return err
+//		}
+//	}
+//	return rows.Err()
+type Rows struct {
+	rows    *sql.Rows
+	sc      *rowScanner
+	current interface{}
+	err     error
+
+	closed chan struct{} // closed by Close to end the statement
+	result chan error    // the error of the statement once it ended
+}
+
+// SelectRows is like Select but returns the rows as they are read from the database
+// rather than all at once. The connection of the query is held until the rows are
+// closed, and the read timeout of the table applies to the whole scan.
+func (m *Mapping) SelectRows(thing interface{}, query string, bindings ...interface{}) (*Rows, error) {
+	return m.SelectRowsContext(context.Background(), thing, query, bindings...)
+}
+
+// SelectRowsContext is like SelectRows but runs the query with ctx, cancelling ctx
+// stops the scan.
+func (m *Mapping) SelectRowsContext(ctx context.Context, thing interface{}, query string, bindings ...interface{}) (_ *Rows, err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	s := &Statement{Op: OpSelect, Table: t.Name, SQL: query, Args: bindings, Idempotent: true}
+	if s.SQL, err = t.guardQuery(s.SQL, m.Type); err != nil {
+		return nil, err
+	}
+
+	// the statement runs in its own goroutine, which hands the rows over and holds the
+	// connection, timeout and budget of the statement until the rows are closed
+	r := &Rows{closed: make(chan struct{}), result: make(chan error, 1)}
+	opened := make(chan *sql.Rows)
+	go func() {
+		r.result <- m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+			rows, done, err := m.queryRows(ctx, s, q)
+			if err != nil {
+				done()
+				return err
+			}
+			defer done()
+			// once rows have been handed out, retrying would hand them out again
+			s.Idempotent = false
+			opened <- rows
+			<-r.closed
+			return rows.Err()
+		})
+	}()
+
+	select {
+	case r.rows = <-opened:
+	case err = <-r.result:
+		return nil, err
+	}
+	if err = setResultColumns(ctx, r.rows); err == nil {
+		r.sc, err = m.newRowScanner(t, r.rows)
+	}
+	if err != nil {
+		r.rows.Close()
+		close(r.closed)
+		<-r.result
+		return nil, err
+	}
+	return r, nil
+}
+
+// Next scans the next row, returning false if there are no more rows or if scanning
+// failed, see Err.
+func (r *Rows) Next() bool {
+	if r.sc == nil {
+		return false
+	}
+	r.current = nil
+	if !r.rows.Next() {
+		r.Close()
+		return false
+	}
+	if r.current, r.err = r.sc.scan(); r.err != nil {
+		r.Close()
+		return false
+	}
+	return true
+}
+
+// Row returns the current row, a pointer to a struct with the type of the thing passed
+// to SelectRows or of one of its subtypes.
+func (r *Rows) Row() interface{} {
+	return r.current
+}
+
+// Scan copies the current row to dest, which is a pointer to a struct or to a struct
+// pointer of the type of the row.
+func (r *Rows) Scan(dest interface{}) error {
+	if r.current == nil {
+		return fmt.Errorf("m: Scan called without a row, see Next")
+	}
+	row := reflect.ValueOf(r.current)
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("m: Scan expects a pointer, got %T", dest)
+	}
+	switch v.Elem().Type() {
+	case row.Type():
+		v.Elem().Set(row)
+	case row.Elem().Type():
+		v.Elem().Set(row.Elem())
+	default:
+		return fmt.Errorf("m: can't Scan a row of type %v into %T", row.Elem().Type(), dest)
+	}
+	return nil
+}
+
+// Err returns the error that stopped Next, if any.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close closes the rows and releases the connection. It can be called more than once.
+func (r *Rows) Close() error {
+	if r.sc == nil {
+		return r.err
+	}
+	err := r.rows.Close()
+	r.sc.close()
+	r.sc = nil
+	close(r.closed)
+	if runErr := <-r.result; err == nil {
+		err = runErr
+	}
+	if r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
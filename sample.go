@@ -0,0 +1,54 @@
+package m
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Sample selects about n random rows of the query instead of all of them. On PostgreSQL
+// a query without conditions or joins reads random blocks of the table with TABLESAMPLE
+// SYSTEM_ROWS, which needs the tsm_system_rows extension, so that large tables aren't
+// scanned. Otherwise the matching rows are ordered by random() and the first n are
+// returned, which reads every matching row, so the query should be selective.
+//
+// Sample can't be combined with Order or Offset, and is not supported on Cassandra.
+//
+//	posts, err := M.Query(Post{}, "*").Sample(100).Do()
+func (q *Query) Sample(n int) *Query {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: Sample is not supported on Cassandra"))
+		return q
+	}
+	if n < 1 {
+		q.fail(fmt.Errorf("m: Sample needs at least one row, got %d", n))
+		return q
+	}
+	q.sample = n
+	return q
+}
+
+// tableSample reports whether q samples its table with TABLESAMPLE rather than by
+// ordering the rows randomly.
+func (q *Query) tableSample() bool {
+	return q.sample > 0 && q.m.Type == PostgreSQL && q.from == "" && len(q.joins) == 0 &&
+		len(q.conditions) == 0 && q.groupBy == "" && !q.excludesDeleted()
+}
+
+// writeSampleOrder writes the ORDER BY and LIMIT clauses of a sampled query, which
+// prepared checks has no order or offset of its own.
+func (q *Query) writeSampleOrder(b *bytes.Buffer) {
+	if !q.tableSample() {
+		if q.m.Type == MySQL {
+			b.WriteString(" ORDER BY RAND()")
+		} else {
+			b.WriteString(" ORDER BY random()")
+		}
+	}
+	n := q.sample
+	if q.limit > 0 && q.limit < n {
+		n = q.limit
+	}
+	b.WriteString(" LIMIT ")
+	b.WriteString(strconv.Itoa(n))
+}
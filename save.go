@@ -0,0 +1,42 @@
+package m
+
+import (
+	"context"
+	"reflect"
+)
+
+// Save updates the row for thing, a pointer to a struct, with the values of all its
+// fields except the primary key, which selects the row. Counter, autocreate and
+// softdelete columns are left as they are, as they aren't set by changing the struct,
+// and so are readonly and createonly columns.
+//
+//	post.Title = title
+//	post.Tags = append(post.Tags, tag)
+//	err := M.Save(post)
+func (m *Mapping) Save(thing interface{}) error {
+	return m.SaveContext(context.Background(), thing)
+}
+
+// SaveContext is like Save but runs the statement with ctx.
+func (m *Mapping) SaveContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	return m.update(ctx, t, thing, t.saved(thing), false)
+}
+
+// saved returns the values of the columns of thing written by Save.
+func (t *tableMap) saved(thing interface{}) map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	data := make(map[string]interface{}, len(t.Columns))
+	for _, c := range t.Columns {
+		if c.PrimaryKey || c.Discriminator || c.Counter || c.AutoCreate || c.SoftDelete || c.ReadOnly || c.CreateOnly {
+			continue
+		}
+		if c.AutoUpdate {
+			// set to the time of the update, see withUpdated
+			continue
+		}
+		data[c.Name] = v.FieldByIndex(c.Field).Interface()
+	}
+	return data
+}
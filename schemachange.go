@@ -0,0 +1,278 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SchemaChange alters a large MySQL or PostgreSQL table without locking it for the
+// duration of the ALTER. The change is applied to an empty copy of the table, triggers
+// copy every write to the table into the copy while the existing rows are copied in
+// batches of primary key ranges, and finally the two tables are swapped by renaming
+// them. The original table is kept as _<table>_old and can be dropped once the change
+// has been checked.
+//
+//	sc := M.NewSchemaChange(Event{}, "ADD COLUMN region text")
+//	sc.Rate = 20
+//	err := sc.Run()
+//
+// The writes are copied by triggers rather than by the Mapping so that writes of other
+// clients are copied as well. Columns that exist in both tables are copied. On
+// PostgreSQL the copy shares the sequences of serial columns with the original table,
+// so they have to be reassigned with ALTER SEQUENCE ... OWNED BY before it is dropped.
+type SchemaChange struct {
+	Alter string // the ALTER TABLE clauses, without ALTER TABLE <table>
+
+	// BatchSize, Rate, Lag, MaxLag, Progress and Resume control the copy of the existing
+	// rows like they do for a Backfill. A change resumed from a checkpoint continues the
+	// copy into the copy created by the interrupted run.
+	BatchSize int
+	Rate      float64
+	Lag       func(context.Context) (time.Duration, error)
+	MaxLag    time.Duration
+	Progress  func(Checkpoint) error
+	Resume    *Checkpoint
+
+	m     *Mapping
+	thing interface{}
+}
+
+// NewSchemaChange returns a SchemaChange that applies alter to the table for thing.
+func (m *Mapping) NewSchemaChange(thing interface{}, alter string) *SchemaChange {
+	m.lookupTable(thing)
+	return &SchemaChange{Alter: alter, m: m, thing: thing}
+}
+
+// Run applies the change.
+func (sc *SchemaChange) Run() error {
+	return sc.RunContext(context.Background())
+}
+
+// RunContext is like Run but runs the statements with ctx.
+func (sc *SchemaChange) RunContext(ctx context.Context) error {
+	ctx = WithPrimary(ctx)
+	m := sc.m
+	t := m.lookupTable(sc.thing)
+	if m.Type != PostgreSQL && m.Type != MySQL {
+		return fmt.Errorf("m: online schema changes are only supported on PostgreSQL and MySQL")
+	}
+	ghost := "_" + t.Name + "_new"
+
+	if sc.Resume == nil {
+		like := "CREATE TABLE %s LIKE %s"
+		if m.Type == PostgreSQL {
+			like = "CREATE TABLE %s (LIKE %s INCLUDING ALL)"
+		}
+		if err := sc.m.execDDL(ctx, fmt.Sprintf(like, ghost, t.Name)); err != nil {
+			return err
+		}
+		if err := sc.m.execDDL(ctx, fmt.Sprintf("ALTER TABLE %s %s", ghost, sc.Alter)); err != nil {
+			return err
+		}
+	}
+	columns, err := sc.sharedColumns(ctx, t.Name, ghost)
+	if err != nil {
+		return err
+	}
+	if sc.Resume == nil {
+		for _, trigger := range sc.triggers(t, ghost, columns) {
+			if err = sc.m.execDDL(ctx, trigger); err != nil {
+				return err
+			}
+		}
+	}
+
+	b := &Backfill{
+		BatchSize: sc.BatchSize,
+		Rate:      sc.Rate,
+		Lag:       sc.Lag,
+		MaxLag:    sc.MaxLag,
+		Progress:  sc.Progress,
+		Resume:    sc.Resume,
+		m:         m,
+		step: func(ctx context.Context, t *tableMap, keys []string, from, to []interface{}) error {
+			return sc.copyRows(ctx, t, ghost, columns, keys, from, to)
+		},
+	}
+	if err = b.RunContext(ctx, sc.thing); err != nil {
+		return err
+	}
+	return sc.swap(ctx, t, ghost)
+}
+
+// Abort drops the copy and the triggers of a change that failed or was interrupted.
+func (sc *SchemaChange) Abort() error {
+	ctx := context.Background()
+	t := sc.m.lookupTable(sc.thing)
+	for _, drop := range sc.dropTriggers(t.Name, t.Name) {
+		if err := sc.m.execDDL(ctx, drop); err != nil {
+			return err
+		}
+	}
+	return sc.m.execDDL(ctx, fmt.Sprintf("DROP TABLE IF EXISTS _%s_new", t.Name))
+}
+
+// sharedColumns returns the columns of table that are also in ghost.
+func (sc *SchemaChange) sharedColumns(ctx context.Context, table, ghost string) ([]string, error) {
+	schema := "current_schema()"
+	if sc.m.Type == MySQL {
+		schema = "DATABASE()"
+	}
+	b := getBuffer()
+	fmt.Fprintf(b, "SELECT column_name FROM information_schema.columns WHERE table_schema = %s AND table_name = ", schema)
+	writePlaceholder(b, 0, sc.m.Type)
+	b.WriteString(" ORDER BY ordinal_position")
+	s := &Statement{Op: OpSelect, SQL: putBuffer(b), Idempotent: true}
+
+	names := make([]map[string]bool, 2)
+	var columns []string
+	for i, name := range []string{ghost, table} {
+		names[i] = make(map[string]bool)
+		s.Args = []interface{}{name}
+		err := sc.m.run(ctx, s, 0, func(ctx context.Context, q querier) error {
+			rows, err := q.QueryContext(ctx, s.SQL, s.Args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var column string
+				if err = rows.Scan(&column); err != nil {
+					return err
+				}
+				names[i][column] = true
+				if i == 1 && names[0][column] {
+					columns = append(columns, column)
+				}
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(columns) == 0 {
+		return nil, ErrNoColumns
+	}
+	return columns, nil
+}
+
+// triggers returns the statements creating the triggers that copy writes to the table
+// of t into ghost.
+func (sc *SchemaChange) triggers(t *tableMap, ghost string, columns []string) []string {
+	list := strings.Join(columns, ", ")
+	values := "NEW." + strings.Join(columns, ", NEW.")
+	keys := t.primaryKey()
+	match := make([]string, len(keys))
+	for i, k := range keys {
+		match[i] = k + " = OLD." + k
+	}
+	del := fmt.Sprintf("DELETE FROM %s WHERE %s", ghost, strings.Join(match, " AND "))
+
+	if sc.m.Type == MySQL {
+		ins := fmt.Sprintf("REPLACE INTO %s (%s) VALUES (%s)", ghost, list, values)
+		return []string{
+			fmt.Sprintf("CREATE TRIGGER m_%s_ins AFTER INSERT ON %s FOR EACH ROW %s", t.Name, t.Name, ins),
+			fmt.Sprintf("CREATE TRIGGER m_%s_upd AFTER UPDATE ON %s FOR EACH ROW BEGIN %s; %s; END", t.Name, t.Name, del, ins),
+			fmt.Sprintf("CREATE TRIGGER m_%s_del AFTER DELETE ON %s FOR EACH ROW %s", t.Name, t.Name, del),
+		}
+	}
+
+	ins := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING", ghost, list, values)
+	return []string{
+		fmt.Sprintf(`CREATE FUNCTION m_%s_sync() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		%s;
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		%s;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql`, t.Name, del, ins),
+		fmt.Sprintf("CREATE TRIGGER m_%s_sync AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE PROCEDURE m_%s_sync()", t.Name, t.Name, t.Name),
+	}
+}
+
+// dropTriggers returns the statements dropping the triggers of table, which is now
+// named current.
+func (sc *SchemaChange) dropTriggers(table, current string) []string {
+	if sc.m.Type == MySQL {
+		return []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS m_%s_ins", table),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS m_%s_upd", table),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS m_%s_del", table),
+		}
+	}
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS m_%s_sync ON %s", table, current),
+		fmt.Sprintf("DROP FUNCTION IF EXISTS m_%s_sync()", table),
+	}
+}
+
+// copyRows copies the rows of t with keys after from, if it isn't nil, up to and
+// including to into ghost. Rows that were already copied by the triggers are newer and
+// are kept. The copied rows are locked so that a row can't be deleted, and its delete
+// copied, between being read and written by the copy.
+func (sc *SchemaChange) copyRows(ctx context.Context, t *tableMap, ghost string, columns, keys []string, from, to []interface{}) error {
+	dbt := sc.m.Type
+	list := strings.Join(columns, ", ")
+	q := getBuffer()
+	if dbt == MySQL {
+		// InnoDB locks the rows read by INSERT ... SELECT
+		q.WriteString("INSERT IGNORE")
+	} else {
+		q.WriteString("INSERT")
+	}
+	fmt.Fprintf(q, " INTO %s (%s) SELECT %s FROM %s WHERE (%s) <= (", ghost, list, list, t.Name, strings.Join(keys, ", "))
+	writePlaceholders(q, 0, len(keys), dbt)
+	q.WriteByte(')')
+	args := to
+	if from != nil {
+		fmt.Fprintf(q, " AND (%s) > (", strings.Join(keys, ", "))
+		writePlaceholders(q, len(keys), len(keys), dbt)
+		q.WriteByte(')')
+		args = append(append([]interface{}{}, to...), from...)
+	}
+	if dbt == PostgreSQL {
+		q.WriteString(" FOR SHARE ON CONFLICT DO NOTHING")
+	}
+	_, err := sc.m.exec(ctx, t, &Statement{Op: OpInsert, Table: ghost, SQL: putBuffer(q), Args: args, Idempotent: true})
+	return err
+}
+
+// swap renames the table of t to _<table>_old and ghost to the table, and drops the
+// triggers.
+func (sc *SchemaChange) swap(ctx context.Context, t *tableMap, ghost string) error {
+	old := "_" + t.Name + "_old"
+	if sc.m.Type == MySQL {
+		// the rename is atomic, the triggers stay on the old table
+		if err := sc.m.execDDL(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", t.Name, old, ghost, t.Name)); err != nil {
+			return err
+		}
+		for _, drop := range sc.dropTriggers(t.Name, old) {
+			if err := sc.m.execDDL(ctx, drop); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return sc.m.inTx(ctx, func(m *Mapping) error {
+		stmts := []string{fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", t.Name)}
+		stmts = append(stmts, sc.dropTriggers(t.Name, t.Name)...)
+		stmts = append(stmts,
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", t.Name, old),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", ghost, t.Name),
+		)
+		for _, query := range stmts {
+			if _, err := m.exec(ctx, nil, &Statement{Op: OpUpdate, SQL: query}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,222 @@
+package m
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Scrubber rewrites the values of sensitive columns across tables, to produce sanitized
+// copies of production data for staging and development. Columns are marked with a
+// sensitive tag naming the faker that replaces their values:
+//
+//	type User struct {
+//		ID    int64  `db:"id,pk"`
+//		Email string `db:"email" sensitive:"email"`
+//		Name  string `db:"name" sensitive:"name"`
+//		Notes string `db:"notes" sensitive:"null"`
+//	}
+//
+// The built in fakers are deterministic for a given Secret, so that a value that
+// appears in several tables is replaced by the same fake everywhere.
+type Scrubber struct {
+	BatchSize int    // rows updated per transaction
+	Secret    []byte // keys the hash the fake values are derived from
+
+	// Fakers replace values by the kind named in sensitive tags. The built in kinds
+	// are email, name, phone, hash, redact and null.
+	Fakers map[string]Faker
+
+	m *Mapping
+}
+
+// Faker returns the fake value for v, which must be nil or assignable to the field v
+// came from. h is a keyed hash of v that can be used to derive the fake
+// deterministically.
+type Faker func(v interface{}, h []byte) interface{}
+
+// NewScrubber returns a Scrubber with the built in fakers.
+func (m *Mapping) NewScrubber(secret []byte) *Scrubber {
+	return &Scrubber{
+		BatchSize: 1000,
+		Secret:    secret,
+		Fakers: map[string]Faker{
+			"email":  func(v interface{}, h []byte) interface{} { return "user-" + hex.EncodeToString(h[:6]) + "@example.com" },
+			"name":   func(v interface{}, h []byte) interface{} { return "Name " + strings.ToUpper(hex.EncodeToString(h[:4])) },
+			"phone":  fakePhone,
+			"hash":   func(v interface{}, h []byte) interface{} { return hex.EncodeToString(h) },
+			"redact": func(v interface{}, h []byte) interface{} { return "REDACTED" },
+			"null":   func(v interface{}, h []byte) interface{} { return nil },
+		},
+		m: m,
+	}
+}
+
+func fakePhone(v interface{}, h []byte) interface{} {
+	digits := make([]byte, 4)
+	for i := range digits {
+		digits[i] = '0' + h[i]%10
+	}
+	return "555-01" + string(digits[:2]) + "-" + string(digits[2:])
+}
+
+// Scrub rewrites the sensitive columns of the tables for things, or of every
+// registered table with sensitive columns if no things are given, and returns the
+// number of rows scrubbed per table. Tables are walked in primary key order, BatchSize
+// rows at a time.
+func (s *Scrubber) Scrub(things ...interface{}) (map[string]int64, error) {
+	return s.ScrubContext(context.Background(), things...)
+}
+
+// ScrubContext is like Scrub but runs the statements with ctx.
+func (s *Scrubber) ScrubContext(ctx context.Context, things ...interface{}) (map[string]int64, error) {
+	ctx = WithPrimary(ctx)
+	if s.m.Type == Cassandra {
+		return nil, fmt.Errorf("m: scrubbing is not supported on Cassandra")
+	}
+	var tables []*tableMap
+	if len(things) == 0 {
+		for _, t := range s.m.registry.tables {
+			if !t.View && len(sensitiveColumns(t)) > 0 {
+				tables = append(tables, t)
+			}
+		}
+	}
+	for _, thing := range things {
+		t, err := s.m.table(thing)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+
+	scrubbed := make(map[string]int64)
+	for _, t := range tables {
+		n, err := s.scrubTable(ctx, t)
+		scrubbed[t.Name] += n
+		if err != nil {
+			return scrubbed, err
+		}
+	}
+	return scrubbed, nil
+}
+
+func (s *Scrubber) scrubTable(ctx context.Context, t *tableMap) (int64, error) {
+	keys := t.primaryKey()
+	if len(keys) == 0 {
+		return 0, ErrNoPrimaryKey
+	}
+	for _, kind := range sensitiveColumns(t) {
+		if s.Fakers[kind] == nil {
+			return 0, fmt.Errorf("m: unknown sensitive kind %q in %v", kind, t.Type)
+		}
+	}
+	batch := s.BatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+
+	var total int64
+	var last []interface{}
+	for {
+		b := getBuffer()
+		fmt.Fprintf(b, "SELECT * FROM %s", quoteIdent(t.Name, s.m.Type))
+		if last != nil {
+			fmt.Fprintf(b, " WHERE (%s) > (", quoteIdents(keys, s.m.Type))
+			writePlaceholders(b, 0, len(keys), s.m.Type)
+			b.WriteByte(')')
+		}
+		fmt.Fprintf(b, " ORDER BY %s LIMIT %d", quoteIdents(keys, s.m.Type), batch)
+		rows, err := s.m.doSelect(ctx, t, putBuffer(b), last...)
+		if err != nil {
+			return total, err
+		}
+
+		err = s.m.inTx(ctx, func(m *Mapping) error {
+			for _, row := range rows {
+				if err := s.scrubRow(ctx, m, row); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(rows))
+		if len(rows) < batch {
+			s.m.cached(t, func(c *TableCache) { c.Purge() })
+			return total, nil
+		}
+		_, last = keysForUpdate(rows[len(rows)-1], t)
+	}
+}
+
+// scrubRow replaces the sensitive values of row and updates it. Its own table is used,
+// as the row may be a subtype of the scrubbed table.
+func (s *Scrubber) scrubRow(ctx context.Context, m *Mapping, row interface{}) error {
+	t, err := m.table(row)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(row).Elem()
+	var columns []string
+	var values []interface{}
+	for _, c := range t.Columns {
+		kind := t.Type.FieldByIndex(c.Field).Tag.Get("sensitive")
+		if kind == "" {
+			continue
+		}
+		field := v.FieldByIndex(c.Field)
+		if field.IsZero() { // including nil pointers
+			continue
+		}
+		fake := s.Fakers[kind](field.Interface(), s.hash(field))
+		if fake != nil && !assignable(fake, field.Type()) {
+			return fmt.Errorf("m: %s fake %v can't be assigned to column %s of %v", kind, fake, c.Name, t.Type)
+		}
+		assign(field, fake)
+		if c.Serialize {
+			var err error
+			if fake, err = columnValue(t, c, field); err != nil {
+				return err
+			}
+		}
+		columns = append(columns, c.Name)
+		values = append(values, fake)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	keyColumns, keyValues := keysForUpdate(row, t)
+	query, err := sqlUpdateString(t.Name, "", columns, keyColumns, m.Type)
+	if err != nil {
+		return err
+	}
+	_, err = m.exec(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: append(values, keyValues...), Idempotent: true})
+	return err
+}
+
+// hash returns the keyed hash of the value of field, or of the value it points to, so
+// that a *string hashes like a string. field must not be a nil pointer.
+func (s *Scrubber) hash(field reflect.Value) []byte {
+	h := hmac.New(sha256.New, s.Secret)
+	fmt.Fprint(h, reflect.Indirect(field).Interface())
+	return h.Sum(nil)
+}
+
+// sensitiveColumns returns the sensitive kinds of the columns of t.
+func sensitiveColumns(t *tableMap) []string {
+	var kinds []string
+	for _, c := range t.Columns {
+		if kind := t.Type.FieldByIndex(c.Field).Tag.Get("sensitive"); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
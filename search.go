@@ -0,0 +1,137 @@
+package m
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WhereILike adds a case-insensitive LIKE condition on column. It uses ILIKE on
+// PostgreSQL and LIKE on SQLite, which ignores the case of ASCII letters, and on MySQL
+// unless the column is tagged with a case-sensitive collation, in which case both sides
+// are lowercased. A backslash escapes the next character of pattern on every database,
+// see EscapeLike. Cassandra is not supported.
+//
+//	M.Query(User{}, "*").WhereILike("name", m.EscapeLike(name)+"%")
+func (q *Query) WhereILike(column, pattern string) *Query {
+	switch q.m.Type {
+	case PostgreSQL:
+		q.conditions = append(q.conditions, column+" ILIKE ?")
+	case Cassandra:
+		q.fail(fmt.Errorf("m: case-insensitive conditions are not supported on Cassandra"))
+		return q
+	default:
+		if q.m.Type == MySQL && !q.caseInsensitive(column) {
+			q.conditions = append(q.conditions, "LOWER("+column+") LIKE LOWER(?)")
+		} else {
+			q.conditions = append(q.conditions, column+" LIKE ?"+likeEscape(q.m.Type))
+		}
+	}
+	q.bindings = append(q.bindings, pattern)
+	return q
+}
+
+// WhereHasPrefix adds a condition that column starts with prefix, which is matched
+// literally. Cassandra is not supported.
+func (q *Query) WhereHasPrefix(column, prefix string) *Query {
+	return q.like(column, EscapeLike(prefix)+"%")
+}
+
+// WhereHasSuffix adds a condition that column ends with suffix, which is matched
+// literally. Cassandra is not supported.
+func (q *Query) WhereHasSuffix(column, suffix string) *Query {
+	return q.like(column, "%"+EscapeLike(suffix))
+}
+
+// WhereContains adds a condition that column contains substr, which is matched
+// literally. Cassandra is not supported.
+func (q *Query) WhereContains(column, substr string) *Query {
+	return q.like(column, "%"+EscapeLike(substr)+"%")
+}
+
+func (q *Query) like(column, pattern string) *Query {
+	if q.m.Type == Cassandra {
+		q.fail(fmt.Errorf("m: escaped LIKE patterns are not supported on Cassandra"))
+		return q
+	}
+	q.conditions = append(q.conditions, column+" LIKE ?"+likeEscape(q.m.Type))
+	q.bindings = append(q.bindings, pattern)
+	return q
+}
+
+// EscapeLike escapes the wildcards % and _ and the escape character \ in s with a
+// backslash, so that s matches itself when used in a pattern of WhereILike. User input
+// should always be escaped before it is used in a pattern.
+func EscapeLike(s string) string {
+	if !strings.ContainsAny(s, `%_\`) {
+		return s
+	}
+	b := getBuffer()
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return putBuffer(b)
+}
+
+// likeEscape returns the ESCAPE clause making backslash the escape character of LIKE
+// patterns, which it is by default on PostgreSQL and MySQL.
+func likeEscape(dbt DBType) string {
+	if dbt == SQLite {
+		return ` ESCAPE '\'`
+	}
+	return ""
+}
+
+// WhereEqualFold adds a condition that column equals value ignoring case, like
+// strings.EqualFold. Columns tagged with a case-insensitive collation, which are all
+// columns without a collation on MySQL, are compared with = so that their indexes can
+// be used, other columns are lowercased or compared with COLLATE NOCASE on SQLite.
+// Cassandra is not supported.
+func (q *Query) WhereEqualFold(column string, value string) *Query {
+	switch {
+	case q.m.Type == Cassandra:
+		q.fail(fmt.Errorf("m: case-insensitive conditions are not supported on Cassandra"))
+		return q
+	case q.caseInsensitive(column):
+		q.conditions = append(q.conditions, column+" = ?")
+	case q.m.Type == SQLite:
+		q.conditions = append(q.conditions, column+" = ? COLLATE NOCASE")
+	default:
+		q.conditions = append(q.conditions, "LOWER("+column+") = LOWER(?)")
+	}
+	q.bindings = append(q.bindings, value)
+	return q
+}
+
+// caseInsensitive reports whether column of the queried table compares
+// case-insensitively, see columnMap.caseInsensitive. Columns of joined tables are
+// looked up by their unqualified name.
+func (q *Query) caseInsensitive(column string) bool {
+	if i := strings.LastIndexByte(column, '.'); i >= 0 {
+		column = column[i+1:]
+	}
+	return q.t.column(column).caseInsensitive(q.m.Type)
+}
+
+// caseInsensitive reports whether c, which may be nil for columns that aren't mapped,
+// compares case-insensitively as far as the name of its collation tells: the default
+// and the _ci collations of MySQL, NOCASE on SQLite and nondeterministic ICU collations
+// of strength 1 or 2 on PostgreSQL, or collations named like MySQL's.
+func (c *columnMap) caseInsensitive(dbt DBType) bool {
+	var collate string
+	if c != nil {
+		collate = strings.ToLower(c.Collate)
+	}
+	switch dbt {
+	case MySQL:
+		return collate == "" || strings.HasSuffix(collate, "_ci")
+	case SQLite:
+		return collate == "nocase"
+	case PostgreSQL:
+		return strings.HasSuffix(collate, "_ci") || strings.Contains(collate, "-ks-level1") || strings.Contains(collate, "-ks-level2")
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package m
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// snapshotFormat identifies the archives written by Snapshot.
+const snapshotFormat = "m-snapshot"
+
+// snapshotRecord is one line of a snapshot archive: the header, the schema of a table
+// which is followed by its rows, or a row.
+type snapshotRecord struct {
+	Format  string                     `json:"format,omitempty"`
+	Version int                        `json:"version,omitempty"`
+	Table   string                     `json:"table,omitempty"`
+	Columns []snapshotColumn           `json:"columns,omitempty"`
+	Row     map[string]json.RawMessage `json:"row,omitempty"`
+}
+
+type snapshotColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PrimaryKey bool   `json:"pk,omitempty"`
+}
+
+// Snapshot writes the schema and rows of the tables for things to w, or of every
+// registered table except views if no things are given. The archive is newline
+// delimited JSON that doesn't depend on the database, so it can be loaded into another
+// database, even of another type, with Restore.
+func (m *Mapping) Snapshot(w io.Writer, things ...interface{}) error {
+	return m.SnapshotContext(context.Background(), w, things...)
+}
+
+// SnapshotContext is like Snapshot but runs the queries with ctx.
+func (m *Mapping) SnapshotContext(ctx context.Context, w io.Writer, things ...interface{}) error {
+	tables := make(map[string]*tableMap)
+	if len(things) == 0 {
+		for _, t := range m.registry.tables {
+			if !t.View {
+				tables[t.Name] = t
+			}
+		}
+	}
+	for _, thing := range things {
+		t, err := m.table(thing)
+		if err != nil {
+			return err
+		}
+		tables[t.Name] = t
+	}
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(snapshotRecord{Format: snapshotFormat, Version: 1}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := m.snapshotTable(ctx, enc, tables[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mapping) snapshotTable(ctx context.Context, enc *json.Encoder, t *tableMap) error {
+	if err := enc.Encode(snapshotRecord{Table: t.Name, Columns: m.snapshotColumns(t)}); err != nil {
+		return err
+	}
+
+	return m.scanTable(ctx, t, func(instance interface{}) error {
+		rowTable := m.lookupTable(instance)
+		v := reflect.ValueOf(instance).Elem()
+		row := make(map[string]json.RawMessage, len(rowTable.Columns))
+		for _, c := range rowTable.Columns {
+			data, err := json.Marshal(v.FieldByIndex(c.Field).Interface())
+			if err != nil {
+				return fmt.Errorf("m: can't snapshot column %s of %s: %v", c.Name, t.Name, err)
+			}
+			row[c.Name] = data
+		}
+		return enc.Encode(snapshotRecord{Row: row})
+	})
+}
+
+// scanTable calls fn with every row of t. Every row is read on purpose, so the guard
+// against unbounded queries doesn't apply.
+func (m *Mapping) scanTable(ctx context.Context, t *tableMap, fn func(interface{}) error) error {
+	s := &Statement{Op: OpSelect, Table: t.Name, SQL: "SELECT * FROM " + quoteIdent(t.Name, m.Type), Idempotent: true}
+	return m.run(ctx, s, t.ReadTimeout, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, s.SQL)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		return m.scanRows(t, rows, func(instance interface{}) error {
+			s.Idempotent = false
+			return fn(instance)
+		})
+	})
+}
+
+// Restore inserts the rows of an archive written by Snapshot into the tables mapped by
+// m, which must already exist. Rows are inserted as they are, without the defaults that
+// Insert fills in. Restoring into a transaction started with Begin makes it all or
+// nothing.
+func (m *Mapping) Restore(r io.Reader) error {
+	return m.RestoreContext(context.Background(), r)
+}
+
+// RestoreContext is like Restore but runs the statements with ctx.
+func (m *Mapping) RestoreContext(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var header snapshotRecord
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Format != snapshotFormat || header.Version != 1 {
+		return fmt.Errorf("m: not a snapshot archive")
+	}
+
+	var tables []*tableMap // the types rows of the current table can be restored as
+	for {
+		var rec snapshotRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if rec.Table != "" {
+			if tables, err = m.restoreTables(rec); err != nil {
+				return err
+			}
+			continue
+		}
+		if tables == nil {
+			return fmt.Errorf("m: snapshot row before table")
+		}
+		if err = m.restoreRow(ctx, tables, rec.Row); err != nil {
+			return err
+		}
+	}
+}
+
+// restoreTables returns the registered types of the table of rec, after checking that
+// they map every column of the archive.
+func (m *Mapping) restoreTables(rec snapshotRecord) ([]*tableMap, error) {
+	var tables []*tableMap
+	for _, t := range m.registry.tables {
+		if t.Name == rec.Table && !t.View {
+			tables = append(tables, t)
+		}
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("m: snapshot table %s is not mapped", rec.Table)
+	}
+	for _, c := range rec.Columns {
+		found := false
+		for _, t := range tables {
+			found = found || t.column(c.Name) != nil
+		}
+		if !found {
+			return nil, fmt.Errorf("m: snapshot column %s of table %s is not mapped", c.Name, rec.Table)
+		}
+	}
+	return tables, nil
+}
+
+func (m *Mapping) restoreRow(ctx context.Context, tables []*tableMap, row map[string]json.RawMessage) error {
+	t := tables[0]
+	if family := m.registry.subtypes[t.Name]; len(family) > 0 {
+		if c := t.discriminatorColumn(); c != nil {
+			var value string
+			json.Unmarshal(row[c.Name], &value)
+			if subtype, ok := family[value]; ok {
+				t = subtype
+			}
+		}
+	}
+
+	thing := reflect.New(t.Type)
+	for _, c := range t.Columns {
+		data, ok := row[c.Name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(data, thing.Elem().FieldByIndex(c.Field).Addr().Interface()); err != nil {
+			return fmt.Errorf("m: can't restore column %s of %s: %v", c.Name, t.Name, err)
+		}
+	}
+
+	columns, values, err := prepareInsertSqlColumnsValues(thing.Interface(), t)
+	if err != nil {
+		return err
+	}
+	query, err := sqlInsertString(t.Name, columns, m.Type)
+	if err != nil {
+		return err
+	}
+	_, err = m.exec(ctx, t, &Statement{Op: OpInsert, Table: t.Name, SQL: query, Args: values})
+	return err
+}
+
+// snapshotColumns returns the columns of t and of the other types sharing its table.
+func (m *Mapping) snapshotColumns(t *tableMap) []snapshotColumn {
+	var columns []snapshotColumn
+	seen := make(map[string]bool)
+	add := func(t *tableMap) {
+		for _, c := range t.Columns {
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				columns = append(columns, snapshotColumn{Name: c.Name, Type: t.Type.FieldByIndex(c.Field).Type.String(), PrimaryKey: c.PrimaryKey})
+			}
+		}
+	}
+	add(t)
+	for _, subtype := range m.registry.subtypes[t.Name] {
+		add(subtype)
+	}
+	return columns
+}
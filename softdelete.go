@@ -0,0 +1,102 @@
+package m
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"time"
+)
+
+// A time.Time or *time.Time field tagged softdelete makes Delete and DeleteWhere set
+// its column to the current time instead of deleting rows:
+//
+//	DeletedAt *time.Time `db:"deleted_at,softdelete"`
+//
+// Query, Get and Preload skip the rows whose column is set, with a condition on it, unless
+// WithDeleted is called. The SQL of Select and the other methods taking queries is run
+// as written. Soft-deleted rows can only be removed with SQL.
+
+// WithDeleted makes the query return soft-deleted rows too.
+func (q *Query) WithDeleted() *Query {
+	q.withDeleted = true
+	return q
+}
+
+// softDeleteColumn returns the column tagged softdelete, or nil.
+func (t *tableMap) softDeleteColumn() *columnMap {
+	for _, c := range t.Columns {
+		if c.SoftDelete {
+			return c
+		}
+	}
+	return nil
+}
+
+// softDelete sets the soft delete column c of the row for thing, and of thing if it is
+// a pointer, to the current time.
+func (m *Mapping) softDelete(ctx context.Context, t *tableMap, thing interface{}, c *columnMap) error {
+	now := time.Now()
+	keyColumns, keyValues := keysForUpdate(thing, t)
+	query, err := sqlUpdateString(t.Name, m.using(ctx, t), []string{c.Name}, keyColumns, m.Type)
+	if err != nil {
+		return err
+	}
+	err = m.versioned(ctx, t, keyCondition(keyColumns, m.Type), keyValues, now, func(m *Mapping) error {
+		_, err := m.exec(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: append([]interface{}{now}, keyValues...), Idempotent: true})
+		return err
+	})
+	if err == nil {
+		if field := reflect.Indirect(reflect.ValueOf(thing)).FieldByIndex(c.Field); field.CanSet() {
+			assign(field, now)
+		}
+	}
+	m.cached(t, func(c *TableCache) { c.removeRow(thing) })
+	return err
+}
+
+// softDeleteWhere sets the soft delete column c of the rows matching condition that
+// aren't deleted yet to the current time.
+func (m *Mapping) softDeleteWhere(ctx context.Context, t *tableMap, c *columnMap, condition string, bindings []interface{}) error {
+	now := time.Now()
+	b := getBuffer()
+	b.WriteString("UPDATE ")
+	writeIdent(b, t.Name, m.Type)
+	b.WriteString(" SET ")
+	writeIdent(b, c.Name, m.Type)
+	b.WriteString(" = ")
+	// the bindings of condition are numbered from the start on PostgreSQL
+	writePlaceholder(b, len(bindings), m.Type)
+	args := append([]interface{}{now}, bindings...)
+	if m.Type == PostgreSQL {
+		args = append(bindings[:len(bindings):len(bindings)], now)
+	}
+	b.WriteString(" WHERE ")
+	if condition != "" {
+		b.WriteString("(" + condition + ") AND ")
+	}
+	writeIdent(b, c.Name, m.Type)
+	b.WriteString(" IS NULL")
+	query := putBuffer(b)
+	err := m.versioned(ctx, t, condition, bindings, now, func(m *Mapping) error {
+		_, err := m.exec(ctx, t, &Statement{Op: OpUpdate, Table: t.Name, SQL: query, Args: args, Idempotent: true})
+		return err
+	})
+	m.cached(t, func(c *TableCache) { c.Purge() })
+	return err
+}
+
+// excludesDeleted reports whether q has to exclude soft-deleted rows.
+func (q *Query) excludesDeleted() bool {
+	return !q.withDeleted && q.t.softDeleteColumn() != nil
+}
+
+// writeNotDeleted writes the condition excluding the soft-deleted rows of q.
+func (q *Query) writeNotDeleted(b *bytes.Buffer) {
+	c := q.t.softDeleteColumn()
+	if len(q.joins) > 0 {
+		writeIdent(b, q.t.Name, q.m.Type)
+		b.WriteByte('.')
+	}
+	writeIdent(b, c.Name, q.m.Type)
+	b.WriteString(" IS NULL")
+}
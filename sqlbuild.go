@@ -0,0 +1,161 @@
+package m
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Statement generation is on the hot path of every call, so the buffers used to build
+// statements are pooled and table and column names are interned at registration.
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns the contents of b as a string and puts b back into the pool.
+func putBuffer(b *bytes.Buffer) string {
+	s := b.String()
+	b.Reset()
+	bufferPool.Put(b)
+	return s
+}
+
+var interned = struct {
+	sync.Mutex
+	strings map[string]string
+}{strings: make(map[string]string)}
+
+func intern(s string) string {
+	interned.Lock()
+	defer interned.Unlock()
+	if i, ok := interned.strings[s]; ok {
+		return i
+	}
+	interned.strings[s] = s
+	return s
+}
+
+// writePlaceholder writes the placeholder for the i'th (zero-based) binding.
+func writePlaceholder(b *bytes.Buffer, i int, dbt DBType) {
+	if dbt == PostgreSQL {
+		var num [20]byte
+		b.WriteByte('$')
+		b.Write(strconv.AppendInt(num[:0], int64(i+1), 10))
+		return
+	}
+	b.WriteByte('?')
+}
+
+// rebind replaces the ? placeholders of query, outside of quoted strings and
+// identifiers, with numbered PostgreSQL placeholders.
+func rebind(query string) string {
+	if strings.IndexByte(query, '?') < 0 {
+		return query
+	}
+	b := getBuffer()
+	var quote byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			writePlaceholder(b, n, PostgreSQL)
+			n++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return putBuffer(b)
+}
+
+// questionMarks returns n comma separated ? placeholders, which the conditions of a
+// Query are written with, see rebind.
+func questionMarks(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n)[:n*3-2]
+}
+
+// writePlaceholders writes n comma separated placeholders for the bindings starting at
+// index start.
+func writePlaceholders(b *bytes.Buffer, start, n int, dbt DBType) {
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writePlaceholder(b, start+i, dbt)
+	}
+}
+
+// writeColumnPlaceholders writes "column = placeholder" for each column, joined by sep,
+// for the bindings starting at index start.
+func writeColumnPlaceholders(b *bytes.Buffer, columns []string, start int, sep string, dbt DBType) {
+	for i, column := range columns {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		writeIdent(b, column, dbt)
+		b.WriteString(" = ")
+		writePlaceholder(b, start+i, dbt)
+	}
+}
+
+// writeIdent writes a table or column name, quoted with backticks on MySQL so that
+// names can be reserved words.
+func writeIdent(b *bytes.Buffer, name string, dbt DBType) {
+	if dbt != MySQL {
+		b.WriteString(name)
+		return
+	}
+	b.WriteByte('`')
+	b.WriteString(strings.Replace(name, "`", "``", -1))
+	b.WriteByte('`')
+}
+
+// quoteIdent returns name quoted like writeIdent, for statements built with fmt.
+func quoteIdent(name string, dbt DBType) string {
+	if dbt != MySQL {
+		return name
+	}
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// quoteIdents returns names quoted like writeIdent and joined by commas.
+func quoteIdents(names []string, dbt DBType) string {
+	if dbt != MySQL {
+		return strings.Join(names, ", ")
+	}
+	b := getBuffer()
+	writeIdents(b, names, ", ", dbt)
+	return putBuffer(b)
+}
+
+// writeIdents writes names joined by sep, quoted by writeIdent.
+func writeIdents(b *bytes.Buffer, names []string, sep string, dbt DBType) {
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		writeIdent(b, name, dbt)
+	}
+}
+
+func writeJoined(b *bytes.Buffer, s []string, sep string) {
+	for i, v := range s {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(v)
+	}
+}
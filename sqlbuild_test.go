@@ -0,0 +1,65 @@
+package m
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSqlUpdateStringPlaceholders(t *testing.T) {
+	for _, tt := range []struct {
+		dbt  DBType
+		want string
+	}{
+		{PostgreSQL, "UPDATE posts SET title = $1, body = $2 WHERE id = $3 AND author = $4"},
+		{Cassandra, "UPDATE posts SET title = ?, body = ? WHERE id = ? AND author = ?"},
+	} {
+		got, err := sqlUpdateString("posts", "", []string{"title", "body"}, []string{"id", "author"}, tt.dbt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("sqlUpdateString(%v) = %q, want %q", tt.dbt, got, tt.want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	got := rebind(`SELECT '?', "a?" FROM t WHERE a = ? AND b IN (?, ?)`)
+	want := `SELECT '?', "a?" FROM t WHERE a = $1 AND b IN ($2, $3)`
+	if got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+}
+
+type rebindPost struct {
+	ID    int64  `db:"id,pk"`
+	Title string `db:"title"`
+}
+
+func TestQueryPlaceholders(t *testing.T) {
+	for _, tt := range []struct {
+		dbt  DBType
+		want string
+	}{
+		{PostgreSQL, "SELECT * FROM posts WHERE title = $1 AND id IN ($2, $3)"},
+		{Cassandra, "SELECT * FROM posts WHERE title = ? AND id IN (?, ?)"},
+	} {
+		m := tt.dbt.NewMapping()
+		m.AddTable("posts", rebindPost{})
+		if got := m.Query(rebindPost{}, "*").Where("title =", "a").In("id", 1, 2).String(); got != tt.want {
+			t.Errorf("Query(%v) = %q, want %q", tt.dbt, got, tt.want)
+		}
+	}
+}
+
+func TestQuerySharedRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.AddTable("posts", rebindPost{})
+	pg, my := r.NewMapping(PostgreSQL), r.NewMapping(MySQL)
+	if got := pg.Query(rebindPost{}, "*").Where("id =", 1).String(); !strings.Contains(got, "$1") {
+		t.Errorf("PostgreSQL query = %q", got)
+	}
+	if got := my.Query(rebindPost{}, "*").Where("id =", 1).String(); !strings.Contains(got, "?") {
+		t.Errorf("MySQL query = %q, built for another database", got)
+	}
+}
@@ -0,0 +1,105 @@
+package m
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A StatsCollector is told about every statement executed by a Mapping once it is done,
+// with how long it took in total, including retries and waiting for a rate limiter,
+// and the error it returned, if any. It can export the numbers to a metrics system.
+type StatsCollector interface {
+	Observe(ctx context.Context, s *Statement, dur time.Duration, err error)
+}
+
+// SetStatsCollector sets the StatsCollector for statements executed by m, nil turns it
+// off.
+func (m *Mapping) SetStatsCollector(c StatsCollector) {
+	m.stats = c
+}
+
+// LatencyBuckets are the upper bounds of the latency histograms of Stats.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Stats is a StatsCollector that counts statements and their latencies per operation
+// and table. It implements expvar.Var, so it can be published as is:
+//
+//	stats := &m.Stats{}
+//	M.SetStatsCollector(stats)
+//	expvar.Publish("db", stats)
+type Stats struct {
+	mu  sync.Mutex
+	ops map[StatsKey]*OpStats
+}
+
+// StatsKey is the operation and table the statements of an OpStats are for.
+type StatsKey struct {
+	Op    Op
+	Table string
+}
+
+// OpStats are the counters and latency histogram of the statements of one operation on
+// one table.
+type OpStats struct {
+	Statements int64
+	Errors     int64
+	Total      time.Duration
+	// Buckets[i] is the number of statements that took at most LatencyBuckets[i], and
+	// the last bucket counts the slower ones.
+	Buckets []int64
+}
+
+func (st *Stats) Observe(ctx context.Context, s *Statement, dur time.Duration, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ops == nil {
+		st.ops = make(map[StatsKey]*OpStats)
+	}
+	key := StatsKey{s.Op, s.Table}
+	o := st.ops[key]
+	if o == nil {
+		o = &OpStats{Buckets: make([]int64, len(LatencyBuckets)+1)}
+		st.ops[key] = o
+	}
+
+	o.Statements++
+	if err != nil {
+		o.Errors++
+	}
+	o.Total += dur
+	o.Buckets[sort.Search(len(LatencyBuckets), func(i int) bool { return dur <= LatencyBuckets[i] })]++
+}
+
+// Snapshot returns a copy of the current stats of each operation and table.
+func (st *Stats) Snapshot() map[StatsKey]OpStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	res := make(map[StatsKey]OpStats, len(st.ops))
+	for key, o := range st.ops {
+		c := *o
+		c.Buckets = append([]int64(nil), o.Buckets...)
+		res[key] = c
+	}
+	return res
+}
+
+// String returns the stats as a JSON object keyed by "OP table", for expvar.
+func (st *Stats) String() string {
+	snapshot := st.Snapshot()
+	res := make(map[string]OpStats, len(snapshot))
+	for key, o := range snapshot {
+		res[key.Op.String()+" "+key.Table] = o
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,204 @@
+package m
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// stmtCache is an LRU cache of prepared statements keyed by their DB and SQL, so that
+// the statements generated over and over by a Mapping are only parsed once per
+// connection by the server. A cached *sql.Stmt is shared by every goroutine using the
+// Mapping: database/sql prepares it on each connection it is executed on the first time
+// and binds later executions to one of those connections, so concurrent executions
+// don't wait on each other. The cache is emptied when the DB of the Mapping is
+// replaced.
+type stmtCache struct {
+	size int
+
+	mu    sync.Mutex
+	db    *sql.DB
+	lru   *list.List // of *cachedStmt, most recently used first
+	items map[stmtKey]*list.Element
+}
+
+type stmtKey struct {
+	db  *sql.DB // m.DB or one of the replicas
+	sql string
+}
+
+type cachedStmt struct {
+	key     stmtKey
+	stmt    *sql.Stmt
+	err     error
+	ready   chan struct{} // closed once stmt or err is set
+	refs    int           // executions that are about to use stmt
+	evicted bool          // stmt is closed once refs drops to zero
+}
+
+// SetStatementCache makes m prepare the statements it executes and keep up to size of
+// them, evicting the least recently used. A size of zero turns the cache off and closes
+// the cached statements.
+func (m *Mapping) SetStatementCache(size int) {
+	if m.stmts != nil {
+		m.stmts.purge(nil)
+	}
+	if size <= 0 {
+		m.stmts = nil
+		return
+	}
+	m.stmts = &stmtCache{size: size, lru: list.New(), items: make(map[stmtKey]*list.Element)}
+}
+
+// prepared returns q with statements executed as cached prepared statements. Statements
+// on connections taken from the pool explicitly are not cached.
+func (m *Mapping) prepared(q querier) querier {
+	if m.stmts == nil {
+		return q
+	}
+	switch q := q.(type) {
+	case *sql.DB:
+		return &stmtQuerier{c: m.stmts, primary: m.DB, db: q}
+	case *sql.Tx:
+		return &stmtQuerier{c: m.stmts, primary: m.DB, db: m.DB, tx: q}
+	}
+	return q
+}
+
+// stmtQuerier executes statements with the prepared statements of c for db, in tx if
+// it is not nil.
+type stmtQuerier struct {
+	c       *stmtCache
+	primary *sql.DB
+	db      *sql.DB
+	tx      *sql.Tx
+}
+
+// Rows keep their statement open until they are closed, so statements only need to be
+// held until ExecContext or QueryContext returns.
+
+func (q *stmtQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, release, err := q.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (q *stmtQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, release, err := q.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (q *stmtQuerier) stmt(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	stmt, release, err := q.c.get(ctx, q.primary, q.db, query)
+	if err != nil || q.tx == nil {
+		return stmt, release, err
+	}
+	return q.tx.StmtContext(ctx, stmt), release, nil
+}
+
+// get returns the prepared statement for query on db, preparing it if it isn't cached.
+// primary is the current m.DB, the cache is emptied when it changes. release must be
+// called when done with the statement.
+func (c *stmtCache) get(ctx context.Context, primary, db *sql.DB, query string) (stmt *sql.Stmt, release func(), err error) {
+	key := stmtKey{db, query}
+	c.mu.Lock()
+	if c.db != primary {
+		c.purgeLocked(primary)
+	}
+	e, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		c.mu.Unlock()
+		// the statement may still be being prepared by another goroutine, which
+		// executions of the same query wait for rather than preparing it again
+		select {
+		case <-cs.ready:
+		case <-ctx.Done():
+			c.release(cs)
+			return nil, nil, ctx.Err()
+		}
+		if cs.err != nil {
+			c.release(cs)
+			return nil, nil, cs.err
+		}
+		return cs.stmt, c.releaser(cs), nil
+	}
+	cs := &cachedStmt{key: key, ready: make(chan struct{}), refs: 1}
+	c.items[key] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.size {
+		c.evict(c.lru.Back())
+	}
+	c.mu.Unlock()
+
+	// prepare without holding the lock, an entry evicted or purged meanwhile is closed
+	// once its executions are done
+	stmt, err = db.PrepareContext(ctx, query)
+
+	c.mu.Lock()
+	cs.stmt, cs.err = stmt, err
+	close(cs.ready)
+	if err != nil && !cs.evicted {
+		// don't cache the error, the next execution prepares the statement again
+		c.evict(c.items[key])
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.release(cs)
+		return nil, nil, err
+	}
+	return stmt, c.releaser(cs), nil
+}
+
+func (c *stmtCache) releaser(cs *cachedStmt) func() {
+	return func() { c.release(cs) }
+}
+
+func (c *stmtCache) release(cs *cachedStmt) {
+	c.mu.Lock()
+	cs.refs--
+	if cs.evicted && cs.refs == 0 {
+		cs.close()
+	}
+	c.mu.Unlock()
+}
+
+// evict removes e from the cache and closes its statement once it is no longer used.
+func (c *stmtCache) evict(e *list.Element) {
+	cs := e.Value.(*cachedStmt)
+	c.lru.Remove(e)
+	delete(c.items, cs.key)
+	cs.evicted = true
+	if cs.refs == 0 {
+		cs.close()
+	}
+}
+
+func (cs *cachedStmt) close() {
+	if cs.stmt != nil {
+		cs.stmt.Close()
+	}
+}
+
+// purge closes every cached statement and makes db the DB of c.
+func (c *stmtCache) purge(db *sql.DB) {
+	c.mu.Lock()
+	c.purgeLocked(db)
+	c.mu.Unlock()
+}
+
+func (c *stmtCache) purgeLocked(db *sql.DB) {
+	for e := c.lru.Front(); e != nil; e = c.lru.Front() {
+		c.evict(e)
+	}
+	c.db = db
+}
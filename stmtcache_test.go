@@ -0,0 +1,116 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// benchDriver is a database/sql driver that does no work, so that the benchmarks
+// measure the overhead of the Mapping. It counts the statements prepared on its
+// connections.
+type benchDriver struct {
+	prepares int64
+}
+
+func (d *benchDriver) Open(string) (driver.Conn, error)             { return &benchConn{d}, nil }
+func (d *benchDriver) Connect(context.Context) (driver.Conn, error) { return &benchConn{d}, nil }
+func (d *benchDriver) Driver() driver.Driver                        { return d }
+
+type benchConn struct{ d *benchDriver }
+
+func (c *benchConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt64(&c.d.prepares, 1)
+	return benchStmt{}, nil
+}
+func (c *benchConn) Close() error              { return nil }
+func (c *benchConn) Begin() (driver.Tx, error) { return benchTx{}, nil }
+
+type benchTx struct{}
+
+func (benchTx) Commit() error   { return nil }
+func (benchTx) Rollback() error { return nil }
+
+type benchStmt struct{}
+
+func (benchStmt) Close() error  { return nil }
+func (benchStmt) NumInput() int { return -1 }
+func (benchStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (benchStmt) Query([]driver.Value) (driver.Rows, error) { return &benchRows{}, nil }
+
+type benchRows struct{ n int }
+
+func (r *benchRows) Columns() []string { return []string{"id", "name"} }
+func (r *benchRows) Close() error      { return nil }
+func (r *benchRows) Next(dest []driver.Value) error {
+	if r.n == 10 {
+		return io.EOF
+	}
+	r.n++
+	dest[0], dest[1] = int64(r.n), "name"
+	return nil
+}
+
+type benchUser struct {
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
+}
+
+func benchMapping(b *testing.B, cache int) (*Mapping, *benchDriver) {
+	d := &benchDriver{}
+	db := sql.OpenDB(d)
+	db.SetMaxIdleConns(16)
+	b.Cleanup(func() { db.Close() })
+	m := PostgreSQL.NewMapping()
+	m.DB = db
+	m.AddTable("users", benchUser{})
+	m.SetStatementCache(cache)
+	return m, d
+}
+
+func benchParallel(b *testing.B, fn func(m *Mapping) error) {
+	for _, bc := range []struct {
+		name  string
+		cache int
+	}{{"NoCache", 0}, {"Cache", 16}} {
+		b.Run(bc.name, func(b *testing.B) {
+			m, d := benchMapping(b, bc.cache)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := fn(m); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt64(&d.prepares))/float64(b.N), "prepares/op")
+		})
+	}
+}
+
+func BenchmarkInsertParallel(b *testing.B) {
+	benchParallel(b, func(m *Mapping) error {
+		return m.Insert(&benchUser{ID: 1, Name: "name"})
+	})
+}
+
+func BenchmarkSelectParallel(b *testing.B) {
+	benchParallel(b, func(m *Mapping) error {
+		_, err := m.Select(benchUser{}, "SELECT * FROM users WHERE id > $1", 0)
+		return err
+	})
+}
+
+func BenchmarkQueryParallel(b *testing.B) {
+	benchParallel(b, func(m *Mapping) error {
+		_, err := m.Query(benchUser{}, "*").Where("id >", 0).Limit(10).Do()
+		return err
+	})
+}
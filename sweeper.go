@@ -0,0 +1,222 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Sweeper periodically deletes rows whose expiry column is in the past from a set of
+// tables, in batches so that sweeping a large backlog doesn't hold locks for long.
+//
+//	s := M.NewSweeper(time.Minute)
+//	s.BatchSize = 1000
+//	s.Add(Session{}, "expires_at")
+//	s.Add(Token{}, "")
+//	s.Start()
+//	defer s.Stop()
+type Sweeper struct {
+	Interval  time.Duration // time between sweeps
+	BatchSize int           // rows deleted per statement, zero deletes all expired rows at once
+	Pause     time.Duration // time between batches of a sweep, limiting the delete rate
+
+	// Errors receives errors that happen while sweeping in the background, if it is not
+	// nil. Errors are dropped if nobody is receiving.
+	Errors chan<- error
+
+	m      *Mapping
+	mu     sync.Mutex
+	tables []*sweepTable
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type sweepTable struct {
+	t      *tableMap
+	column string
+	stats  SweepStats
+}
+
+// SweepStats are the metrics of sweeping one table.
+type SweepStats struct {
+	Sweeps       int
+	Deleted      int64
+	Errors       int
+	LastSweep    time.Time
+	LastDuration time.Duration
+}
+
+// NewSweeper returns a Sweeper that sweeps tables every interval.
+func (m *Mapping) NewSweeper(interval time.Duration) *Sweeper {
+	return &Sweeper{Interval: interval, m: m}
+}
+
+// Add adds the table for thing to s. Rows are deleted when column is in the past, if
+// column is empty the column tagged expires is used.
+func (s *Sweeper) Add(thing interface{}, column string) {
+	t := s.m.lookupTable(thing)
+	if column == "" {
+		if c := t.expiresColumn(); c != nil {
+			column = c.Name
+		}
+	}
+	if t.column(column) == nil {
+		panic(fmt.Sprintf("Unknown expiry column %q for type: %v", column, t.Type))
+	}
+	s.mu.Lock()
+	s.tables = append(s.tables, &sweepTable{t: t, column: column})
+	s.mu.Unlock()
+}
+
+// Start starts sweeping in the background.
+func (s *Sweeper) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-s.stop:
+				case <-ctx.Done():
+				}
+				cancel()
+			}()
+			if err := s.Sweep(ctx); err != nil && s.Errors != nil {
+				select {
+				case s.Errors <- err:
+				default:
+				}
+			}
+			cancel()
+		}
+	}()
+}
+
+// Stop stops sweeping in the background and waits for a running sweep to finish.
+func (s *Sweeper) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Sweep sweeps every table once. It returns the first error, but tries every table.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	s.mu.Lock()
+	tables := append([]*sweepTable(nil), s.tables...)
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, st := range tables {
+		start := time.Now()
+		deleted, err := s.sweepTable(ctx, st)
+
+		s.mu.Lock()
+		st.stats.Sweeps++
+		st.stats.Deleted += deleted
+		st.stats.LastSweep = start
+		st.stats.LastDuration = time.Since(start)
+		if err != nil {
+			st.stats.Errors++
+		}
+		s.mu.Unlock()
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the metrics of each table by table name.
+func (s *Sweeper) Stats() map[string]SweepStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]SweepStats, len(s.tables))
+	for _, st := range s.tables {
+		res[st.t.Name] = st.stats
+	}
+	return res
+}
+
+func (s *Sweeper) sweepTable(ctx context.Context, st *sweepTable) (int64, error) {
+	var total int64
+	for {
+		n, err := s.m.sweep(ctx, st.t, st.column, s.BatchSize)
+		total += n
+		if err != nil || s.BatchSize <= 0 || n < int64(s.BatchSize) {
+			return total, err
+		}
+
+		select {
+		case <-time.After(s.Pause):
+		case <-ctx.Done():
+			return total, ctx.Err()
+		}
+	}
+}
+
+// sweep deletes up to batch rows of t whose column is in the past, or all of them if
+// batch isn't positive, and returns the number of deleted rows.
+func (m *Mapping) sweep(ctx context.Context, t *tableMap, column string, batch int) (int64, error) {
+	query, err := m.sweepQuery(t, column, batch)
+	if err != nil {
+		return 0, err
+	}
+	res, err := m.exec(ctx, t, &Statement{Op: OpDelete, Table: t.Name, SQL: query, Args: []interface{}{time.Now()}, Idempotent: true})
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		m.cached(t, func(c *TableCache) { c.Purge() })
+	}
+	return n, nil
+}
+
+func (m *Mapping) sweepQuery(t *tableMap, column string, batch int) (string, error) {
+	if m.Type == Cassandra {
+		return "", fmt.Errorf("m: Cassandra rows can't be swept, use SetTTL")
+	}
+
+	b := getBuffer()
+	b.WriteString("DELETE FROM ")
+	writeIdent(b, t.Name, m.Type)
+	b.WriteString(" WHERE ")
+	if batch > 0 && m.Type != MySQL {
+		keys := t.primaryKey()
+		if len(keys) == 0 {
+			putBuffer(b)
+			return "", ErrNoPrimaryKey
+		}
+		b.WriteByte('(')
+		writeIdents(b, keys, ", ", m.Type)
+		b.WriteString(") IN (SELECT ")
+		writeIdents(b, keys, ", ", m.Type)
+		b.WriteString(" FROM ")
+		writeIdent(b, t.Name, m.Type)
+		b.WriteString(" WHERE ")
+	}
+	writeIdent(b, column, m.Type)
+	b.WriteString(" < ")
+	writePlaceholder(b, 0, m.Type)
+	switch {
+	case batch > 0 && m.Type == MySQL:
+		// MySQL has no LIMIT in IN subqueries, but limits DELETE itself
+		fmt.Fprintf(b, " LIMIT %d", batch)
+	case batch > 0:
+		fmt.Fprintf(b, " LIMIT %d)", batch)
+	}
+	return putBuffer(b), nil
+}
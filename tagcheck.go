@@ -0,0 +1,184 @@
+package m
+
+import (
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TagError is returned by CheckStructs, Problems has one entry per mistake.
+type TagError struct {
+	Problems []string
+}
+
+func (e *TagError) Error() string {
+	return "m: invalid db tags: " + strings.Join(e.Problems, "; ")
+}
+
+// knownFlags are the flags of db tags and knownOptions the keys of their options, see
+// appendTableColumns and setOption.
+var (
+	knownFlags = map[string]bool{
+		"pk": true, "serialize": true, "discriminator": true, "counter": true, "expires": true,
+		"autoincrement": true, "nullzero": true, "autocreate": true, "autoupdate": true,
+		"softdelete": true, "inline": true, "readonly": true, "createonly": true,
+	}
+	knownOptions = map[string]bool{"collate": true, "charset": true, "serialize": true, "default": true}
+)
+
+// CheckStructs checks the db tags of things, structs or struct pointers, for mistakes
+// that would otherwise only show up when the tables are used: duplicate column names,
+// unknown flags, invalid defaults, primary keys of types that can't be keys and
+// serialized fields that encoding/json can't encode. It returns a *TagError listing the
+// mistakes, or nil. It is meant for tests, with the structs passed to AddTable:
+//
+//	if err := m.CheckStructs(Post{}, User{}); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// The analyzer in the dbtags package runs the same checks with go vet.
+func CheckStructs(things ...interface{}) error {
+	var problems []string
+	for _, thing := range things {
+		problems = append(problems, checkStruct(thing)...)
+	}
+	if len(problems) > 0 {
+		return &TagError{problems}
+	}
+	return nil
+}
+
+func checkStruct(thing interface{}) (problems []string) {
+	typ := reflect.TypeOf(thing)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("%v is not a struct", typ)}
+	}
+	problems = checkFlags(typ, typ)
+	// tags that AddTable rejects panic, which checkFlags has reported already
+	defer func() {
+		if r := recover(); r != nil && len(problems) == 0 {
+			problems = append(problems, fmt.Sprintf("%v: %v", typ, r))
+		}
+	}()
+
+	seen := make(map[string]bool)
+	for _, c := range getTableColumns(thing, typ) {
+		field := typ.FieldByIndex(c.Field)
+		if seen[c.Name] {
+			problems = append(problems, fmt.Sprintf("%v: column %s is mapped twice", typ, c.Name))
+		}
+		seen[c.Name] = true
+		if c.PrimaryKey && !keyType(field.Type) {
+			problems = append(problems, fmt.Sprintf("%v: field %s of type %v can't be a primary key", typ, field.Name, field.Type))
+		}
+		if c.Default != "" {
+			if _, err := parseValue(field.Type, c.Default); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: invalid default %s of field %s: %v", typ, c.Default, field.Name, err))
+			}
+		}
+		if c.CodecName != "" && !registeredCodec(c.CodecName) {
+			problems = append(problems, fmt.Sprintf("%v: unknown codec %s of field %s", typ, c.CodecName, field.Name))
+		}
+		if c.Serialize && (c.CodecName == "" || c.CodecName == "json") {
+			if bad := jsonUnsupported(field.Type, make(map[reflect.Type]bool)); bad != nil {
+				problems = append(problems, fmt.Sprintf("%v: serialized field %s contains %v, which encoding/json can't encode", typ, field.Name, bad))
+			}
+		}
+	}
+	return problems
+}
+
+// checkFlags returns the unknown flags and options of the db tags of the fields of typ,
+// which is root or embedded in it, and the timestamp flags of fields that aren't times,
+// following appendTableColumns.
+func checkFlags(root, typ reflect.Type) []string {
+	var problems []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("db"), ",")
+		if field.Type.Kind() == reflect.Struct && (field.Anonymous && tag[0] == "" || contains(tag[1:], "inline")) {
+			problems = append(problems, checkFlags(root, field.Type)...)
+			continue
+		}
+		for _, flag := range tag[1:] {
+			switch i := strings.IndexByte(flag, '='); {
+			case i > 0 && !knownOptions[flag[:i]]:
+				problems = append(problems, fmt.Sprintf("%v: unknown option %s of field %s", root, flag[:i], field.Name))
+			case i > 0:
+			case !knownFlags[flag]:
+				problems = append(problems, fmt.Sprintf("%v: unknown flag %q of field %s", root, flag, field.Name))
+			case flag == "autocreate" || flag == "autoupdate" || flag == "softdelete":
+				if typ := field.Type; typ != timeType && (typ.Kind() != reflect.Ptr || typ.Elem() != timeType) {
+					problems = append(problems, fmt.Sprintf("%v: field %s tagged %s is of type %v, not time.Time", root, field.Name, flag, typ))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+var (
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// keyType reports whether values of typ can be primary keys, which can't be null or
+// collections.
+func keyType(typ reflect.Type) bool {
+	if typ == bytesType || typ == timeType || typ.Implements(valuerType) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// jsonUnsupported returns a type in typ that encoding/json can't encode, or nil.
+func jsonUnsupported(typ reflect.Type, seen map[reflect.Type]bool) reflect.Type {
+	if seen[typ] {
+		return nil
+	}
+	seen[typ] = true
+	if typ.Implements(jsonMarshalerType) || reflect.PtrTo(typ).Implements(jsonMarshalerType) ||
+		typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType) {
+		return nil
+	}
+	switch typ.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return typ
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return jsonUnsupported(typ.Elem(), seen)
+	case reflect.Map:
+		switch key := typ.Key(); key.Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !key.Implements(textMarshalerType) {
+				return typ
+			}
+		}
+		return jsonUnsupported(typ.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" && !field.Anonymous || field.Tag.Get("json") == "-" {
+				continue
+			}
+			if bad := jsonUnsupported(field.Type, seen); bad != nil {
+				return bad
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package m
+
+import (
+	"context"
+	"reflect"
+)
+
+// Statements are hand-written statements that replace the generated INSERT, UPDATE and
+// DELETE statements of a table, for example to call stored procedures. Fields of the
+// struct are bound by the names of their columns prefixed with a colon. An empty
+// statement keeps the generated one.
+type Statements struct {
+	Insert string
+	Update string
+	Delete string
+}
+
+// SetStatements sets the statements used by Insert, Update and Delete for the table for
+// thing. Update sets the fields of the struct from its data before the statement is
+// bound, so the update statement should set every column it may be called with.
+// InsertMany inserts the rows of a table with an insert statement one at a time, and
+// keys generated by the database are not read back.
+//
+//	M.SetStatements(User{}, m.Statements{
+//		Insert: "SELECT create_user(:id, :email)",
+//		Delete: "UPDATE users SET deleted = true WHERE id = :id",
+//	})
+func (m *Mapping) SetStatements(thing interface{}, s Statements) {
+	t := m.lookupTable(thing)
+	t.Statements = make(map[Op]*namedStatement)
+	for op, query := range map[Op]string{OpInsert: s.Insert, OpUpdate: s.Update, OpDelete: s.Delete} {
+		if query == "" {
+			continue
+		}
+		sql, names := compileNamed(query, m.Type)
+		t.checkColumns(names)
+		t.Statements[op] = &namedStatement{SQL: sql, Columns: names}
+	}
+}
+
+// namedStatement is a statement with named placeholders replaced by positional ones.
+type namedStatement struct {
+	SQL     string
+	Columns []string // column bound to each placeholder
+}
+
+// compileNamed replaces the :name placeholders of query with placeholders for dbt, and
+// returns the names in order. Quoted strings and PostgreSQL :: casts are left alone.
+func compileNamed(query string, dbt DBType) (string, []string) {
+	b := getBuffer()
+	var names []string
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			b.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			j := i + 1
+			for j < len(query) && (isNameStart(query[j]) || query[j] >= '0' && query[j] <= '9') {
+				j++
+			}
+			writePlaceholder(b, len(names), dbt)
+			names = append(names, query[i+1:j])
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return putBuffer(b), names
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// statement returns the statement set with SetStatements for op, or nil.
+func (t *tableMap) statement(op Op) *namedStatement {
+	return t.Statements[op]
+}
+
+// execStatement binds the fields of thing to ns and executes it.
+func (m *Mapping) execStatement(ctx context.Context, t *tableMap, op Op, ns *namedStatement, thing interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	args := make([]interface{}, len(ns.Columns))
+	for i, name := range ns.Columns {
+		c := t.column(name)
+		field := v.FieldByIndex(c.Field)
+		var err error
+		if args[i], err = columnValue(t, c, field); err != nil {
+			return err
+		}
+	}
+	_, err := m.exec(ctx, t, &Statement{Op: op, Table: t.Name, SQL: ns.SQL, Args: args})
+	return err
+}
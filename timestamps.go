@@ -0,0 +1,63 @@
+package m
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Fields tagged autocreate are set to the current time by Insert, InsertMany, Replace
+// and Upsert if they are zero, and are left alone when Upsert updates an existing row.
+// Fields tagged autoupdate are set like autocreate fields by the inserts, and to the
+// current time by every Update unless its data sets them:
+//
+//	CreatedAt time.Time `db:"created_at,autocreate"`
+//	UpdatedAt time.Time `db:"updated_at,autoupdate"`
+//
+// Columns managed by the database can be tagged readonly, which are only scanned, or
+// createonly, which are written by inserts like autocreate columns but never updated.
+// Passing them to Update returns an *UpdateError:
+//
+//	Total   int64  `db:"total,readonly"` // a generated column
+//	OwnerID int64  `db:"owner_id,createonly"`
+
+// checkTimeField panics if field, tagged with flag, isn't a time.Time or *time.Time.
+func checkTimeField(field reflect.StructField, flag string) {
+	if typ := field.Type; typ != timeType && (typ.Kind() != reflect.Ptr || typ.Elem() != timeType) {
+		panic(fmt.Sprintf("Field %s tagged %s is of type %v, not time.Time", field.Name, flag, typ))
+	}
+}
+
+// setTimestamps sets the zero autocreate and autoupdate fields of thing to now. If thing
+// isn't a pointer a modified copy is returned.
+func setTimestamps(t *tableMap, thing interface{}, now time.Time) interface{} {
+	for _, c := range t.Columns {
+		if c.AutoCreate || c.AutoUpdate {
+			thing = setTimeIfZero(t, thing, c, now)
+		}
+	}
+	return thing
+}
+
+// withUpdated returns data with the autoupdate columns of t that it doesn't set set to
+// now.
+func (t *tableMap) withUpdated(data map[string]interface{}, now time.Time) map[string]interface{} {
+	for _, c := range t.Columns {
+		if _, ok := data[c.Name]; c.AutoUpdate && !ok {
+			data = withValue(data, c.Name, now)
+		}
+	}
+	return data
+}
+
+// createdColumns returns the names of the autocreate and createonly columns of t, which
+// are only written by inserts.
+func (t *tableMap) createdColumns() []string {
+	var columns []string
+	for _, c := range t.Columns {
+		if c.AutoCreate || c.CreateOnly {
+			columns = append(columns, c.Name)
+		}
+	}
+	return columns
+}
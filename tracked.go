@@ -0,0 +1,107 @@
+package m
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Tracked remembers the column values of the struct it is embedded in when the struct
+// is selected, so that SaveChanges only writes the columns that changed since. It adds
+// no columns to the table:
+//
+//	type Post struct {
+//		m.Tracked
+//		ID    int64  `db:"id,pk"`
+//		Title string `db:"title"`
+//	}
+type Tracked struct {
+	values []interface{} // the values of the columns, see snapshot
+}
+
+func (tr *Tracked) tracked() *Tracked {
+	return tr
+}
+
+// tracker is implemented by pointers to structs embedding Tracked.
+type tracker interface {
+	tracked() *Tracked
+}
+
+// SaveChanges is like Save but only writes the columns whose fields changed since thing
+// was selected or last saved by SaveChanges, and doesn't write anything if none did. The
+// struct of thing has to embed Tracked, otherwise, or if thing wasn't selected, every
+// column is written like Save does.
+func (m *Mapping) SaveChanges(thing interface{}) error {
+	return m.SaveChangesContext(context.Background(), thing)
+}
+
+// SaveChangesContext is like SaveChanges but runs the statement with ctx.
+func (m *Mapping) SaveChangesContext(ctx context.Context, thing interface{}) (err error) {
+	defer m.recover(&err)
+	t := m.lookupTable(thing)
+	data := t.saved(thing)
+	tr, ok := thing.(tracker)
+	if ok && tr.tracked().values != nil {
+		v := reflect.Indirect(reflect.ValueOf(thing))
+		for i, c := range t.Columns {
+			if _, saved := data[c.Name]; saved && snapshotEqual(tr.tracked().values[i], snapshotValue(c, v.FieldByIndex(c.Field))) {
+				delete(data, c.Name)
+			}
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+	if err = m.update(ctx, t, thing, data, false); err == nil && ok {
+		t.snapshot(thing)
+	}
+	return err
+}
+
+// snapshot records the column values of thing if it embeds Tracked.
+func (t *tableMap) snapshot(thing interface{}) {
+	tr, ok := thing.(tracker)
+	if !ok {
+		return
+	}
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	values := make([]interface{}, len(t.Columns))
+	for i, c := range t.Columns {
+		values[i] = snapshotValue(c, v.FieldByIndex(c.Field))
+	}
+	tr.tracked().values = values
+}
+
+// snapshotValue returns a copy of the value of field that changes to the field don't
+// affect: serialized fields are marshaled, pointers are dereferenced and slices copied.
+func snapshotValue(c *columnMap, field reflect.Value) interface{} {
+	if c.Serialize {
+		data, err := c.Codec.Marshal(field.Interface())
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+	switch field.Kind() {
+	case reflect.Ptr:
+		if field.IsNil() {
+			return nil
+		}
+		return snapshotValue(c, field.Elem())
+	case reflect.Slice:
+		if field.IsNil() {
+			return nil
+		}
+		return reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field).Interface()
+	}
+	return field.Interface()
+}
+
+func snapshotEqual(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
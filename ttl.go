@@ -0,0 +1,154 @@
+package m
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetTTL sets the default time to live of the rows of the table for thing, so that
+// expiring data is declared once instead of on every write. On Cassandra rows are
+// inserted and updated USING TTL. Other databases need a time.Time column tagged
+// expires, which Insert sets to now plus ttl if it is zero, and expired rows are deleted
+// by SweepExpired.
+//
+//	type Session struct {
+//		ID        string    `db:"id,pk"`
+//		ExpiresAt time.Time `db:"expires_at,expires"`
+//	}
+//	M.SetTTL(Session{}, 24*time.Hour)
+func (m *Mapping) SetTTL(thing interface{}, ttl time.Duration) {
+	t := m.lookupTable(thing)
+	if m.Type != Cassandra && t.expiresColumn() == nil {
+		panic(fmt.Sprintf("No expires column for type: %v", t.Type))
+	}
+	t.TTL = ttl
+}
+
+// SweepExpired deletes the rows of the table for thing whose expires column is in the
+// past and returns the number of deleted rows.
+func (m *Mapping) SweepExpired(thing interface{}) (int64, error) {
+	t, err := m.table(thing)
+	if err != nil {
+		return 0, err
+	}
+	c := t.expiresColumn()
+	if c == nil {
+		return 0, fmt.Errorf("m: table %s has no expires column", t.Name)
+	}
+	return m.sweep(context.Background(), t, c.Name, 0)
+}
+
+// ScheduleSweeps starts a Sweeper for every table with a TTL and an expires column that
+// sweeps every interval until the returned stop function is called. Errors are sent to
+// errs if it is not nil.
+func (m *Mapping) ScheduleSweeps(every time.Duration, errs chan<- error) (stop func()) {
+	s := m.NewSweeper(every)
+	s.Errors = errs
+	for _, t := range m.registry.tables {
+		if t.TTL > 0 && t.expiresColumn() != nil {
+			s.Add(reflect.New(t.Type).Interface(), "")
+		}
+	}
+	s.Start()
+	return s.Stop
+}
+
+type writeOptionsKey struct{}
+
+// writeOptions are the Cassandra options of writes, see WithTTL and WithTimestamp.
+type writeOptions struct {
+	ttl       time.Duration
+	timestamp time.Time
+}
+
+// WithTTL returns a copy of ctx whose inserts and updates on Cassandra write rows that
+// expire after ttl, overriding the TTL of the table. It is ignored by other databases.
+//
+//	err := M.InsertContext(m.WithTTL(ctx, time.Hour), &Session{ID: id})
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	o := contextWriteOptions(ctx)
+	o.ttl = ttl
+	return context.WithValue(ctx, writeOptionsKey{}, o)
+}
+
+// WithTimestamp returns a copy of ctx whose inserts and updates on Cassandra are
+// written with timestamp at rather than the time of the write, which decides what
+// concurrent writes win. It is ignored by other databases.
+func WithTimestamp(ctx context.Context, at time.Time) context.Context {
+	o := contextWriteOptions(ctx)
+	o.timestamp = at
+	return context.WithValue(ctx, writeOptionsKey{}, o)
+}
+
+func contextWriteOptions(ctx context.Context) writeOptions {
+	o, _ := ctx.Value(writeOptionsKey{}).(writeOptions)
+	return o
+}
+
+// using returns the Cassandra USING clause for writes to t with ctx.
+func (m *Mapping) using(ctx context.Context, t *tableMap) string {
+	if m.Type != Cassandra {
+		return ""
+	}
+	o := contextWriteOptions(ctx)
+	if o.ttl <= 0 {
+		o.ttl = t.TTL
+	}
+	var clauses []string
+	if o.ttl > 0 {
+		clauses = append(clauses, "TTL "+strconv.FormatInt(int64(o.ttl/time.Second), 10))
+	}
+	if !o.timestamp.IsZero() {
+		// Cassandra timestamps are in microseconds
+		clauses = append(clauses, "TIMESTAMP "+strconv.FormatInt(o.timestamp.UnixNano()/int64(time.Microsecond), 10))
+	}
+	if len(clauses) == 0 {
+		return ""
+	}
+	return "USING " + strings.Join(clauses, " AND ")
+}
+
+// setExpiry sets the expires column of thing to now plus the TTL of t if it is zero. If
+// thing isn't a pointer a modified copy is returned.
+func (m *Mapping) setExpiry(t *tableMap, thing interface{}) interface{} {
+	if m.Type == Cassandra || t.TTL <= 0 {
+		return thing
+	}
+	if c := t.expiresColumn(); c != nil {
+		thing = setTimeIfZero(t, thing, c, time.Now().Add(t.TTL))
+	}
+	return thing
+}
+
+// setTimeIfZero sets the time.Time or *time.Time field of column c of thing to at if it
+// is zero. If thing isn't a pointer a modified copy is returned.
+func setTimeIfZero(t *tableMap, thing interface{}, c *columnMap, at time.Time) interface{} {
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	field := v.FieldByIndex(c.Field)
+	if !field.IsZero() {
+		return thing
+	}
+	if !assignable(at, field.Type()) {
+		return thing
+	}
+	if !v.CanSet() {
+		p := reflect.New(t.Type)
+		p.Elem().Set(v)
+		thing, field = p.Interface(), p.Elem().FieldByIndex(c.Field)
+	}
+	assign(field, at)
+	return thing
+}
+
+func (t *tableMap) expiresColumn() *columnMap {
+	for _, c := range t.Columns {
+		if c.Expires {
+			return c
+		}
+	}
+	return nil
+}
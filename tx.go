@@ -0,0 +1,205 @@
+package m
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+)
+
+// Tx is a transaction started with Mapping.Begin. It has the same Insert, Update,
+// Delete, Select and Query methods as a Mapping, which all run inside the transaction.
+//
+//	tx, err := M.Begin()
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//	if err := tx.Insert(post); err != nil {
+//		return err
+//	}
+//	if err := tx.Update(author, map[string]interface{}{"posts": author.Posts + 1}); err != nil {
+//		return err
+//	}
+//	return tx.Commit()
+type Tx struct {
+	*Mapping
+
+	tx            *sql.Tx
+	afterCommit   []func()
+	afterRollback []func()
+
+	parent     *Tx    // the enclosing transaction of a savepoint
+	savepoint  string // the name of the savepoint, see Savepoint
+	savepoints int    // the number of savepoints started in the transaction
+	cursors    int64  // the number of cursors declared in the transaction, see cursorName
+	open       []*Tx  // savepoints that are neither committed nor rolled back
+	prepared   string // the id of the prepared transaction, see Prepare
+	done       bool
+}
+
+// Begin starts a transaction.
+func (m *Mapping) Begin() (*Tx, error) {
+	return m.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction with the given options. The transaction is rolled back if
+// ctx is cancelled before it is committed.
+func (m *Mapping) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	sqlTx, err := m.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := *m
+	tx := &Tx{Mapping: &c, tx: sqlTx}
+	c.tx = tx
+	return tx, nil
+}
+
+// Savepoint starts a nested transaction inside tx, with SAVEPOINT. Committing it
+// releases the savepoint, and its AfterCommit and AfterRollback functions then wait for
+// the outcome of tx. Rolling it back undoes the statements executed since the savepoint,
+// calls its AfterRollback functions and discards its AfterCommit functions, while tx can
+// go on.
+//
+//	sp, err := tx.Savepoint()
+//	if err != nil {
+//		return err
+//	}
+//	if err := sp.Insert(event); err != nil {
+//		sp.Rollback() // tx still commits the other rows
+//	} else if err := sp.Commit(); err != nil {
+//		return err
+//	}
+func (tx *Tx) Savepoint() (*Tx, error) {
+	return tx.SavepointContext(context.Background())
+}
+
+// SavepointContext is like Savepoint but runs the statement with ctx.
+func (tx *Tx) SavepointContext(ctx context.Context) (*Tx, error) {
+	root := tx
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.savepoints++
+	name := fmt.Sprintf("m_savepoint_%d", root.savepoints)
+	if err := tx.execDDL(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	c := *tx.Mapping
+	sp := &Tx{Mapping: &c, tx: tx.tx, parent: tx, savepoint: name}
+	c.tx = sp
+	tx.open = append(tx.open, sp)
+	return sp, nil
+}
+
+// cursorName returns a name for a cursor of SelectCursor that no other cursor of the
+// transaction has.
+func (tx *Tx) cursorName() string {
+	root := tx
+	for root.parent != nil {
+		root = root.parent
+	}
+	return fmt.Sprintf("m_cursor_%d", atomic.AddInt64(&root.cursors, 1))
+}
+
+// AfterCommit adds fn to the functions called after the transaction is committed, in
+// the order they were added, for side effects like sending emails or events that must
+// not happen if the transaction is rolled back.
+func (tx *Tx) AfterCommit(fn func()) {
+	tx.afterCommit = append(tx.afterCommit, fn)
+}
+
+// AfterRollback adds fn to the functions called after the transaction is rolled back,
+// or fails to commit, in the order they were added.
+func (tx *Tx) AfterRollback(fn func()) {
+	tx.afterRollback = append(tx.afterRollback, fn)
+}
+
+// Commit commits the transaction and calls its AfterCommit functions. If the commit
+// fails, the AfterRollback functions are called instead.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	if err := tx.commitOpen(); err != nil {
+		return err
+	}
+	tx.done = true
+	if tx.parent != nil {
+		if err := tx.execDDL(context.Background(), "RELEASE SAVEPOINT "+tx.savepoint); err != nil {
+			tx.rolledBack()
+			return err
+		}
+		// the released statements are committed or rolled back with the parent
+		tx.parent.afterCommit = append(tx.parent.afterCommit, tx.afterCommit...)
+		tx.parent.afterRollback = append(tx.parent.afterRollback, tx.afterRollback...)
+		tx.afterCommit, tx.afterRollback = nil, nil
+		return nil
+	}
+	if tx.prepared != "" {
+		if err := tx.outside().execDDL(context.Background(), "COMMIT PREPARED "+quoteString(tx.prepared)); err != nil {
+			// the prepared transaction is still there to be committed or rolled back
+			tx.done = false
+			return err
+		}
+	} else if err := tx.tx.Commit(); err != nil {
+		tx.rolledBack()
+		return err
+	}
+	fns := tx.afterCommit
+	tx.afterCommit, tx.afterRollback = nil, nil
+	for _, fn := range fns {
+		fn()
+	}
+	return nil
+}
+
+// Rollback aborts the transaction and calls its AfterRollback functions. Calling
+// Rollback after Commit does nothing, so it can be deferred right after Begin or
+// Savepoint.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	var err error
+	if tx.parent != nil {
+		err = tx.execDDL(context.Background(), "ROLLBACK TO SAVEPOINT "+tx.savepoint)
+	} else if tx.prepared != "" {
+		err = tx.outside().execDDL(context.Background(), "ROLLBACK PREPARED "+quoteString(tx.prepared))
+	} else if err = tx.tx.Rollback(); err == sql.ErrTxDone {
+		err = nil
+	}
+	tx.rolledBack()
+	return err
+}
+
+// commitOpen commits the savepoints of tx that are still open, which are committed with
+// tx.
+func (tx *Tx) commitOpen() error {
+	for i := len(tx.open) - 1; i >= 0; i-- {
+		if err := tx.open[i].Commit(); err != nil && err != sql.ErrTxDone {
+			return err
+		}
+	}
+	tx.open = nil
+	return nil
+}
+
+// rolledBack discards the AfterCommit functions of tx and calls its AfterRollback
+// functions, and those of its open savepoints first.
+func (tx *Tx) rolledBack() {
+	for i := len(tx.open) - 1; i >= 0; i-- {
+		if sp := tx.open[i]; !sp.done {
+			sp.done = true
+			sp.rolledBack()
+		}
+	}
+	tx.open = nil
+	fns := tx.afterRollback
+	tx.afterCommit, tx.afterRollback = nil, nil
+	for _, fn := range fns {
+		fn()
+	}
+}
@@ -0,0 +1,65 @@
+package m
+
+import "reflect"
+
+// Validator is implemented by structs that check their fields before they are written.
+// Validate is called by Insert, InsertMany, Replace, Upsert and InsertIfNotExists after
+// the BeforeInsert hook and the timestamps and defaults have been set, and by Update,
+// UpdateKey and UpdateIf with the updated fields. An error is returned as it is and
+// nothing is executed.
+type Validator interface {
+	Validate() error
+}
+
+// A ValidatorFunc validates thing, a pointer to a struct about to be written, for
+// example with the validation tags of a validation package.
+type ValidatorFunc func(thing interface{}) error
+
+// SetValidator sets the ValidatorFunc called after the Validate method of the structs
+// written by m, nil removes it.
+//
+//	v := validator.New()
+//	M.SetValidator(func(thing interface{}) error { return v.Struct(thing) })
+func (m *Mapping) SetValidator(v ValidatorFunc) {
+	m.validator = v
+}
+
+// validates reports whether things written to t are validated.
+func (m *Mapping) validates(t *tableMap) bool {
+	return m.validator != nil || reflect.PtrTo(t.Type).Implements(validatorType)
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// validate calls the Validate method of thing, if any, and the validator of m.
+func (m *Mapping) validate(thing interface{}) error {
+	if v, ok := thing.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	if m.validator != nil {
+		return m.validator(thing)
+	}
+	return nil
+}
+
+// updateValid assigns data to thing like updateAndGetSqlColumnsValues and validates the
+// result, restoring thing if it isn't valid.
+func (m *Mapping) updateValid(thing interface{}, t *tableMap, data map[string]interface{}) ([]string, []interface{}, error) {
+	if !m.validates(t) {
+		return updateAndGetSqlColumnsValues(thing, t, data)
+	}
+	v := reflect.Indirect(reflect.ValueOf(thing))
+	old := reflect.New(v.Type()).Elem()
+	old.Set(v)
+	columns, values, err := updateAndGetSqlColumnsValues(thing, t, data)
+	if err == nil {
+		err = m.validate(thing)
+	}
+	if err != nil {
+		v.Set(old)
+		return nil, nil, err
+	}
+	return columns, values, nil
+}
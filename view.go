@@ -0,0 +1,44 @@
+package m
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddView adds a PostgreSQL materialized view to struct mapping to a Registry. Views can
+// be queried like tables but Insert and Update return ErrReadOnly.
+//
+//	r.AddView("monthly_sales", MonthlySales{})
+func (r *Registry) AddView(name string, thing interface{}) {
+	r.AddTable(name, thing)
+	r.tables[tableType(thing)].View = true
+}
+
+// AddView adds a materialized view to struct mapping to a Mapping, see Registry.AddView.
+func (m *Mapping) AddView(name string, thing interface{}) {
+	m.registry.AddView(name, thing)
+}
+
+// RefreshView refreshes the materialized view name, which must have been added with
+// AddView. A concurrent refresh doesn't lock out reads but requires a unique index on
+// the view.
+func (m *Mapping) RefreshView(name string, concurrently bool) error {
+	if m.Type != PostgreSQL {
+		return fmt.Errorf("m: materialized views are only supported on PostgreSQL")
+	}
+	var view *tableMap
+	for _, t := range m.registry.tables {
+		if t.View && t.Name == name {
+			view = t
+		}
+	}
+	if view == nil {
+		return fmt.Errorf("m: unknown materialized view %s", name)
+	}
+
+	query := "REFRESH MATERIALIZED VIEW "
+	if concurrently {
+		query += "CONCURRENTLY "
+	}
+	return m.execDDL(context.Background(), query+view.Name)
+}